@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type RangeKind int
+
+const (
+	Charwise RangeKind = iota
+	Linewise
+	Blockwise
+)
+
+// A span of text between two positions. Charwise ranges end before
+// `end`, unless they are inclusive. Linewise ranges cover whole lines.
+type TextRange struct {
+	start     Position
+	end       Position
+	kind      RangeKind
+	inclusive bool
+}
+
+func (p Position) isBefore(other Position) bool {
+	return p.y < other.y || (p.y == other.y && p.x < other.x)
+}
+
+// Creating a range between two positions, in whichever order they're given
+func newTextRange(a, b Position, kind RangeKind, inclusive bool) TextRange {
+	if b.isBefore(a) {
+		a, b = b, a
+	}
+	return TextRange{start: a, end: b, kind: kind, inclusive: inclusive}
+}
+
+func (r TextRange) isEmpty() bool {
+	return r.kind == Charwise && !r.inclusive && r.start == r.end
+}
+
+func runeCount(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func firstNonBlank(line string) int {
+	for i, r := range []rune(line) {
+		if !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return max(runeCount(line)-1, 0)
+}
+
+func leadingWhitespace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// Returning the rune index just past the end of a charwise range,
+// within the line that it ends on
+func (b *Buffer) exclusiveEndX(r TextRange) int {
+	endX := r.end.x
+	if r.inclusive {
+		endX++
+	}
+	return min(endX, runeCount(b.lineContent(r.end.y)))
+}
+
+func (b *Buffer) textInRange(r TextRange) []string {
+	if r.kind == Linewise {
+		text := []string{}
+		for y := r.start.y; y <= r.end.y; y++ {
+			text = append(text, b.lineContent(y))
+		}
+		return text
+	}
+
+	first := []rune(b.lineContent(r.start.y))
+	startX := min(r.start.x, len(first))
+	endX := b.exclusiveEndX(r)
+
+	if r.start.y == r.end.y {
+		return []string{string(first[startX:max(endX, startX)])}
+	}
+
+	text := []string{string(first[startX:])}
+	for y := r.start.y + 1; y < r.end.y; y++ {
+		text = append(text, b.lineContent(y))
+	}
+	last := []rune(b.lineContent(r.end.y))
+	text = append(text, string(last[:endX]))
+
+	return text
+}
+
+func (b *Buffer) deleteRange(r TextRange) {
+	if r.kind == Linewise {
+		for y := r.end.y; y >= r.start.y; y-- {
+			b.removeLine(y)
+		}
+
+		// Always keeping one line in the buffer
+		if len(b.lines) == 0 {
+			b.insertLine(0, "")
+		}
+		return
+	}
+
+	first := []rune(b.lineContent(r.start.y))
+	last := []rune(b.lineContent(r.end.y))
+	startX := min(r.start.x, len(first))
+	endX := max(b.exclusiveEndX(r), 0)
+
+	if r.start.y == r.end.y {
+		endX = max(endX, startX)
+	}
+
+	b.updateLine(r.start.y, string(first[:startX])+string(last[endX:]))
+
+	for y := r.end.y; y > r.start.y; y-- {
+		b.removeLine(y)
+	}
+}
+
+// Inserting charwise text at a position, splitting the line if the text
+// has several lines. Returning the position just after the inserted text.
+func (b *Buffer) insertText(pos Position, text []string) Position {
+	line := []rune(b.lineContent(pos.y))
+	x := min(pos.x, len(line))
+	left := string(line[:x])
+	right := string(line[x:])
+
+	if len(text) == 1 {
+		b.updateLine(pos.y, left+text[0]+right)
+		return Position{x + runeCount(text[0]), pos.y}
+	}
+
+	b.updateLine(pos.y, left+text[0])
+	for i, content := range text[1 : len(text)-1] {
+		b.insertLine(pos.y+1+i, content)
+	}
+
+	lastY := pos.y + len(text) - 1
+	lastLine := text[len(text)-1]
+	b.insertLine(lastY, lastLine+right)
+
+	return Position{runeCount(lastLine), lastY}
+}
+
+func (b *Buffer) insertLines(lineNum int, lines []string) {
+	for i, content := range lines {
+		b.insertLine(lineNum+i, content)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A way of reaching the system clipboard, which the `+` and `*` registers use
+type ClipboardProvider interface {
+	name() string
+	copy(text string) error
+	paste() (string, error)
+}
+
+// Keeping the clipboard inside the program, for when nothing else is available
+type MemoryClipboard struct {
+	text string
+}
+
+func (c *MemoryClipboard) name() string { return "memory" }
+
+func (c *MemoryClipboard) copy(text string) error {
+	c.text = text
+	return nil
+}
+
+func (c *MemoryClipboard) paste() (string, error) {
+	return c.text, nil
+}
+
+// Terminals cap how long an OSC 52 sequence can be,
+// and this is around the smallest common limit
+const osc52MaxEncodedLength = 100000
+
+// Copying by asking the terminal to set the clipboard with an OSC 52 escape
+// sequence, which works even over ssh. Terminals rarely allow reading the
+// clipboard back, so pasting returns what was last copied.
+type OSC52Clipboard struct {
+	write func(sequence string)
+	last  string
+}
+
+func (c *OSC52Clipboard) name() string { return "osc52" }
+
+func (c *OSC52Clipboard) copy(text string) error {
+	encoded := base64.StdEncoding.EncodeToString([]byte(text))
+	if len(encoded) > osc52MaxEncodedLength {
+		return fmt.Errorf("Too much text for the terminal's clipboard: %d bytes", len(text))
+	}
+
+	c.write(osc52Sequence(encoded))
+	c.last = text
+	return nil
+}
+
+func (c *OSC52Clipboard) paste() (string, error) {
+	return c.last, nil
+}
+
+// Building the sequence that sets the clipboard (`c`) to base64 encoded text
+func osc52Sequence(encoded string) string {
+	return "\x1b]52;c;" + encoded + "\x07"
+}
+
+// Copying and pasting by running a clipboard tool, like xclip
+type CommandClipboard struct {
+	tool      string
+	copyArgs  []string
+	pasteTool string
+	pasteArgs []string
+}
+
+func (c *CommandClipboard) name() string { return c.tool }
+
+// Only stdin is connected, since tools like xclip fork a child that keeps
+// the selection alive, and it would hold an output pipe open until the
+// clipboard changes again, leaving the copy waiting for it
+func (c *CommandClipboard) copy(text string) error {
+	cmd := exec.Command(c.tool, c.copyArgs...)
+	cmd.Stdin = strings.NewReader(text)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to copy with %s: %v", c.tool, err)
+	}
+	return nil
+}
+
+func (c *CommandClipboard) paste() (string, error) {
+	output, err := exec.Command(c.pasteTool, c.pasteArgs...).Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to paste with %s: %v", c.pasteTool, err)
+	}
+	return string(output), nil
+}
+
+var clipboardTools = map[string]*CommandClipboard{
+	"wl-copy": {
+		tool:      "wl-copy",
+		pasteTool: "wl-paste",
+		pasteArgs: []string{"--no-newline"},
+	},
+	"xclip": {
+		tool:      "xclip",
+		copyArgs:  []string{"-selection", "clipboard", "-in"},
+		pasteTool: "xclip",
+		pasteArgs: []string{"-selection", "clipboard", "-out"},
+	},
+	"xsel": {
+		tool:      "xsel",
+		copyArgs:  []string{"--clipboard", "--input"},
+		pasteTool: "xsel",
+		pasteArgs: []string{"--clipboard", "--output"},
+	},
+}
+
+func isOnPath(tool string) bool {
+	_, err := exec.LookPath(tool)
+	return err == nil
+}
+
+// Finding a clipboard tool for the running display server,
+// or falling back to OSC 52 when there is none
+func (prog *Program[T]) detectClipboard() ClipboardProvider {
+	if os.Getenv("WAYLAND_DISPLAY") != "" && isOnPath("wl-copy") && isOnPath("wl-paste") {
+		return clipboardTools["wl-copy"]
+	}
+	if os.Getenv("DISPLAY") != "" {
+		for _, tool := range []string{"xclip", "xsel"} {
+			if isOnPath(tool) {
+				return clipboardTools[tool]
+			}
+		}
+	}
+	return &OSC52Clipboard{write: prog.term.writeSequence}
+}
+
+// Returning the clipboard provider that the clipboard setting asks
+// for, making it the first time it's needed, or after the setting changed
+func (prog *Program[T]) clipboard() ClipboardProvider {
+	s := &prog.state
+	wanted := prog.settings.clipboardprovider
+
+	if s.clipboard != nil && s.clipboardSetting == wanted {
+		return s.clipboard
+	}
+
+	switch wanted {
+	case "osc52":
+		s.clipboard = &OSC52Clipboard{write: prog.term.writeSequence}
+	case "memory":
+		s.clipboard = &MemoryClipboard{}
+	case "auto":
+		s.clipboard = prog.detectClipboard()
+	default:
+		s.clipboard = clipboardTools[wanted]
+	}
+
+	s.clipboardSetting = wanted
+	return s.clipboard
+}
+
+// Turning a register into plain text, where whole lines end with a newline
+func (r Register) clipboardText() string {
+	text := strings.Join(r.text, "\n")
+	if r.kind == Linewise {
+		text += "\n"
+	}
+	return text
+}
+
+func (prog *Program[T]) copyToClipboard(reg Register) {
+	prog.state.registers.clipboard = reg
+
+	if err := prog.clipboard().copy(reg.clipboardText()); err != nil {
+		prog.setError(err)
+	}
+}
+
+// Reading the clipboard as a register. Text that was copied from here keeps
+// the kind it had, and other text is linewise if it ends with a newline.
+func (prog *Program[T]) pasteFromClipboard() Register {
+	text, err := prog.clipboard().paste()
+	if err != nil {
+		prog.setError(err)
+		return Register{}
+	}
+
+	copied := prog.state.registers.clipboard
+	if !copied.isEmpty() && copied.clipboardText() == text {
+		return copied
+	}
+	if text == "" {
+		return Register{}
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if strings.HasSuffix(text, "\n") {
+		return Register{text: strings.Split(strings.TrimSuffix(text, "\n"), "\n"), kind: Linewise}
+	}
+	return Register{text: strings.Split(text, "\n"), kind: Charwise}
+}
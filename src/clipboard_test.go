@@ -0,0 +1,141 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClipboard struct {
+	text   string
+	copies []string
+}
+
+func (c *fakeClipboard) name() string { return "fake" }
+
+func (c *fakeClipboard) copy(text string) error {
+	c.text = text
+	c.copies = append(c.copies, text)
+	return nil
+}
+
+func (c *fakeClipboard) paste() (string, error) {
+	return c.text, nil
+}
+
+func testingProgramWithClipboard(buf string) (Program[MockTerminal], *fakeClipboard) {
+	p := testingProgramFromBuf(buf)
+	clipboard := &fakeClipboard{}
+	p.settings.clipboardprovider = "fake"
+	p.state.clipboard = clipboard
+	p.state.clipboardSetting = "fake"
+	return p, clipboard
+}
+
+func TestYankToClipboardRegister(t *testing.T) {
+	p, clipboard := testingProgramWithClipboard("foo bar\nbaz")
+	p.processKeys("\"+yiw")
+	if clipboard.text != "foo" {
+		t.Errorf("wanted clipboard to hold %q, got %q", "foo", clipboard.text)
+	}
+
+	p.processKeys("\"*yj")
+	if clipboard.text != "foo bar\nbaz\n" {
+		t.Errorf("wanted clipboard to hold both lines, got %q", clipboard.text)
+	}
+}
+
+func TestPutFromClipboardRegister(t *testing.T) {
+	p, clipboard := testingProgramWithClipboard("foo")
+	clipboard.text = "bar"
+	p.processKeys("\"+p")
+	p.assertBufferContent(t, "fbaroo")
+
+	// Text ending with a newline is put as whole lines
+	clipboard.text = "x\ny\n"
+	p.processKeys("\"+p")
+	p.assertBufferContent(t, "fbaroo", "x", "y")
+}
+
+func TestClipboardKeepsKindOfCopiedText(t *testing.T) {
+	p, _ := testingProgramWithClipboard("ab\ncd")
+	p.processKeys("l\x16j\"+y$\"+p")
+	p.assertBufferContent(t, "abb", "cdd")
+}
+
+func TestUnnamedClipboardSetting(t *testing.T) {
+	p, clipboard := testingProgramWithClipboard("foo\nbar")
+	p.processKeys(":set unnamedclipboard\n")
+	p.processKeys("yy")
+	if clipboard.text != "foo\n" {
+		t.Errorf("wanted yy to copy to the clipboard, got %q", clipboard.text)
+	}
+
+	// Deletes leave the clipboard alone, just like the unnamed register
+	p.processKeys("jdd")
+	if len(clipboard.copies) != 1 {
+		t.Errorf("wanted dd not to copy, got copies %q", clipboard.copies)
+	}
+
+	clipboard.text = "baz"
+	p.processKeys("p")
+	p.assertBufferContent(t, "fbazoo")
+}
+
+func TestOSC52Clipboard(t *testing.T) {
+	p := testingProgramFromBuf("hello\nworld")
+	p.settings.clipboardprovider = "osc52"
+	p.processKeys("\"+yiw")
+
+	want := []string{"\x1b]52;c;aGVsbG8=\x07"}
+	if got := *p.term.sequences; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("wanted sequences %q, got %q", want, got)
+	}
+
+	p.processKeys("\"+yj")
+	if got := *p.term.sequences; len(got) != 2 || got[1] != "\x1b]52;c;aGVsbG8Kd29ybGQK\x07" {
+		t.Errorf("wanted a linewise OSC 52 sequence, got %q", got)
+	}
+
+	// Pasting gives back what was copied, since the terminal can't be asked
+	p.processKeys("G\"+P")
+	p.assertBufferContent(t, "hello", "hello", "world", "world")
+}
+
+func TestClipboardProviderSetting(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":set clipboardprovider=memory\n")
+	if name := p.clipboard().name(); name != "memory" {
+		t.Errorf("wanted memory clipboard, got %s", name)
+	}
+
+	p.processKeys(":set cbp=nope\n")
+	if !p.state.statusIsError || p.settings.clipboardprovider != "memory" {
+		t.Errorf("wanted an invalid provider to be rejected")
+	}
+}
+
+func TestCommandClipboard(t *testing.T) {
+	if !isOnPath("sh") {
+		t.Skip("needs sh")
+	}
+	path := filepath.Join(t.TempDir(), "clipboard")
+
+	// Like xclip, the tool leaves a child running in the background,
+	// which copying shouldn't wait for
+	c := &CommandClipboard{tool: "sh", copyArgs: []string{"-c", "cat > \"$0\"; sleep 5 &", path}}
+	start := time.Now()
+	if err := c.copy("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("wanted the copy to return without waiting on the child, took %v", elapsed)
+	}
+	assertFileContent(t, path, "hello")
+
+	c = &CommandClipboard{tool: "sh", copyArgs: []string{"-c", "exit 3"}}
+	if err := c.copy("hello"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("wanted the exit status reported, got %v", err)
+	}
+}
@@ -1,25 +1,77 @@
 package main
 
+// Columns are counted the way the terminal draws them, so a tab takes
+// up tabstop columns, and wide chars like CJK take up two
 func getVisualX(line string, logicalX int, settings *Settings) int {
+	runes := []rune(line)
 	result := 0
-	for i := 0; i < min(logicalX, len(line)); i++ {
-		if line[i] == '\t' {
-			result += settings.tabstop
-		} else {
-			result += 1
-		}
+	for i := 0; i < min(logicalX, len(runes)); i++ {
+		result += runeWidth(runes[i], settings)
 	}
 	return result
 }
 
+// Returning how many columns a rune takes up on screen
+func runeWidth(r rune, settings *Settings) int {
+	if r == '\t' {
+		return settings.tabstop
+	}
+	return charWidth(r)
+}
+
+// The ranges of chars that terminals draw two columns wide, which are
+// the East Asian Wide and Fullwidth chars, and most emoji
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x2E80, 0x303E},   // CJK radicals and punctuation
+	{0x3041, 0x33FF},   // Kana, and CJK compatibility
+	{0x3400, 0x4DBF},   // CJK Extension A
+	{0x4E00, 0x9FFF},   // CJK Unified Ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xAC00, 0xD7A3},   // Hangul Syllables
+	{0xF900, 0xFAFF},   // CJK Compatibility Ideographs
+	{0xFE30, 0xFE4F},   // CJK Compatibility Forms
+	{0xFF00, 0xFF60},   // Fullwidth Forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x1F300, 0x1F64F}, // Pictographs and emoticons
+	{0x1F680, 0x1F6FF}, // Transport and map symbols
+	{0x1F900, 0x1F9FF}, // Supplemental symbols and pictographs
+	{0x20000, 0x2FFFD}, // CJK Extensions B and later
+	{0x30000, 0x3FFFD},
+}
+
+// Returning how many columns a char other than a tab takes up
+func charWidth(r rune) int {
+	for _, wide := range wideRanges {
+		if r < wide[0] {
+			break
+		}
+		if r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// Returning how many columns text takes up when drawn as it is,
+// like the command line, where tabs aren't expanded
+func textWidth(runes []rune) int {
+	width := 0
+	for _, r := range runes {
+		width += charWidth(r)
+	}
+	return width
+}
+
 func getLogicalXWithVisualX(line string, visualX int, settings *Settings) int {
+	runes := []rune(line)
 	newLogicalX := 0
 	newVisualX := 0
 
 	// Incrementing newLogicalX until another increment would
 	// exceed the previous visualCursorX
 	for {
-		if newLogicalX+1 >= len(line) {
+		if newLogicalX+1 >= len(runes) {
 			break
 		}
 
@@ -27,15 +79,7 @@ func getLogicalXWithVisualX(line string, visualX int, settings *Settings) int {
 			break
 		}
 
-		visualXChunk := 0
-
-		isTab := line[newLogicalX] == '\t'
-
-		if isTab {
-			visualXChunk += settings.tabstop
-		} else {
-			visualXChunk += 1
-		}
+		visualXChunk := runeWidth(runes[newLogicalX], settings)
 
 		if newVisualX+visualXChunk > visualX {
 			break
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strconv"
+)
+
+// Remembering the keys of the last change, so that `.` can replay them
+// through the same mode handlers that ran them the first time. A change
+// is a normal mode command that changed the buffer, or one that started
+// an insert session, together with every key typed until leaving it.
+type DotRepeat struct {
+	// The keys of the command being typed, and which of them were counts
+	keys      []rune
+	countKeys []bool
+
+	// Set while an insert session that belongs to a change is going on
+	inserting bool
+
+	// The last complete change
+	last      []rune
+	lastCount []bool
+
+	// Set while `.` is replaying, so the replay isn't recorded over itself
+	replaying bool
+
+	// Set by the mode handlers, about the key or command that just ran
+	isCountKey      bool
+	skipCommand     bool
+	commandHasCount bool
+}
+
+func (d *DotRepeat) noteCommand(notRepeatable bool, hasCount bool) {
+	if d.replaying {
+		return
+	}
+	d.skipCommand = notRepeatable
+	d.commandHasCount = hasCount
+}
+
+// Recording a key after it was handled, and deciding whether the
+// keys so far make up a change once a command is done
+func (prog *Program[T]) recordChangeKey(input rune, modeBefore ProgramMode) {
+	d := &prog.state.dot
+	isCountKey := d.isCountKey
+	skipCommand := d.skipCommand
+	d.isCountKey = false
+	d.skipCommand = false
+
+	if d.replaying {
+		return
+	}
+
+	if isTypingMode(modeBefore) && d.inserting {
+		d.keys = append(d.keys, input)
+		d.countKeys = append(d.countKeys, false)
+
+		if !isTypingMode(prog.state.currentMode) {
+			d.inserting = false
+			d.finishChange()
+		}
+		return
+	}
+
+	// Changes made in visual mode are not repeated
+	if modeBefore != NormalMode {
+		d.keys = nil
+		d.countKeys = nil
+		return
+	}
+
+	d.keys = append(d.keys, input)
+	d.countKeys = append(d.countKeys, isCountKey)
+
+	// Waiting for the rest of the command
+	if !prog.state.normalCommand.isIdle() {
+		return
+	}
+
+	switch {
+	case skipCommand:
+		d.keys = nil
+		d.countKeys = nil
+	case isTypingMode(prog.state.currentMode):
+		d.inserting = true
+	case prog.state.currentMode == NormalMode && len(prog.getActiveBuffer().history.pending) > 0:
+		d.finishChange()
+	default:
+		d.keys = nil
+		d.countKeys = nil
+	}
+}
+
+// Whether keys typed in a mode are text, which belongs to the
+// change that started it, like an insert or a replace
+func isTypingMode(mode ProgramMode) bool {
+	return mode == InsertMode || mode == ReplaceMode
+}
+
+func (d *DotRepeat) finishChange() {
+	d.last = d.keys
+	d.lastCount = d.countKeys
+	d.keys = nil
+	d.countKeys = nil
+}
+
+// Replaying the last change at the cursor. A count replaces the count
+// that the change was typed with, and is put before the command's keys.
+func (prog *Program[T]) repeatLastChange(count int) {
+	d := &prog.state.dot
+	if len(d.last) == 0 || d.replaying {
+		return
+	}
+
+	keys := d.last
+	if d.commandHasCount {
+		keys = []rune{}
+		countInserted := false
+
+		for i, key := range d.last {
+			if d.lastCount[i] {
+				continue
+			}
+
+			// Keeping a picked register, like `"a`, before the new count
+			isRegisterPrefix := i < 2 && d.last[0] == '"'
+			if !countInserted && !isRegisterPrefix {
+				keys = append(keys, []rune(strconv.Itoa(count))...)
+				countInserted = true
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	d.replaying = true
+	defer func() { d.replaying = false }()
+
+	for _, key := range keys {
+		dispatchInput(prog, key)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRepeatDelete(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a b c d", "dw.", "c d"},
+		{"a\nb\nc\nd", "dd.", "c\nd"},
+		{"abcdef", "x..", "def"},
+		{"a b c d e f", "2dw.", "e f"},
+		{"a b c d e f g", "2dw3.", "f g"},
+		{"a b c d e f", "d2w.", "e f"},
+		{"a b c d e f", "d2w1.", "d e f"},
+		{"a.b.c", "dt..", ".c"},
+	})
+}
+
+func TestRepeatInsert(t *testing.T) {
+	p := testingProgramFromBuf("a\nb")
+	p.processKeys("ix\x1bj.")
+	p.assertBufferContent(t, "xa", "xb")
+
+	p = testingProgramFromBuf("foo bar\nbaz qux")
+	p.processKeys("cwnew\x1bj0.")
+	p.assertBufferContent(t, "new bar", "new qux")
+}
+
+func TestRepeatIgnoresMotionsAndUndo(t *testing.T) {
+	p := testingProgramFromBuf("abcdef")
+	p.processKeys("xlu.")
+	p.assertBufferContent(t, "bcdef")
+
+	// Yanking changes nothing, so `.` still repeats the delete
+	p = testingProgramFromBuf("abcdef")
+	p.processKeys("xyl.")
+	p.assertBufferContent(t, "cdef")
+}
+
+func TestRepeatIsOneUndoStep(t *testing.T) {
+	p := testingProgramFromBuf("a b c d e")
+	p.processKeys("dw3.u")
+	p.assertBufferContent(t, "b c d e")
+}
+
+func TestRepeatWithNothingToRepeat(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys(".")
+	p.assertBufferContent(t, "abc")
+}
+
+func TestRepeatKeepsRegister(t *testing.T) {
+	p := testingProgramFromBuf("a b c")
+	p.processKeys("\"Adw.")
+	if reg := p.state.registers.named[0]; len(reg.text) != 1 || reg.text[0] != "a b " {
+		t.Errorf("wanted register a to collect both deletes, got %q", reg.text)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Something for the main loop to handle. Keys are one kind of
+// event among several, which all arrive through the same channel.
+type Event interface {
+	isEvent()
+}
+
+// A key typed on the keyboard, or played back
+type KeyEvent struct {
+	key rune
+}
+
+// The terminal changed size
+type ResizeEvent struct{}
+
+// Sent on an interval, for things that happen between keys
+type TickEvent struct {
+	time time.Time
+}
+
+// A file that's open in a buffer was changed by something else
+type FileChangedEvent struct {
+	path string
+}
+
+// The result of work that a JobProducer ran in the background
+type JobOutputEvent struct {
+	job    string
+	output string
+	err    error
+}
+
+// Text that was pasted into the terminal all at once,
+// which is inserted as text, rather than run as keys
+type PasteEvent struct {
+	text string
+}
+
+type MouseButton int
+
+const (
+	MouseLeft MouseButton = iota
+	MouseMiddle
+	MouseRight
+	MouseRelease
+	MouseWheelUp
+	MouseWheelDown
+)
+
+// A mouse button or wheel, at a 0-indexed cell of the terminal
+type MouseEvent struct {
+	x      int
+	y      int
+	button MouseButton
+}
+
+// The terminal's answer to asking about a DEC private mode, where a state
+// of 0 means it isn't supported, 1 or 2 that it's set or reset, and 3 or 4
+// that it's always set or reset
+type ModeReportEvent struct {
+	mode  int
+	state int
+}
+
+// The input ran out, or couldn't be read, which ends the main loop
+type InputClosedEvent struct {
+	err error
+}
+
+func (KeyEvent) isEvent()         {}
+func (ResizeEvent) isEvent()      {}
+func (TickEvent) isEvent()        {}
+func (FileChangedEvent) isEvent() {}
+func (JobOutputEvent) isEvent()   {}
+func (PasteEvent) isEvent()       {}
+func (MouseEvent) isEvent()       {}
+func (ModeReportEvent) isEvent()  {}
+func (InputClosedEvent) isEvent() {}
+
+// Something that sends events into the loop from its own goroutine.
+// run returns once there's nothing more to send, or the loop is done,
+// and stop unblocks anything run might be waiting on besides the loop.
+type EventProducer interface {
+	run(loop *EventLoop)
+	stop()
+}
+
+// The channel that every producer sends into, and that the main
+// loop reads from. Stopping the loop stops all of its producers.
+type EventLoop struct {
+	events    chan Event
+	done      chan struct{}
+	producers []EventProducer
+	running   sync.WaitGroup
+	stopOnce  sync.Once
+}
+
+func newEventLoop(producers ...EventProducer) *EventLoop {
+	loop := &EventLoop{
+		events: make(chan Event, 100),
+		done:   make(chan struct{}),
+	}
+	for _, p := range producers {
+		loop.start(p)
+	}
+	return loop
+}
+
+// Running another producer, which can be started at any time
+func (loop *EventLoop) start(p EventProducer) {
+	loop.producers = append(loop.producers, p)
+	loop.running.Add(1)
+
+	go func() {
+		defer loop.running.Done()
+		p.run(loop)
+	}()
+}
+
+// Sending an event, unless the loop is done.
+// Returning false once the producer should return.
+func (loop *EventLoop) send(e Event) bool {
+	// Checking first, since a select with room in the
+	// channel could pick either case once the loop is done
+	select {
+	case <-loop.done:
+		return false
+	default:
+	}
+
+	select {
+	case loop.events <- e:
+		return true
+	case <-loop.done:
+		return false
+	}
+}
+
+func (loop *EventLoop) next() Event {
+	return <-loop.events
+}
+
+// Stopping every producer, and waiting for them to return
+func (loop *EventLoop) stop() {
+	loop.stopOnce.Do(func() {
+		close(loop.done)
+		for _, p := range loop.producers {
+			p.stop()
+		}
+		loop.running.Wait()
+	})
+}
+
+// Sending each rune of an InputIterator as a KeyEvent, or
+// what it decodes, if it's also an EventIterator
+type InputProducer struct {
+	it InputIterator
+}
+
+func (p InputProducer) run(loop *EventLoop) {
+	for {
+		done, e, err := p.next()
+		if done || err != nil {
+			loop.send(InputClosedEvent{err: err})
+			return
+		}
+		if !loop.send(e) {
+			return
+		}
+	}
+}
+
+func (p InputProducer) next() (bool, Event, error) {
+	if events, ok := p.it.(EventIterator); ok {
+		return events.NextEvent()
+	}
+	done, r, err := p.it.Next()
+	return done, KeyEvent{key: r}, err
+}
+
+func (p InputProducer) stop() {
+	p.it.Close()
+}
+
+// Sending a TickEvent every interval
+type TickProducer struct {
+	interval time.Duration
+}
+
+func (p TickProducer) run(loop *EventLoop) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case t := <-ticker.C:
+			if !loop.send(TickEvent{time: t}) {
+				return
+			}
+		case <-loop.done:
+			return
+		}
+	}
+}
+
+func (TickProducer) stop() {}
+
+// Running a job in the background, and sending what it returns. The job
+// is given the loop's done channel, and should return once it closes,
+// but the producer doesn't wait for that, since stopping the loop waits
+// for every producer, and a job might not be able to stop right away.
+type JobProducer struct {
+	name string
+	job  func(done <-chan struct{}) (string, error)
+}
+
+func (p JobProducer) run(loop *EventLoop) {
+	result := make(chan JobOutputEvent, 1)
+	go func() {
+		output, err := p.job(loop.done)
+		result <- JobOutputEvent{job: p.name, output: output, err: err}
+	}()
+
+	select {
+	case e := <-result:
+		loop.send(e)
+	case <-loop.done:
+	}
+}
+
+func (JobProducer) stop() {}
+
+// Handling one event, and returning false if the main loop should end
+func handleEvent[T Terminal](prog *Program[T], event Event) bool {
+	switch e := event.(type) {
+	case KeyEvent:
+		prog.recordMacroKey(e.key)
+		processInput(prog, e.key)
+	case ResizeEvent:
+		prog.handleResize()
+	case TickEvent:
+		for _, path := range prog.changedFiles() {
+			handleEvent(prog, FileChangedEvent{path: path})
+		}
+	case FileChangedEvent:
+		prog.handleFileChanged(e.path)
+	case PasteEvent:
+		prog.handlePaste(e.text)
+	case JobOutputEvent:
+		prog.handleJobOutput(e)
+	case MouseEvent:
+		prog.handleMouse(e)
+	case ModeReportEvent:
+		prog.handleModeReport(e)
+	case InputClosedEvent:
+		if e.err != nil {
+			prog.logger(fmt.Sprintf("Error reading input: %v", e.err))
+		}
+		return false
+	}
+
+	return !prog.state.shouldExit
+}
+
+// Finding the buffers whose files were changed on disk since they were
+// last read or written, and remembering the change, so it's only noticed once
+func (prog *Program[T]) changedFiles() []string {
+	paths := []string{}
+	for i := range prog.state.buffers {
+		buffer := &prog.state.buffers[i]
+		if buffer.modTime.IsZero() {
+			continue
+		}
+		if modTime := fileModTime(buffer.filepath); !modTime.Equal(buffer.modTime) {
+			paths = append(paths, buffer.filepath)
+		}
+	}
+	return paths
+}
+
+func (prog *Program[T]) handleFileChanged(path string) {
+	for i := range prog.state.buffers {
+		buffer := &prog.state.buffers[i]
+		if buffer.filepath == path {
+			buffer.modTime = fileModTime(path)
+			prog.setStatus("W11: Warning: File \"%s\" has changed since editing started", path)
+		}
+	}
+}
+
+// Running a job on the main loop's events, once it's started
+func (prog *Program[T]) startJob(name string, job func(done <-chan struct{}) (string, error)) error {
+	if prog.state.events == nil {
+		return fmt.Errorf("Cannot run jobs before the main loop starts")
+	}
+	prog.state.events.start(JobProducer{name: name, job: job})
+	return nil
+}
+
+// Showing the last line of what a job printed, or what went wrong
+func (prog *Program[T]) handleJobOutput(e JobOutputEvent) {
+	if e.err != nil {
+		prog.setError(fmt.Errorf("%s: %w", e.job, e.err))
+		return
+	}
+
+	output := strings.TrimRight(e.output, "\n")
+	if output == "" {
+		prog.setStatus("%s: done", e.job)
+		return
+	}
+	lines := strings.Split(output, "\n")
+	prog.setStatus("%s: %s", e.job, lines[len(lines)-1])
+}
+
+// Typing pasted text in whatever mode the program is in. In NormalMode
+// it's inserted before the cursor, and in CommandMode it's kept on one line.
+// Control chars besides newlines and tabs are dropped, so that pasted
+// text can't run commands, like an Esc followed by normal mode keys.
+func (prog *Program[T]) handlePaste(text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.Map(func(r rune) rune {
+		if (r < ' ' && r != '\n' && r != '\t') || r == 0x7f {
+			return -1
+		}
+		return r
+	}, text)
+	keys := []rune(text)
+
+	switch prog.state.currentMode {
+	case NormalMode:
+		keys = append(append([]rune{'i'}, keys...), RuneEscape)
+	case InsertMode, ReplaceMode:
+	case CommandMode:
+		keys = []rune(strings.ReplaceAll(text, "\n", ""))
+	default:
+		return
+	}
+
+	for _, key := range keys {
+		prog.recordMacroKey(key)
+		processInput(prog, key)
+	}
+}
+
+// Turning synchronized output on once the terminal says it supports it.
+// A mode that's always reset can't be turned on, so it doesn't count.
+func (prog *Program[T]) handleModeReport(e ModeReportEvent) {
+	if e.mode == synchronizedOutputMode && e.state >= 1 && e.state <= 3 {
+		prog.settings.syncoutput = true
+	}
+}
+
+// How many lines the mouse wheel scrolls at a time
+const mouseScrollLines = 3
+
+func (prog *Program[T]) handleMouse(e MouseEvent) {
+	switch e.button {
+	case MouseLeft:
+		prog.clickCell(e.x, e.y)
+	case MouseWheelUp:
+		prog.scrollLines(-mouseScrollLines)
+	case MouseWheelDown:
+		prog.scrollLines(mouseScrollLines)
+	}
+}
+
+// Moving the cursor to the char under a clicked cell, and making
+// its panel the active one. Clicks outside of panels do nothing.
+func (prog *Program[T]) clickCell(x, y int) {
+	tab := &prog.state.tabs[prog.state.activeTabIdx]
+
+	for i := range tab.panels {
+		panel := &tab.panels[i]
+		if x < panel.topLeftX || x >= panel.topLeftX+panel.width ||
+			y < panel.topLeftY || y >= panel.topLeftY+panel.height {
+			continue
+		}
+
+		tab.activePanelIdx = i
+		buffer := prog.getActiveBuffer()
+		lineIdx := min(buffer.topVisibleLineIdx+y-panel.topLeftY, len(buffer.lines)-1)
+		line := buffer.lineContent(lineIdx)
+
+		prog.setLogicalCursorPosition(getLogicalXWithVisualX(line, x-panel.topLeftX, &prog.settings), lineIdx)
+		prog.scrollToCursor()
+		return
+	}
+}
+
+// Scrolling the active panel without moving the cursor,
+// unless that's needed to keep it on the screen
+func (prog *Program[T]) scrollLines(n int) {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	buffer.topVisibleLineIdx = max(min(buffer.topVisibleLineIdx+n, len(buffer.lines)-1), 0)
+
+	y := max(min(panel.logicalCursorY, buffer.topVisibleLineIdx+panel.height-1), buffer.topVisibleLineIdx)
+	if y != panel.logicalCursorY {
+		prog.setLogicalCursorPosition(panel.logicalCursorX, y)
+		prog.clampCursor()
+	}
+	prog.state.needsRedraw = true
+}
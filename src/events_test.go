@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsAreHandledInOrder(t *testing.T) {
+	p := testingProgramWithSize("abc", 5, 20)
+	p.processEvents(KeyEvent{'x'}, ResizeEvent{}, KeyEvent{'.'})
+	p.assertBufferContent(t, "c")
+
+	// Nothing after the input closes is handled
+	p.processEvents(KeyEvent{'x'}, InputClosedEvent{}, KeyEvent{'x'})
+	p.assertBufferContent(t, "")
+}
+
+func TestFileChangedOnTick(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+
+	p.processEvents(TickEvent{})
+	if p.state.statusMessage != "" {
+		t.Errorf("wanted no warning for an unchanged file, got %q", p.state.statusMessage)
+	}
+
+	// Changing the file behind the program's back
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	p.processEvents(TickEvent{})
+	if !strings.HasPrefix(p.state.statusMessage, "W11: Warning: File") {
+		t.Errorf("wanted a warning that the file changed, got %q", p.state.statusMessage)
+	}
+
+	// The same change is only warned about once
+	p.state.statusMessage = ""
+	p.processEvents(TickEvent{})
+	if p.state.statusMessage != "" {
+		t.Errorf("wanted the warning shown once, got %q", p.state.statusMessage)
+	}
+
+	// Writing the file isn't a change made by something else
+	p.processKeys(":w\n")
+	p.state.statusMessage = ""
+	p.processEvents(TickEvent{})
+	if p.state.statusMessage != "" {
+		t.Errorf("wanted no warning after writing, got %q", p.state.statusMessage)
+	}
+}
+
+func TestJobOutput(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processEvents(JobOutputEvent{job: "make", output: "building\nok\n"})
+	if p.state.statusMessage != "make: ok" || p.state.statusIsError {
+		t.Errorf("wanted the last line of the output, got %q", p.state.statusMessage)
+	}
+
+	p.processEvents(JobOutputEvent{job: "make", err: errors.New("exit status 2")})
+	if p.state.statusMessage != "make: exit status 2" || !p.state.statusIsError {
+		t.Errorf("wanted the job's error, got %q", p.state.statusMessage)
+	}
+}
+
+func TestJobProducer(t *testing.T) {
+	loop := newEventLoop(JobProducer{name: "echo", job: func(done <-chan struct{}) (string, error) {
+		return "hi\n", nil
+	}})
+	defer loop.stop()
+
+	want := JobOutputEvent{job: "echo", output: "hi\n"}
+	if got := loop.next(); got != want {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestJobsDontHoldUpStopping(t *testing.T) {
+	// A job that ignores the done channel
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	loop := newEventLoop(JobProducer{name: "stuck", job: func(done <-chan struct{}) (string, error) {
+		<-unblock
+		return "", nil
+	}})
+
+	stopped := make(chan struct{})
+	go func() {
+		loop.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wanted the loop to stop without waiting for the job")
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run commands with")
+	}
+
+	p := testingProgramFromBuf("abc")
+	loop := newEventLoop()
+	p.state.events = loop
+
+	if err := p.runCommandLine(":!echo one; echo two"); err != nil {
+		t.Fatal(err)
+	}
+	handleEvent(&p, loop.next())
+	if p.state.statusMessage != "echo one; echo two: two" {
+		t.Errorf("wanted the command's last line, got %q", p.state.statusMessage)
+	}
+
+	loop.stop()
+	p.state.events = nil
+
+	// A command that's still running is killed once the loop is done
+	done := make(chan struct{})
+	close(done)
+	start := time.Now()
+	if _, err := shellJob("sleep 10")(done); err == nil {
+		t.Errorf("wanted the command to be killed")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("wanted the command killed without waiting for it")
+	}
+
+	if err := p.runCommandLine(":!"); err == nil || err.Error() != "Argument required" {
+		t.Errorf("wanted an error without a command, got %v", err)
+	}
+}
+
+func TestPaste(t *testing.T) {
+	// In NormalMode, text is inserted before the cursor, as one change
+	p := testingProgramFromBuf("xy")
+	p.processKeys("l")
+	p.processEvents(PasteEvent{"ab\r\ncd"})
+	p.assertBufferContent(t, "xab", "cdy")
+	if p.state.currentMode != NormalMode {
+		t.Errorf("wanted to stay in NormalMode")
+	}
+	p.processKeys("u")
+	p.assertBufferContent(t, "xy")
+
+	// In InsertMode, it's typed, and control chars can't leave InsertMode
+	p = testingProgramFromBuf("xy")
+	p.processKeys("i")
+	p.processEvents(PasteEvent{"dd\x1bu"})
+	p.processKeys("\x1b")
+	p.assertBufferContent(t, "dduxy")
+
+	// In CommandMode, newlines don't run the command
+	p = testingProgramFromBuf("xy")
+	p.processKeys(":")
+	p.processEvents(PasteEvent{"set\nts?"})
+	if got := string(p.state.commandLine.text); got != "setts?" {
+		t.Errorf("wanted the paste on one line, got %q", got)
+	}
+}
+
+func TestMouseClick(t *testing.T) {
+	p := testingProgramWithSize("abc\n\tdef\nghi", 6, 20)
+	panel := p.getActivePanel()
+
+	p.processEvents(MouseEvent{x: panel.topLeftX + 5, y: panel.topLeftY + 1, button: MouseLeft})
+	p.assertLogicalPos(t, 2, 1)
+
+	// Past the end of a line, or below the last one
+	p.processEvents(MouseEvent{x: panel.topLeftX + 10, y: panel.topLeftY + 3, button: MouseLeft})
+	p.assertLogicalPos(t, 2, 2)
+
+	// Outside of the panel
+	p.processEvents(MouseEvent{x: 0, y: 0, button: MouseLeft})
+	p.assertLogicalPos(t, 2, 2)
+}
+
+func TestMouseWheel(t *testing.T) {
+	lines := []string{}
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	p := testingProgramWithSize(strings.Join(lines, "\n"), 8, 10)
+	buffer := p.getActiveBuffer()
+
+	// The cursor is kept on the screen as it scrolls away
+	p.processEvents(MouseEvent{button: MouseWheelDown})
+	if buffer.topVisibleLineIdx != 3 {
+		t.Errorf("wanted to scroll 3 lines, got %d", buffer.topVisibleLineIdx)
+	}
+	p.assertLogicalPos(t, 0, 3)
+
+	// And doesn't move while it's still shown
+	p.processKeys("jj")
+	p.processEvents(MouseEvent{button: MouseWheelUp})
+	if buffer.topVisibleLineIdx != 0 {
+		t.Errorf("wanted to scroll back to the top, got %d", buffer.topVisibleLineIdx)
+	}
+	p.assertLogicalPos(t, 0, 5)
+
+	p.processKeys("G")
+	p.processEvents(MouseEvent{button: MouseWheelDown}, MouseEvent{button: MouseWheelDown})
+	if buffer.topVisibleLineIdx != 19 {
+		t.Errorf("wanted to stop at the last line, got %d", buffer.topVisibleLineIdx)
+	}
+	p.assertLogicalPos(t, 0, 19)
+}
+
+// An input that waits for keys that never come, until it's closed
+type blockingInputIterator struct {
+	closed chan struct{}
+}
+
+func (it blockingInputIterator) Next() (bool, rune, error) {
+	<-it.closed
+	return true, 0, nil
+}
+
+func (it blockingInputIterator) Close() {
+	close(it.closed)
+}
+
+func TestEventLoopStopsProducers(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	input := blockingInputIterator{closed: make(chan struct{})}
+
+	loop := newEventLoop(
+		InputProducer{it: input},
+		TickProducer{interval: time.Millisecond},
+	)
+	loop.start(staticEventProducer{events: []Event{KeyEvent{'x'}}})
+
+	returned := make(chan struct{})
+	go func() {
+		runMainLoop(&p, loop)
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wanted the loop to stop its producers and return")
+	}
+
+	p.assertBufferContent(t, "bc")
+	select {
+	case <-input.closed:
+	default:
+		t.Errorf("wanted the input closed")
+	}
+
+	// Producers can't send once the loop is stopped
+	if loop.send(TickEvent{}) {
+		t.Errorf("wanted sending to a stopped loop to fail")
+	}
+}
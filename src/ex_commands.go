@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CompletionKind int
+
+const (
+	CompleteNothing CompletionKind = iota
+	CompleteFiles
+	CompleteSettings
+)
+
+type ExCommand[T Terminal] struct {
+	// The full name, and the shortest prefix of it that is accepted
+	name   string
+	abbrev string
+
+	allowRange bool
+	allowBang  bool
+	completion CompletionKind
+	run        func(prog *Program[T], cmd *ExCommandLine) error
+}
+
+// Registering every ex command. Adding a command only requires adding
+// an entry here, because the parser doesn't know about command names.
+// When abbreviations overlap, the first entry wins.
+func exCommandTable[T Terminal]() []ExCommand[T] {
+	return []ExCommand[T]{
+		{name: "write", abbrev: "w", allowBang: true, completion: CompleteFiles, run: exWrite[T]},
+		{name: "wq", abbrev: "wq", allowBang: true, completion: CompleteFiles, run: exWriteQuit[T]},
+		{name: "xit", abbrev: "x", allowBang: true, completion: CompleteFiles, run: exXit[T]},
+		{name: "exit", abbrev: "exi", allowBang: true, completion: CompleteFiles, run: exXit[T]},
+		{name: "quit", abbrev: "q", allowBang: true, run: exQuit[T]},
+		{name: "edit", abbrev: "e", allowBang: true, completion: CompleteFiles, run: exEdit[T]},
+		{name: "set", abbrev: "se", completion: CompleteSettings, run: exSet[T]},
+		{name: "delete", abbrev: "d", allowRange: true, run: exDelete[T]},
+		{name: "substitute", abbrev: "s", allowRange: true, run: exSubstitute[T]},
+		{name: "global", abbrev: "g", allowRange: true, allowBang: true, run: exGlobal[T]},
+		{name: "vglobal", abbrev: "v", allowRange: true, run: exVglobal[T]},
+		{name: "move", abbrev: "m", allowRange: true, run: exMove[T]},
+		{name: "copy", abbrev: "co", allowRange: true, run: exCopy[T]},
+		{name: "t", abbrev: "t", allowRange: true, run: exCopy[T]},
+		{name: "normal", abbrev: "norm", allowRange: true, allowBang: true, run: exNormal[T]},
+		{name: "!", abbrev: "!", run: exShell[T]},
+	}
+}
+
+func lookupExCommand[T Terminal](name string) (*ExCommand[T], bool) {
+	table := exCommandTable[T]()
+
+	for i := range table {
+		cmd := &table[i]
+		if strings.HasPrefix(cmd.name, name) && len(name) >= len(cmd.abbrev) {
+			return cmd, true
+		}
+	}
+
+	return nil, false
+}
+
+func (prog *Program[T]) exAddressContext() ExAddressContext {
+	return ExAddressContext{
+		currentLine: prog.getActivePanel().logicalCursorY,
+		lastLine:    len(prog.getActiveBuffer().lines) - 1,
+	}
+}
+
+func (prog *Program[T]) executeCommandLine(line string) {
+	if err := prog.runCommandLine(line); err != nil {
+		prog.setError(err)
+	}
+}
+
+func (prog *Program[T]) runCommandLine(line string) error {
+	cmd, err := parseExCommandLine(line, prog.exAddressContext())
+	if err != nil {
+		return err
+	}
+
+	// A lone range, like `:12`, jumps to its last line
+	if cmd.name == "" {
+		if cmd.addressCount > 0 {
+			prog.recordJump()
+			prog.setLogicalCursorPosition(0, cmd.endLine)
+			prog.scrollToCursor()
+		}
+		return nil
+	}
+
+	def, ok := lookupExCommand[T](cmd.name)
+	if !ok {
+		return fmt.Errorf("Not an editor command: %s", strings.TrimSpace(line))
+	}
+
+	if cmd.addressCount > 0 && !def.allowRange {
+		return fmt.Errorf("No range allowed")
+	}
+
+	if cmd.bang && !def.allowBang {
+		return fmt.Errorf("No ! allowed")
+	}
+
+	return def.run(prog, &cmd)
+}
+
+// Running a shell command in the background, and showing the last line
+// it prints once it's done. Unlike vim, editing goes on in the meantime.
+func exShell[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	if cmd.arg == "" {
+		return fmt.Errorf("Argument required")
+	}
+	return prog.startJob(cmd.arg, shellJob(cmd.arg))
+}
+
+// How long a killed command's output is waited for, since anything
+// it started in the background can keep the output open
+const shellWaitDelay = time.Second
+
+// A job running command with the shell, and killing it if the loop
+// is done first. Stdin isn't connected, so it can't take typed keys.
+func shellJob(command string) func(done <-chan struct{}) (string, error) {
+	return func(done <-chan struct{}) (string, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		}
+		cmd.WaitDelay = shellWaitDelay
+
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+}
+
+func exWrite[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	return prog.writeBuffer(prog.getActiveBuffer(), cmd.arg)
+}
+
+func exWriteQuit[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	if err := prog.writeBuffer(prog.getActiveBuffer(), cmd.arg); err != nil {
+		return err
+	}
+	return exQuit(prog, &ExCommandLine{bang: cmd.bang})
+}
+
+// Like :wq, but only writing when there are changes
+func exXit[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	buffer := prog.getActiveBuffer()
+	if buffer.modified || cmd.arg != "" {
+		if err := prog.writeBuffer(buffer, cmd.arg); err != nil {
+			return err
+		}
+	}
+	return exQuit(prog, &ExCommandLine{bang: cmd.bang})
+}
+
+func exQuit[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	if !cmd.bang {
+		for _, buffer := range prog.state.buffers {
+			if buffer.modified {
+				return fmt.Errorf("No write since last change for \"%s\" (add ! to override)", buffer.filepath)
+			}
+		}
+	}
+	prog.state.shouldExit = true
+	return nil
+}
+
+func exEdit[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	return prog.editFile(cmd.arg, cmd.bang)
+}
+
+func exDelete[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	buffer := prog.getActiveBuffer()
+
+	for i := cmd.endLine; i >= cmd.startLine; i-- {
+		buffer.removeLine(i)
+	}
+
+	// Always keeping one line in the buffer
+	if len(buffer.lines) == 0 {
+		buffer.insertLine(0, "")
+	}
+
+	prog.setLogicalCursorPosition(0, min(cmd.startLine, len(buffer.lines)-1))
+	prog.scrollToCursor()
+	return nil
+}
+
+// Reading the address that :move and :copy put lines below,
+// where 0 means above the first line
+func (prog *Program[T]) parseDestination(arg string) (int, error) {
+	runes := []rune(strings.TrimSpace(arg))
+	ctx := prog.exAddressContext()
+
+	line, next, found, err := parseExAddress(runes, 0, ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !found || next != len(runes) {
+		return 0, fmt.Errorf("Invalid address")
+	}
+	if line < -1 || line > ctx.lastLine {
+		return 0, fmt.Errorf("Invalid range")
+	}
+	return line, nil
+}
+
+func exMove[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	dest, err := prog.parseDestination(cmd.arg)
+	if err != nil {
+		return err
+	}
+	if dest >= cmd.startLine && dest < cmd.endLine {
+		return fmt.Errorf("Cannot move a range of lines into itself")
+	}
+
+	buffer := prog.getActiveBuffer()
+	count := cmd.endLine - cmd.startLine + 1
+
+	// Moving lines to where they already are changes nothing
+	if dest == cmd.startLine-1 || dest == cmd.endLine {
+		prog.moveToFirstNonBlank(cmd.endLine)
+		return nil
+	}
+
+	moved := append([]BufferLine{}, buffer.lines[cmd.startLine:cmd.endLine+1]...)
+	for i := cmd.endLine; i >= cmd.startLine; i-- {
+		buffer.removeLine(i)
+	}
+	if dest > cmd.endLine {
+		dest -= count
+	}
+
+	// Keeping the flags of each line, so marked lines stay marked
+	for i, line := range moved {
+		buffer.insertLine(dest+1+i, line.content)
+		buffer.lines[dest+1+i].flags = line.flags
+	}
+
+	prog.moveToFirstNonBlank(dest + count)
+	return nil
+}
+
+func exCopy[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	dest, err := prog.parseDestination(cmd.arg)
+	if err != nil {
+		return err
+	}
+
+	buffer := prog.getActiveBuffer()
+	copied := []string{}
+	for y := cmd.startLine; y <= cmd.endLine; y++ {
+		copied = append(copied, buffer.lineContent(y))
+	}
+
+	buffer.insertLines(dest+1, copied)
+	prog.moveToFirstNonBlank(dest + len(copied))
+	return nil
+}
+
+// Writing a buffer to disk, and reporting the outcome in the bottom chrome.
+// An empty path writes the buffer back to its own file.
+func (prog *Program[T]) writeBuffer(buffer *Buffer, path string) error {
+	if path == "" {
+		path = buffer.filepath
+	}
+
+	if err := buffer.saveAs(path); err != nil {
+		return err
+	}
+
+	prog.setStatus("\"%s\" %dL, %dB written", path, len(buffer.lines), len(buffer.contentBytes()))
+
+	if prog.settings.undofile && path == buffer.filepath {
+		if err := buffer.writeUndoFile(prog.undoDir()); err != nil {
+			return fmt.Errorf("\"%s\" written, but its undo history wasn't: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Finding the buffer that has a file open
+func (prog *Program[T]) findBuffer(path string) (int, bool) {
+	for i, buffer := range prog.state.buffers {
+		if filepath.Clean(buffer.filepath) == filepath.Clean(path) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Switching the active panel to a file, loading it if it isn't open yet.
+// An empty path reloads the current file. With force, changes to an
+// already open file are discarded, and it's read from disk again.
+func (prog *Program[T]) editFile(path string, force bool) error {
+	panel := prog.getActivePanel()
+	current := prog.getActiveBuffer()
+
+	if path == "" {
+		if current.modified && !force {
+			return fmt.Errorf("No write since last change (add ! to override)")
+		}
+		path = current.filepath
+		force = true
+	}
+
+	bufferIdx, ok := prog.findBuffer(path)
+	if !ok {
+		bufferIdx = -1
+	}
+
+	if bufferIdx != panel.bufferIdx {
+		prog.recordJump()
+	}
+
+	if bufferIdx != -1 && !force {
+		panel.bufferIdx = bufferIdx
+		prog.setLogicalCursorPosition(0, 0)
+		prog.scrollToCursor()
+		return nil
+	}
+
+	buffer := Buffer{filepath: path}
+
+	switch checkPath(path) {
+	case FileStatusIsDirectory:
+		return fmt.Errorf("Cannot open directories yet: %s", path)
+	case FileStatusAccessDenied:
+		return fmt.Errorf("Access denied: %s", path)
+	case FileStatusNotExists:
+		buffer.lines = []BufferLine{{}}
+		prog.setStatus("\"%s\" [New]", path)
+	case FileStatusIsFile:
+		lines, err := readFileAsBufferLines(path)
+		if err != nil {
+			return err
+		}
+		buffer.lines = lines
+		buffer.modTime = fileModTime(path)
+		prog.setStatus("\"%s\" %dL, %dB", path, len(buffer.lines), len(buffer.contentBytes()))
+		prog.loadUndoFile(&buffer)
+	}
+
+	if bufferIdx == -1 {
+		prog.state.buffers = append(prog.state.buffers, buffer)
+		bufferIdx = len(prog.state.buffers) - 1
+	} else {
+		// Marks stay with a file that's read again
+		buffer.marks = prog.state.buffers[bufferIdx].marks
+		prog.state.buffers[bufferIdx] = buffer
+	}
+
+	panel.bufferIdx = bufferIdx
+	prog.setLogicalCursorPosition(0, 0)
+	prog.scrollToCursor()
+	return nil
+}
+
+// Finding candidates for the word before the cursor in the command line.
+// Returning the rune index where the word starts, and the candidates.
+func (prog *Program[T]) commandLineCompletions(text []rune) (int, []string) {
+	cmd, err := parseExCommandLine(string(text), prog.exAddressContext())
+	if err != nil {
+		return 0, nil
+	}
+
+	wordStart := len(text)
+	for wordStart > 0 && text[wordStart-1] != ' ' && text[wordStart-1] != ':' {
+		wordStart--
+	}
+	word := string(text[wordStart:])
+
+	// Still typing the command name
+	if !strings.ContainsRune(strings.TrimLeft(string(text), " :"), ' ') {
+		if cmd.bang {
+			return 0, nil
+		}
+		wordStart = len(text) - len([]rune(cmd.name))
+		candidates := []string{}
+		for _, def := range exCommandTable[T]() {
+			if strings.HasPrefix(def.name, cmd.name) {
+				candidates = append(candidates, def.name)
+			}
+		}
+		return wordStart, candidates
+	}
+
+	def, ok := lookupExCommand[T](cmd.name)
+	if !ok {
+		return 0, nil
+	}
+
+	switch def.completion {
+	case CompleteFiles:
+		return wordStart, completeFilePath(word)
+	case CompleteSettings:
+		return wordStart, completeSettingName(word)
+	}
+
+	return 0, nil
+}
+
+func completeFilePath(prefix string) []string {
+	matches, err := filepath.Glob(escapeGlob(prefix) + "*")
+	if err != nil {
+		return nil
+	}
+
+	candidates := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err == nil && info.IsDir() {
+			match += string(filepath.Separator)
+		}
+		candidates = append(candidates, match)
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+func escapeGlob(path string) string {
+	replacer := strings.NewReplacer("*", "\\*", "?", "\\?", "[", "\\[", "\\", "\\\\")
+	return replacer.Replace(path)
+}
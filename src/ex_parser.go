@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A parsed ex command line, like `:3,10d` or `:e! file`.
+// Line numbers are 0-indexed, even though they are typed 1-indexed.
+type ExCommandLine struct {
+	addressCount int
+	startLine    int
+	endLine      int
+	name         string
+	bang         bool
+	arg          string
+}
+
+// Everything the parser needs to know about the buffer to resolve addresses
+type ExAddressContext struct {
+	currentLine int
+	lastLine    int
+}
+
+func parseExCommandLine(line string, ctx ExAddressContext) (ExCommandLine, error) {
+	cmd := ExCommandLine{
+		startLine: ctx.currentLine,
+		endLine:   ctx.currentLine,
+	}
+
+	runes := []rune(line)
+	pos := skipSpaces(runes, 0)
+
+	// Stripping any number of leading colons, like vim does
+	for pos < len(runes) && runes[pos] == ':' {
+		pos = skipSpaces(runes, pos+1)
+	}
+
+	pos, err := parseExRange(runes, pos, ctx, &cmd)
+	if err != nil {
+		return cmd, err
+	}
+
+	pos = skipSpaces(runes, pos)
+
+	// Reading the command name, which is either a run of letters,
+	// or a single symbol like `<`, `>` or `&`
+	nameStart := pos
+	for pos < len(runes) && unicode.IsLetter(runes[pos]) {
+		pos++
+	}
+	if pos == nameStart && pos < len(runes) && !unicode.IsDigit(runes[pos]) {
+		pos++
+	}
+	cmd.name = string(runes[nameStart:pos])
+
+	if pos < len(runes) && runes[pos] == '!' {
+		cmd.bang = true
+		pos++
+	}
+
+	cmd.arg = string(runes[skipSpaces(runes, pos):])
+
+	return cmd, nil
+}
+
+func parseExRange(runes []rune, pos int, ctx ExAddressContext, cmd *ExCommandLine) (int, error) {
+	if pos < len(runes) && runes[pos] == '%' {
+		cmd.addressCount = 2
+		cmd.startLine = 0
+		cmd.endLine = ctx.lastLine
+		return pos + 1, nil
+	}
+
+	addresses := []int{}
+
+	for {
+		line, next, found, err := parseExAddress(runes, pos, ctx)
+		if err != nil {
+			return pos, err
+		}
+		pos = next
+
+		separator := rune(0)
+		if pos < len(runes) && (runes[pos] == ',' || runes[pos] == ';') {
+			separator = runes[pos]
+		}
+
+		// A missing address next to a separator means the current line
+		if !found && separator == 0 {
+			break
+		}
+		if !found {
+			line = ctx.currentLine
+		}
+
+		if line < 0 || line > ctx.lastLine {
+			return pos, fmt.Errorf("Invalid range")
+		}
+		addresses = append(addresses, line)
+
+		if separator == 0 {
+			break
+		}
+
+		// With `;`, the following address is relative to this one
+		if separator == ';' {
+			ctx.currentLine = line
+		}
+		pos++
+	}
+
+	switch len(addresses) {
+	case 0:
+		return pos, nil
+	case 1:
+		cmd.startLine = addresses[0]
+		cmd.endLine = addresses[0]
+	default:
+		cmd.startLine = addresses[len(addresses)-2]
+		cmd.endLine = addresses[len(addresses)-1]
+	}
+
+	cmd.addressCount = min(len(addresses), 2)
+
+	// Quietly accepting backwards ranges, like `:10,3d`
+	if cmd.startLine > cmd.endLine {
+		cmd.startLine, cmd.endLine = cmd.endLine, cmd.startLine
+	}
+
+	return pos, nil
+}
+
+// Parsing a single address, like `12`, `.`, `$` or `.+3`
+func parseExAddress(runes []rune, pos int, ctx ExAddressContext) (line, next int, found bool, err error) {
+	pos = skipSpaces(runes, pos)
+
+	line = ctx.currentLine
+
+	if pos < len(runes) {
+		switch r := runes[pos]; {
+		case r == '.':
+			found = true
+			pos++
+		case r == '$':
+			line = ctx.lastLine
+			found = true
+			pos++
+		case unicode.IsDigit(r):
+			n, end := parseNumber(runes, pos)
+			line = n - 1
+			found = true
+			pos = end
+		}
+	}
+
+	// Applying any number of offsets, like `+2` or `-`
+	for pos < len(runes) && (runes[pos] == '+' || runes[pos] == '-') {
+		sign := 1
+		if runes[pos] == '-' {
+			sign = -1
+		}
+		pos++
+
+		offset := 1
+		if pos < len(runes) && unicode.IsDigit(runes[pos]) {
+			offset, pos = parseNumber(runes, pos)
+		}
+
+		line += sign * offset
+		found = true
+	}
+
+	return line, pos, found, nil
+}
+
+func parseNumber(runes []rune, pos int) (int, int) {
+	end := pos
+	for end < len(runes) && unicode.IsDigit(runes[end]) {
+		end++
+	}
+	n, _ := strconv.Atoi(string(runes[pos:end]))
+	return n, end
+}
+
+func skipSpaces(runes []rune, pos int) int {
+	for pos < len(runes) && (runes[pos] == ' ' || runes[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+// Splitting a command's argument on whitespace
+func splitExArgs(arg string) []string {
+	return strings.Fields(arg)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseExCommandLine(t *testing.T) {
+	ctx := ExAddressContext{currentLine: 4, lastLine: 19}
+
+	tests := []struct {
+		line     string
+		expected ExCommandLine
+	}{
+		{"w", ExCommandLine{startLine: 4, endLine: 4, name: "w"}},
+		{"  :w  out.txt", ExCommandLine{startLine: 4, endLine: 4, name: "w", arg: "out.txt"}},
+		{"e! file", ExCommandLine{startLine: 4, endLine: 4, name: "e", bang: true, arg: "file"}},
+		{"set tabstop=8", ExCommandLine{startLine: 4, endLine: 4, name: "set", arg: "tabstop=8"}},
+		{"3,10d", ExCommandLine{addressCount: 2, startLine: 2, endLine: 9, name: "d"}},
+		{"10,3d", ExCommandLine{addressCount: 2, startLine: 2, endLine: 9, name: "d"}},
+		{"%d", ExCommandLine{addressCount: 2, startLine: 0, endLine: 19, name: "d"}},
+		{".,$d", ExCommandLine{addressCount: 2, startLine: 4, endLine: 19, name: "d"}},
+		{".+1,+3d", ExCommandLine{addressCount: 2, startLine: 5, endLine: 7, name: "d"}},
+		{"-2,.d", ExCommandLine{addressCount: 2, startLine: 2, endLine: 4, name: "d"}},
+		{",5d", ExCommandLine{addressCount: 2, startLine: 4, endLine: 4, name: "d"}},
+		{"2;+1d", ExCommandLine{addressCount: 2, startLine: 1, endLine: 2, name: "d"}},
+		{"12", ExCommandLine{addressCount: 1, startLine: 11, endLine: 11}},
+		{"$", ExCommandLine{addressCount: 1, startLine: 19, endLine: 19}},
+	}
+
+	for _, test := range tests {
+		actual, err := parseExCommandLine(test.line, ctx)
+		if err != nil {
+			t.Errorf("`%s`: unexpected error: %v", test.line, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("`%s`:\nWanted: %+v\nGot: %+v", test.line, test.expected, actual)
+		}
+	}
+}
+
+func TestParseExCommandLineRejectsInvalidRanges(t *testing.T) {
+	ctx := ExAddressContext{currentLine: 0, lastLine: 9}
+
+	for _, line := range []string{"11d", "0d", "1,20d", "-1d"} {
+		if _, err := parseExCommandLine(line, ctx); err == nil {
+			t.Errorf("`%s`: expected an error", line)
+		}
+	}
+}
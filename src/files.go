@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func getExecDir() (string, error) {
@@ -58,3 +61,131 @@ func checkPath(path string) FileStatus {
 		return FileStatusIsFile
 	}
 }
+
+// When a file was last changed, or zero if it can't be checked
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func readFileAsBufferLines(path string) ([]BufferLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	// Loading the file contents into a list of lines
+	lines := []BufferLine{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines = append(lines, BufferLine{
+			content: scanner.Text(),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Keeping at least one line, so the cursor always has somewhere to be
+	if len(lines) == 0 {
+		lines = append(lines, BufferLine{})
+	}
+
+	return lines, nil
+}
+
+// Writing data through a temporary file in the same directory, and renaming
+// it into place, so a failed write never leaves a half-written file behind.
+// The permissions of an existing file are preserved.
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error accessing %s: %w", path, err)
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", path, err)
+	}
+
+	// Cleaning up the temp file if anything below fails
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set mode on %s: %w", tmpPath, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	committed = true
+	return nil
+}
+
+func (b *Buffer) contentBytes() []byte {
+	var sb strings.Builder
+	for _, line := range b.lines {
+		sb.WriteString(line.content)
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
+
+// Writing the buffer back to the file it was loaded from
+func (b *Buffer) save() error {
+	return b.saveAs(b.filepath)
+}
+
+func (b *Buffer) saveAs(path string) error {
+	if path == "" {
+		return fmt.Errorf("no file name")
+	}
+
+	if err := writeFileAtomic(path, b.contentBytes()); err != nil {
+		return err
+	}
+
+	if path == b.filepath {
+		b.modified = false
+		b.modTime = fileModTime(path)
+		b.markSaved()
+	}
+
+	return nil
+}
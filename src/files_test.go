@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testingProgramFromFile(t *testing.T, content string) (Program[MockTerminal], string) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	p := testingProgramFromBuf(strings.TrimSuffix(content, "\n"))
+	p.state.buffers[0].filepath = path
+	p.state.buffers[0].modTime = fileModTime(path)
+	return p, path
+}
+
+func assertFileContent(t *testing.T, path string, expected string) {
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		failWithStackTrace(t, "failed to read %s: %v", path, err)
+		return
+	}
+
+	if string(actual) != expected {
+		failWithStackTrace(t, "File content:\nWanted: `%v`\nGot: `%v`", expected, string(actual))
+	}
+}
+
+func TestWriteSavesEdits(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\ndef\n")
+	p.processKeys("ix\x1b:w\n")
+	assertFileContent(t, path, "xabc\ndef\n")
+
+	if p.getActiveBuffer().modified {
+		t.Errorf("expected buffer to be unmodified after writing")
+	}
+}
+
+func TestWritePreservesFileMode(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	p.processKeys("ix\x1b:w\n")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("wanted mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteLeavesNoTempFiles(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	p.processKeys(":w\n")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("wanted only the written file in the directory, got %d entries", len(entries))
+	}
+}
+
+func TestWriteToNewPath(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	p.processKeys(":w " + other + "\n")
+	assertFileContent(t, other, "abc\n")
+}
+
+func TestWriteErrorIsReported(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	p.getActiveBuffer().filepath = filepath.Join(path, "missing", "file.txt")
+	p.processKeys(":w\n")
+
+	if !p.state.statusIsError || p.state.statusMessage == "" {
+		t.Errorf("expected an error in the status, got `%s`", p.state.statusMessage)
+	}
+}
+
+func TestWriteQuit(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	p.processKeys("ix\x1b:wq\n")
+	assertFileContent(t, path, "xabc\n")
+
+	if !p.state.shouldExit {
+		t.Errorf("expected :wq to exit")
+	}
+}
+
+func TestExitOnlyWritesWhenModified(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	os.Remove(path)
+	p.processKeys(":x\n")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected :x not to write an unmodified buffer")
+	}
+
+	if !p.state.shouldExit {
+		t.Errorf("expected :x to exit")
+	}
+}
+
+func TestQuitRefusesUnsavedChanges(t *testing.T) {
+	p, _ := testingProgramFromFile(t, "abc\n")
+	p.processKeys("ix\x1b:q\n")
+
+	if p.state.shouldExit {
+		t.Errorf("expected :q to refuse to exit with unsaved changes")
+	}
+
+	p.processKeys(":q!\n")
+
+	if !p.state.shouldExit {
+		t.Errorf("expected :q! to exit")
+	}
+}
+
+func TestEditOpensAnotherFile(t *testing.T) {
+	p, path := testingProgramFromFile(t, "abc\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	os.WriteFile(other, []byte("def\nghi\n"), 0644)
+
+	p.processKeys(":e " + other + "\n")
+	p.assertBufferContent(t, "def", "ghi")
+
+	p.processKeys(":e " + path + "\n")
+	p.assertBufferContent(t, "abc")
+}
+
+func TestEditBangDiscardsChanges(t *testing.T) {
+	p, _ := testingProgramFromFile(t, "abc\n")
+	p.processKeys("ix\x1b:e\n")
+	p.assertBufferContent(t, "xabc")
+
+	p.processKeys(":e!\n")
+	p.assertBufferContent(t, "abc")
+}
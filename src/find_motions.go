@@ -0,0 +1,90 @@
+package main
+
+// The last `f`, `F`, `t` or `T`, which `;` and `,` repeat
+type FindState struct {
+	char    rune
+	forward bool
+
+	// Stopping just before the char, rather than on it
+	till bool
+}
+
+// Finding the count'th occurrence of a char in the cursor's line
+func findChar(m *MotionContext, count int, find FindState, repeat bool) (Position, bool) {
+	line := m.line(m.cursor.y)
+	step := -1
+	if find.forward {
+		step = 1
+	}
+
+	// Repeating `t` would otherwise get stuck on the char it stopped before
+	x := m.cursor.x
+	if find.till && repeat && count == 1 {
+		x += step
+	}
+
+	for i := 0; i < count; i++ {
+		for {
+			x += step
+			if x < 0 || x >= len(line) {
+				return m.cursor, false
+			}
+			if line[x] == find.char {
+				break
+			}
+		}
+	}
+
+	if find.till {
+		x -= step
+	}
+	return Position{x, m.cursor.y}, true
+}
+
+// Remembering a find, so that `;` and `,` can repeat it
+func startFind(m *MotionContext, count int, find FindState) (Position, bool) {
+	m.state.lastFind = find
+	return findChar(m, count, find, false)
+}
+
+func motionFindForward(m *MotionContext, count int, char rune) (Position, bool) {
+	return startFind(m, count, FindState{char: char, forward: true})
+}
+
+func motionFindBackward(m *MotionContext, count int, char rune) (Position, bool) {
+	return startFind(m, count, FindState{char: char})
+}
+
+func motionTillForward(m *MotionContext, count int, char rune) (Position, bool) {
+	return startFind(m, count, FindState{char: char, forward: true, till: true})
+}
+
+func motionTillBackward(m *MotionContext, count int, char rune) (Position, bool) {
+	return startFind(m, count, FindState{char: char, till: true})
+}
+
+func motionRepeatFind(m *MotionContext, count int) (Position, bool) {
+	if m.state.lastFind.char == 0 {
+		return m.cursor, false
+	}
+	return findChar(m, count, m.state.lastFind, true)
+}
+
+func motionRepeatFindReversed(m *MotionContext, count int) (Position, bool) {
+	find := m.state.lastFind
+	if find.char == 0 {
+		return m.cursor, false
+	}
+	find.forward = !find.forward
+	return findChar(m, count, find, true)
+}
+
+// Forward finds include the char they land on, backward ones don't,
+// so repeats are inclusive depending on which way they end up going
+func repeatFindIsInclusive(m *MotionContext) bool {
+	return m.state.lastFind.forward
+}
+
+func repeatFindReversedIsInclusive(m *MotionContext) bool {
+	return !m.state.lastFind.forward
+}
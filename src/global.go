@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Marking the lines in a range that a command should run on. The marks
+// are flags on the lines themselves, so they follow each line as other
+// lines are inserted and removed. Returning how many lines were marked.
+func (prog *Program[T]) markLines(startLine, endLine int, shouldMark func(line string) bool) int {
+	buffer := prog.getActiveBuffer()
+	count := 0
+
+	for y := range buffer.lines {
+		marked := y >= startLine && y <= endLine && shouldMark(buffer.lineContent(y))
+		buffer.lines[y].flags.isMarked = marked
+		if marked {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (prog *Program[T]) clearLineMarks() {
+	buffer := prog.getActiveBuffer()
+	for y := range buffer.lines {
+		buffer.lines[y].flags.isMarked = false
+	}
+}
+
+// Running a function on each marked line, unmarking it first. Lines
+// that are removed before they're reached are skipped with them.
+func (prog *Program[T]) forEachMarkedLine(run func(y int) error) error {
+	buffer := prog.getActiveBuffer()
+	from := 0
+
+	for {
+		y := -1
+		for i := from; i < len(buffer.lines); i++ {
+			if buffer.lines[i].flags.isMarked {
+				y = i
+				break
+			}
+		}
+		if y == -1 {
+			return nil
+		}
+
+		buffer.lines[y].flags.isMarked = false
+		buffer.firstMovedLine = math.MaxInt
+		if err := run(y); err != nil {
+			prog.clearLineMarks()
+			return err
+		}
+		if prog.state.shouldExit {
+			return nil
+		}
+
+		// Every marked line was after y, and the lines after it only move
+		// as far up as the first line that was inserted or removed. Lines
+		// moved above y, like with `:m0`, mean scanning from there.
+		from = min(y+1, buffer.firstMovedLine)
+	}
+}
+
+func exGlobal[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	return prog.runGlobal(cmd, !cmd.bang)
+}
+
+func exVglobal[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	return prog.runGlobal(cmd, false)
+}
+
+// Running an ex command on every line that matches a pattern, or on every
+// line that doesn't. Like `:g/pattern/command`, where `:g!` and `:v` are
+// the lines that don't match, and the whole buffer is the default range.
+func (prog *Program[T]) runGlobal(cmd *ExCommandLine, matching bool) error {
+	if prog.state.runningGlobal {
+		return fmt.Errorf("Cannot do :global recursively")
+	}
+
+	delim, body, err := readDelimiter(cmd.arg)
+	if err != nil {
+		return err
+	}
+	pattern, command := readDelimited(body, delim)
+
+	if pattern == "" {
+		pattern = prog.state.search.pattern
+	}
+	if pattern == "" {
+		return fmt.Errorf("No previous regular expression")
+	}
+	prog.state.search.pattern = pattern
+	prog.state.search.forward = true
+
+	re, err := compilePattern(pattern, &prog.settings, true)
+	if err != nil {
+		return err
+	}
+
+	startLine, endLine := cmd.startLine, cmd.endLine
+	if cmd.addressCount == 0 {
+		startLine, endLine = 0, len(prog.getActiveBuffer().lines)-1
+	}
+
+	count := prog.markLines(startLine, endLine, func(line string) bool {
+		return re.MatchString(line) == matching
+	})
+
+	switch {
+	case count == 0 && matching:
+		return fmt.Errorf("Pattern not found: %s", pattern)
+	case count == 0:
+		return fmt.Errorf("Pattern found in every line: %s", pattern)
+	}
+
+	// Without a command, only saying how many lines there are
+	if strings.TrimSpace(command) == "" {
+		prog.clearLineMarks()
+		prog.setStatus("%d %s", count, plural(count, "line"))
+		return nil
+	}
+
+	prog.state.runningGlobal = true
+	prog.state.substitute.globalTotal = SubstituteTotal{}
+	defer func() { prog.state.runningGlobal = false }()
+
+	err = prog.forEachMarkedLine(func(y int) error {
+		prog.setLogicalCursorPosition(0, y)
+		return prog.runCommandLine(command)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Reporting the substitutions on every line together
+	total := prog.state.substitute.globalTotal
+	switch {
+	case total.ran && total.substitutions == 0:
+		return fmt.Errorf("Pattern not found: %s", prog.state.substitute.pattern)
+	case total.ran:
+		prog.reportSubstitutions(total.substitutions, total.changedLines)
+	}
+	return nil
+}
+
+// Typing keys in normal mode at the start of each line in the range,
+// or just at the cursor without a range
+func exNormal[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	if cmd.arg == "" {
+		return fmt.Errorf("Argument required")
+	}
+
+	// Inside `:global`, the lines are already marked by it
+	if cmd.addressCount == 0 || prog.state.runningGlobal {
+		prog.typeNormalKeys(cmd.arg)
+		return nil
+	}
+
+	prog.markLines(cmd.startLine, cmd.endLine, func(line string) bool { return true })
+	return prog.forEachMarkedLine(func(y int) error {
+		prog.setLogicalCursorPosition(0, y)
+		prog.typeNormalKeys(cmd.arg)
+		return nil
+	})
+}
+
+// Running keys as if typed in normal mode, and then finishing
+// whatever they left unfinished, like an insert, as Esc would
+func (prog *Program[T]) typeNormalKeys(keys string) {
+	prog.state.normalCommand = NormalCommandState{}
+
+	for _, key := range keys {
+		dispatchInput(prog, key)
+		if prog.state.shouldExit {
+			return
+		}
+	}
+
+	if prog.state.currentMode != NormalMode {
+		dispatchInput(prog, RuneEscape)
+	}
+	prog.state.normalCommand = NormalCommandState{}
+}
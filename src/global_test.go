@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGlobalDelete(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a\nx1\nb\nx2\nx3\nc", ":g/x/d\n", "a\nb\nc"},
+		{"a\nx1\nb\nx2\nx3\nc", ":v/x/d\n", "x1\nx2\nx3"},
+		{"a\nx1\nb\nx2\nx3\nc", ":g!/x/d\n", "x1\nx2\nx3"},
+		{"x\nx\nx\nx", ":2,3g/x/d\n", "x\nx"},
+		{"x\ny", ":g/x/d\n", "y"},
+	})
+}
+
+func TestGlobalDeleteFollowingLines(t *testing.T) {
+	// Deleting the next line removes a marked line, which is then skipped,
+	// rather than the mark landing on the line after it
+	runBufferTests(t, []bufferTest{
+		{"x1\nx2\na\nx3\nb", ":g/x/.+1d\n", "x1\na\nx3"},
+	})
+}
+
+func TestGlobalFollowsLinesMovedAboveIt(t *testing.T) {
+	// Marked lines that a command moves above the line it ran on,
+	// or that move up as lines above them are removed, are still reached
+	runBufferTests(t, []bufferTest{
+		{"a1\na2\nb", ":g/a/+1m0\n", "a1\na2\nb"},
+		{"a\nx\nx\nb", ":g/x/-1d\n", "x\nb"},
+	})
+}
+
+func TestGlobalMoveAndCopy(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"1\n2\n3\n4", ":g/^/m0\n", "4\n3\n2\n1"},
+		{"a\nTODO b\nc\nTODO d", ":g/TODO/m$\n", "a\nc\nTODO b\nTODO d"},
+		{"a\nx\nb", ":g/x/t.\n", "a\nx\nx\nb"},
+		{"a\nx\nb\nx", ":g/x/co0\n", "x\nx\na\nx\nb\nx"},
+	})
+}
+
+func TestGlobalNormal(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"TODO a\nb\nTODO c", ":g/TODO/normal dw\n", "a\nb\nc"},
+		{"a\nb", ":g/./normal ix\n", "xa\nxb"},
+		{"a\nb", ":g/a/normal yyp\n", "a\na\nb"},
+	})
+}
+
+func TestGlobalSubstitute(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a1\nb1\na2", ":g/a/s/\\d/N/\n", "aN\nb1\naN"},
+		{"ab\nb\nax", ":g/a/s/b/B/\n", "aB\nb\nax"},
+	})
+}
+
+func TestGlobalSubstituteReportsTotal(t *testing.T) {
+	p := testingProgramFromBuf("x\na\nxx\nx")
+	p.processKeys(":g/x/s/x/y/g\n")
+	if p.state.statusMessage != "4 substitutions on 3 lines" {
+		t.Errorf("wanted the substitutions on every line added up, got %q", p.state.statusMessage)
+	}
+
+	p.processKeys(":g/y/s/z/w/\n")
+	if p.state.statusMessage != "Pattern not found: z" || !p.state.statusIsError {
+		t.Errorf("wanted the pattern reported missing once, got %q", p.state.statusMessage)
+	}
+}
+
+func TestGlobalIsOneUndoStep(t *testing.T) {
+	p := testingProgramFromBuf("x\na\nx\nb")
+	p.processKeys(":g/x/d\nu")
+	p.assertBufferContent(t, "x", "a", "x", "b")
+}
+
+func TestGlobalErrors(t *testing.T) {
+	p := testingProgramFromBuf("a\nb")
+	p.processKeys(":g/z/d\n")
+	p.assertBufferContent(t, "a", "b")
+	if p.state.statusMessage != "Pattern not found: z" {
+		t.Errorf("wanted a not found error, got %q", p.state.statusMessage)
+	}
+
+	p.processKeys(":g/a/g/b/d\n")
+	p.assertBufferContent(t, "a", "b")
+	if p.state.statusMessage != "Cannot do :global recursively" {
+		t.Errorf("wanted an error about recursion, got %q", p.state.statusMessage)
+	}
+
+	// An error stops the command, and leaves no lines marked
+	for _, line := range p.getActiveBuffer().lines {
+		if line.flags.isMarked {
+			t.Errorf("wanted no marked lines left over")
+		}
+	}
+}
+
+func TestGlobalWithoutCommand(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\na")
+	p.processKeys(":g/a\n")
+	if p.state.statusMessage != "2 lines" {
+		t.Errorf("wanted the number of matching lines, got %q", p.state.statusMessage)
+	}
+}
+
+func TestMove(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"1\n2\n3\n4", ":m$\n", "2\n3\n4\n1"},
+		{"1\n2\n3\n4", ":1,2m3\n", "3\n1\n2\n4"},
+		{"1\n2\n3\n4", "G:m0\n", "4\n1\n2\n3"},
+		{"1\n2\n3\n4", ":2,3m1\n", "1\n2\n3\n4"},
+		{"1\n2\n3\n4", ":1,3m2\n", "1\n2\n3\n4"},
+	})
+
+	p := testingProgramFromBuf("1\n2\n3")
+	p.processKeys(":m+1\n")
+	p.assertBufferContent(t, "2", "1", "3")
+	p.assertLogicalPos(t, 0, 1)
+}
+
+func TestCopy(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"1\n2", ":t.\n", "1\n1\n2"},
+		{"1\n2", ":1,2co$\n", "1\n2\n1\n2"},
+		{"1\n2", "j:co0\n", "2\n1\n2"},
+	})
+}
+
+func TestNormalWithRange(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a\nb\nc", ":%norm ix\n", "xa\nxb\nxc"},
+		{"a\nb\nc", ":1,2normal dd\n", "c"},
+		{"a b", ":normal dw\n", "b"},
+	})
+}
@@ -2,52 +2,83 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type InputIterator interface {
 	Next() (bool, rune, error)
+
+	// Ending the input, so that Next returns done
+	Close()
+}
+
+// Input that decodes more than keys, like pastes and mouse clicks,
+// which InputProducer reads instead of Next when it's there
+type EventIterator interface {
+	NextEvent() (bool, Event, error)
 }
 
+// Returned while reading, once the iterator is closed
+var errInputClosed = errors.New("input closed")
+
 type StdinIterator struct {
 	reader     *bufio.Reader
 	runes      chan rune     // Channel to store incoming runes
 	done       chan struct{} // Channel to signal the goroutine to stop
-	tempBuffer []rune        // Buffer to hold runes during escape sequence detection
+	closeOnce  sync.Once
+	tempBuffer []rune // Buffer to hold runes during escape sequence detection
+
+	// Why the input ended, which is set before runes is closed
+	err error
+
+	// How long to wait after an ESC for each rune of the rest of a
+	// sequence, and during a paste for more of it, before giving up
+	// on what was read, so that a sequence that was cut off doesn't
+	// swallow the keys after it
+	escapeTimeout time.Duration
+	pasteTimeout  time.Duration
 }
 
 func NewStdinIterator() *StdinIterator {
+	return newReaderIterator(os.Stdin)
+}
+
+func newReaderIterator(r io.Reader) *StdinIterator {
 	it := &StdinIterator{
-		reader:     bufio.NewReader(os.Stdin),
+		reader:     bufio.NewReader(r),
 		runes:      make(chan rune, 100), // Buffered channel with capacity 100
 		done:       make(chan struct{}),
 		tempBuffer: []rune{},
+
+		escapeTimeout: time.Millisecond,
+		pasteTimeout:  time.Second,
 	}
 
-	// Starting a goroutine to read runes
-	// and send them into the channel
+	// Starting a goroutine to read runes and send them into the channel.
+	// A read can't be interrupted, so after Close, the goroutine
+	// returns once the read it's blocked on finishes.
 	go func() {
 		for {
+			r, _, err := it.reader.ReadRune()
+			if err != nil {
+				// Closing the runes channel on EOF, or on an error like
+				// EIO after the terminal hangs up, which won't go away
+				it.err = err
+				close(it.runes)
+				return
+			}
+
+			// Sending the rune into the channel, unless nothing will read it
 			select {
+			case it.runes <- r:
 			case <-it.done:
-				// Stopping the goroutine when signaled
-				close(it.runes) // Closing the runes channel to signal EOF
 				return
-			default:
-				r, _, err := it.reader.ReadRune()
-				if err != nil {
-					// Closing the runes channel on EOF
-					if err == io.EOF {
-						close(it.runes)
-						return
-					}
-					continue
-				}
-
-				// Sending the rune into the channel
-				it.runes <- r
 			}
 		}
 	}()
@@ -55,113 +86,255 @@ func NewStdinIterator() *StdinIterator {
 	return it
 }
 
-func (it *StdinIterator) Next() (done bool, r rune, err error) {
+func (it *StdinIterator) Close() {
+	it.closeOnce.Do(func() {
+		close(it.done)
+	})
+}
+
+// Returning the next key. Pastes, mouse events and the terminal's
+// answers are skipped, since they can only be read with NextEvent.
+func (it *StdinIterator) Next() (bool, rune, error) {
+	for {
+		done, e, err := it.NextEvent()
+		if done {
+			return true, 0, err
+		}
+		if key, ok := e.(KeyEvent); ok {
+			return false, key.key, nil
+		}
+	}
+}
+
+func (it *StdinIterator) NextEvent() (bool, Event, error) {
+	for {
+		e, err := it.nextEvent()
+		if err == errInputClosed {
+			return true, nil, nil
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		// Sequences like mouse motion decode to nothing
+		if e != nil {
+			return false, e, nil
+		}
+	}
+}
+
+// Reading the next rune, or returning ok false if none arrives within
+// timeout. A timeout of 0 waits for as long as it takes.
+func (it *StdinIterator) readRune(timeout time.Duration) (r rune, ok bool, err error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case r, open := <-it.runes:
+		if !open {
+			// Channel is closed, no more input
+			return 0, false, it.err
+		}
+		return r, true, nil
+	case <-it.done:
+		return 0, false, errInputClosed
+	case <-expired:
+		return 0, false, nil
+	}
+}
+
+func (it *StdinIterator) nextEvent() (Event, error) {
 	// If there are runes in the tempBuffer, returning them first
 	if len(it.tempBuffer) > 0 {
-		r = it.tempBuffer[0]
-		// Removing the returned rune from the buffer
+		r := it.tempBuffer[0]
 		it.tempBuffer = it.tempBuffer[1:]
-		return false, r, nil
+		return KeyEvent{key: r}, nil
+	}
+
+	r, _, err := it.readRune(0)
+	if err != nil {
+		return nil, err
+	}
+	if r != RuneEscape {
+		return KeyEvent{key: r}, nil
 	}
 
+	// An escape sequence arrives all at once, so an ESC that nothing
+	// follows right away is the Escape key. If the input ended instead,
+	// the ESC is still returned, and the next read reports the end.
+	next, ok, err := it.readRune(it.escapeTimeout)
+	if err == errInputClosed {
+		return nil, err
+	}
+	if !ok {
+		return KeyEvent{key: RuneEscape}, nil
+	}
+	if next != '[' {
+		it.tempBuffer = append(it.tempBuffer, next)
+		return KeyEvent{key: RuneEscape}, nil
+	}
+
+	return it.readControlSequence()
+}
+
+// The keys sent as ESC [ and one letter
+var csiKeys = map[rune]rune{
+	'A': RuneUpArrow,
+	'B': RuneDownArrow,
+	'C': RuneRightArrow,
+	'D': RuneLeftArrow,
+	'H': RuneHome,
+	'F': RuneEnd,
+}
+
+// The keys sent as ESC [, a number, and ~
+var csiTildeKeys = map[string]rune{
+	"2": RuneInsert,
+	"3": RuneDelete,
+	"5": RunePageUp,
+	"6": RunePageDown,
+}
+
+// Reading the rest of a sequence that started with ESC [, which is
+// parameters followed by a final char between @ and ~, and decoding it.
+// Sequences that aren't known, or that were cut off, are returned
+// as the keys they were made of.
+func (it *StdinIterator) readControlSequence() (Event, error) {
+	seq := []rune{}
 	for {
-		// Reading the next rune from the channel
-		r, ok := <-it.runes
+		r, ok, err := it.readRune(it.escapeTimeout)
+		if err == errInputClosed {
+			return nil, err
+		}
 		if !ok {
-			// Channel is closed, no more input
-			return true, 0, io.EOF
+			return it.returnAsKeys(seq), nil
+		}
+		seq = append(seq, r)
+		if r >= '@' && r <= '~' {
+			break
 		}
+	}
+	params, final := string(seq[:len(seq)-1]), seq[len(seq)-1]
+
+	switch {
+	case params == "" && csiKeys[final] != 0:
+		return KeyEvent{key: csiKeys[final]}, nil
+
+	case final == '~' && csiTildeKeys[params] != 0:
+		return KeyEvent{key: csiTildeKeys[params]}, nil
 
-		// If the rune is not ESC, returning it immediately
-		if r != '\x1b' {
-			return false, r, nil
+	// Bracketed paste, which ends with ESC [ 201 ~
+	case final == '~' && params == "200":
+		return it.readPaste()
+
+	// SGR mouse reporting, as ESC [ < button ; x ; y, with M for
+	// a press and m for a release, and 1-indexed coordinates
+	case (final == 'M' || final == 'm') && strings.HasPrefix(params, "<"):
+		fields := strings.Split(params[1:], ";")
+		if len(fields) == 3 {
+			b, errB := strconv.Atoi(fields[0])
+			x, errX := strconv.Atoi(fields[1])
+			y, errY := strconv.Atoi(fields[2])
+			if errB == nil && errX == nil && errY == nil {
+				return decodeMouse(b, x-1, y-1, final == 'm'), nil
+			}
 		}
 
-		// Handle possible escape sequence
-		it.tempBuffer = append(it.tempBuffer, r)     // Add ESC to the buffer
-		timer := time.NewTimer(1 * time.Millisecond) // Short timeout for escape sequences
+	// The answer to asking about a DEC private mode,
+	// as ESC [ ? mode ; state $ y
+	case final == 'y' && strings.HasPrefix(params, "?") && strings.HasSuffix(params, "$"):
+		fields := strings.Split(params[1:len(params)-1], ";")
+		if len(fields) == 2 {
+			mode, errMode := strconv.Atoi(fields[0])
+			state, errState := strconv.Atoi(fields[1])
+			if errMode == nil && errState == nil {
+				return ModeReportEvent{mode: mode, state: state}, nil
+			}
+		}
 
-		for {
-			select {
-			case nextRune, ok := <-it.runes:
-				if !ok {
-					// Channel is closed, no more input
-					return true, 0, io.EOF
-				}
-
-				it.tempBuffer = append(it.tempBuffer, nextRune) // Adding the rune to the buffer
-
-				// Checking if this is part of a known escape sequence
-				if len(it.tempBuffer) == 2 && it.tempBuffer[1] == '[' {
-					// Possible CSI sequence (ESC [)
-					continue
-				}
-
-				if len(it.tempBuffer) == 3 {
-					// Handling specific CSI sequences
-					switch it.tempBuffer[2] {
-					case 'A':
-						it.tempBuffer = nil // Clearing the buffer after handling
-						return false, RuneUpArrow, nil
-					case 'B':
-						it.tempBuffer = nil
-						return false, RuneDownArrow, nil
-					case 'C':
-						it.tempBuffer = nil
-						return false, RuneRightArrow, nil
-					case 'D':
-						it.tempBuffer = nil
-						return false, RuneLeftArrow, nil
-					case 'H':
-						it.tempBuffer = nil
-						return false, RuneHome, nil
-					case 'F':
-						it.tempBuffer = nil
-						return false, RuneEnd, nil
-					case '2':
-						if tilde, ok := <-it.runes; ok && tilde == '~' {
-							it.tempBuffer = nil
-							return false, RuneInsert, nil
-						}
-					case '3':
-						if tilde, ok := <-it.runes; ok && tilde == '~' {
-							it.tempBuffer = nil
-							return false, RuneDelete, nil
-						}
-					case '5':
-						if tilde, ok := <-it.runes; ok && tilde == '~' {
-							it.tempBuffer = nil
-							return false, RunePageUp, nil
-						}
-					case '6':
-						if tilde, ok := <-it.runes; ok && tilde == '~' {
-							it.tempBuffer = nil
-							return false, RunePageDown, nil
-						}
-					}
-				}
-
-				// If it's not part of a known escape sequence, breaking out
-				break
-
-			case <-timer.C:
-				// Timer expired, treating ESC as a standalone key
-				timer.Stop()
-				r = it.tempBuffer[0]
-				it.tempBuffer = it.tempBuffer[1:] // Removing the returned rune from the buffer
-				return false, r, nil
+	// The older mouse reporting, for terminals without SGR, as ESC [ M
+	// and three chars, which are the button, x and y plus 32
+	case final == 'M' && params == "":
+		args := [3]int{}
+		for i := range args {
+			r, ok, err := it.readRune(it.escapeTimeout)
+			if err == errInputClosed {
+				return nil, err
+			}
+			if !ok {
+				return it.returnAsKeys(seq), nil
 			}
+			seq = append(seq, r)
+			args[i] = int(r) - 32
 		}
+		return decodeMouse(args[0], args[1]-1, args[2]-1, false), nil
+	}
+
+	return it.returnAsKeys(seq), nil
+}
+
+// Giving up on a sequence, and returning the ESC [ that started
+// it, with the rest of it kept to be returned as keys next
+func (it *StdinIterator) returnAsKeys(seq []rune) Event {
+	it.tempBuffer = append(append(it.tempBuffer, '['), seq...)
+	return KeyEvent{key: RuneEscape}
+}
 
-		// If we exit the inner loop without returning, flushing the buffer
-		if len(it.tempBuffer) > 0 {
-			r = it.tempBuffer[0]
-			it.tempBuffer = it.tempBuffer[1:] // Removing the returned rune from the buffer
-			return false, r, nil
+// Reading pasted text up to the sequence that ends the paste. If the
+// paste stops without it, the text so far is still a paste, so that
+// it's inserted, rather than run as keys.
+func (it *StdinIterator) readPaste() (Event, error) {
+	end := []rune("\x1b[201~")
+	text := []rune{}
+
+	for {
+		r, ok, err := it.readRune(it.pasteTimeout)
+		if err == errInputClosed {
+			return nil, err
+		}
+		if !ok && len(text) == 0 {
+			return nil, nil
+		}
+		if !ok {
+			return PasteEvent{text: string(text)}, nil
+		}
+		text = append(text, r)
+
+		if n := len(text) - len(end); n >= 0 && string(text[n:]) == string(end) {
+			return PasteEvent{text: string(text[:n])}, nil
 		}
 	}
 }
 
+// Turning a reported mouse button into an event. Motion while a
+// button is held is reported too, and returns nil, since it isn't used.
+func decodeMouse(b, x, y int, released bool) Event {
+	if b&32 != 0 {
+		return nil
+	}
+
+	button := MouseRelease
+	switch {
+	case b&64 != 0 && b&1 == 0:
+		button = MouseWheelUp
+	case b&64 != 0:
+		button = MouseWheelDown
+	case released:
+		button = MouseRelease
+	case b&3 == 0:
+		button = MouseLeft
+	case b&3 == 1:
+		button = MouseMiddle
+	case b&3 == 2:
+		button = MouseRight
+	}
+
+	return MouseEvent{x: x, y: y, button: button}
+}
+
 type StaticInputIterator struct {
 	inputs []rune
 	index  int
@@ -182,3 +355,5 @@ func (it *StaticInputIterator) Next() (bool, rune, error) {
 	it.index++
 	return false, input, nil
 }
+
+func (it *StaticInputIterator) Close() {}
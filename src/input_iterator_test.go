@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reading every event out of input, with a long wait after an ESC,
+// so that a slow test can't split a sequence into separate keys
+func readEvents(t *testing.T, input string) []Event {
+	it := newReaderIterator(strings.NewReader(input))
+	it.escapeTimeout = time.Second
+
+	events := []Event{}
+	for {
+		done, e, err := it.NextEvent()
+		if done {
+			if err != io.EOF {
+				t.Errorf("wanted the input to end with EOF, got %v", err)
+			}
+			return events
+		}
+		events = append(events, e)
+	}
+}
+
+func keyEvents(keys ...rune) []Event {
+	events := []Event{}
+	for _, key := range keys {
+		events = append(events, KeyEvent{key: key})
+	}
+	return events
+}
+
+func TestStdinIteratorKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Event
+	}{
+		{"a\x1b[Ab", keyEvents('a', RuneUpArrow, 'b')},
+		{"\x1b[3~\x1b[F\x1b[6~", keyEvents(RuneDelete, RuneEnd, RunePageDown)},
+		{"\x1b", keyEvents(RuneEscape)},
+		{"\x1bx", keyEvents(RuneEscape, 'x')},
+
+		// Sequences that aren't known are kept as the keys they were made of
+		{"\x1b[1;5A", keyEvents(RuneEscape, '[', '1', ';', '5', 'A')},
+	}
+
+	for _, test := range tests {
+		if got := readEvents(t, test.input); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("reading %q: wanted %v, got %v", test.input, test.want, got)
+		}
+	}
+}
+
+func TestStdinIteratorPaste(t *testing.T) {
+	got := readEvents(t, "i\x1b[200~a\x1b[Ab\r\nc\x1b[201~x")
+	want := []Event{KeyEvent{key: 'i'}, PasteEvent{text: "a\x1b[Ab\r\nc"}, KeyEvent{key: 'x'}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestStdinIteratorMouse(t *testing.T) {
+	got := readEvents(t, "\x1b[<0;5;3M\x1b[<0;5;3m\x1b[<2;1;1M\x1b[<64;1;1M\x1b[<65;1;1M\x1b[<32;6;3M")
+	want := []Event{
+		MouseEvent{x: 4, y: 2, button: MouseLeft},
+		MouseEvent{x: 4, y: 2, button: MouseRelease},
+		MouseEvent{x: 0, y: 0, button: MouseRight},
+		MouseEvent{x: 0, y: 0, button: MouseWheelUp},
+		MouseEvent{x: 0, y: 0, button: MouseWheelDown},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+
+	// Terminals without SGR send the button and position as chars
+	got = readEvents(t, "\x1b[M %#\x1b[M#%#")
+	want = []Event{
+		MouseEvent{x: 4, y: 2, button: MouseLeft},
+		MouseEvent{x: 4, y: 2, button: MouseRelease},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestStdinIteratorModeReport(t *testing.T) {
+	got := readEvents(t, "\x1b[?2026;2$yx")
+	want := []Event{ModeReportEvent{mode: 2026, state: 2}, KeyEvent{key: 'x'}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestStdinIteratorNextSkipsEvents(t *testing.T) {
+	it := newReaderIterator(strings.NewReader("a\x1b[200~b\x1b[201~\x1b[<0;1;1Mc"))
+	it.escapeTimeout = time.Second
+
+	keys := []rune{}
+	for {
+		done, r, _ := it.Next()
+		if done {
+			break
+		}
+		keys = append(keys, r)
+	}
+	if string(keys) != "ac" {
+		t.Errorf("wanted only the keys, got %q", string(keys))
+	}
+}
+
+func TestInputProducerSendsDecodedEvents(t *testing.T) {
+	p := testingProgramWithSize("xy", 6, 20)
+	it := newReaderIterator(strings.NewReader("\x1b[200~ab\x1b[201~\x1b[<0;2;1M"))
+	it.escapeTimeout = time.Second
+
+	runMainLoop(&p, newEventLoop(InputProducer{it: it}))
+	p.assertBufferContent(t, "abxy")
+	p.assertLogicalPos(t, 1, 0)
+}
+
+// A reader that sends some input, and then nothing for a while,
+// like a connection that stalls partway through a sequence
+type stallingReader struct {
+	input []string
+	stall chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if len(r.input) == 0 {
+		<-r.stall
+		return 0, io.EOF
+	}
+	n := copy(p, r.input[0])
+	r.input = r.input[1:]
+	return n, nil
+}
+
+func TestStdinIteratorCutOffSequences(t *testing.T) {
+	// Reading n events, which is all there are before the input stalls
+	read := func(input string, n int) []Event {
+		reader := &stallingReader{input: []string{input}, stall: make(chan struct{})}
+		defer close(reader.stall)
+
+		it := newReaderIterator(reader)
+		it.escapeTimeout = 10 * time.Millisecond
+		it.pasteTimeout = 10 * time.Millisecond
+
+		events := []Event{}
+		for len(events) < n {
+			done, e, _ := it.NextEvent()
+			if done {
+				break
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+
+	// What was read of a sequence is returned as keys once it stops
+	got := read("\x1b[12!", 5)
+	if want := keyEvents(RuneEscape, '[', '1', '2', '!'); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+
+	// A paste that stops without ending is still a paste
+	got = read("\x1b[200~ab", 1)
+	if want := []Event{PasteEvent{text: "ab"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("input/output error")
+}
+
+func TestStdinIteratorEndsOnReadError(t *testing.T) {
+	it := newReaderIterator(failingReader{})
+	done, _, err := it.NextEvent()
+	if !done || err == nil || err.Error() != "input/output error" {
+		t.Errorf("wanted the input to end with the read error, got %v, %v", done, err)
+	}
+}
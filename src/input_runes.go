@@ -28,6 +28,15 @@ const (
 	RuneTab            rune = '\t'
 )
 
+// Defining aliases for control characters that are used as commands
+const (
+	RuneCtrlN rune = '\x0e'
+	RuneCtrlO rune = '\x0f'
+	RuneCtrlP rune = '\x10'
+	RuneCtrlR rune = '\x12'
+	RuneCtrlV rune = '\x16'
+)
+
 // Mapping common special keys (like arrows, function keys,
 // navigation keys) into the Unicode Private Use Area (PUA).
 // This ensures they don't collide with printable Unicode chars.
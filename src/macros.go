@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Macros are keys recorded into a named register with `q{reg}` until
+// `q` is typed again, which `@{reg}` plays back as if typed again
+type MacroState struct {
+	// The register being recorded into, or 0, and the keys typed so far
+	recording rune
+	keys      []rune
+
+	// The register last played, which `@@` plays again
+	lastPlayed rune
+
+	// How many macros are playing, counting macros played by macros
+	depth int
+
+	// Set when a command fails during playback, which stops it
+	failed bool
+}
+
+// Macros that play themselves stop when a command fails,
+// and this catches the ones with no command that can fail
+const maxMacroDepth = 100
+
+func (prog *Program[T]) startRecording(name rune) {
+	if !isNamedRegister(name) {
+		return
+	}
+
+	macros := &prog.state.macros
+	macros.recording = name
+	macros.keys = nil
+	prog.state.needsRedraw = true
+}
+
+// Storing the recorded keys, which end with the `q` that stopped recording
+func (prog *Program[T]) stopRecording() {
+	macros := &prog.state.macros
+	keys := macros.keys[:max(len(macros.keys)-1, 0)]
+
+	reg := Register{text: strings.Split(string(keys), "\n"), kind: Charwise}
+	prog.state.registers.setNamed(macros.recording, reg)
+
+	macros.recording = 0
+	macros.keys = nil
+	prog.state.needsRedraw = true
+}
+
+// Recording a key as it comes in, before it's handled
+func (prog *Program[T]) recordMacroKey(input rune) {
+	macros := &prog.state.macros
+	if macros.recording != 0 && macros.depth == 0 {
+		macros.keys = append(macros.keys, input)
+	}
+}
+
+// Noting that a command failed, so that a playing macro stops
+func (prog *Program[T]) commandFailed() {
+	prog.state.macros.failed = true
+}
+
+// Playing the keys in a register count times, or until a command fails.
+// `@@` plays the register that was played last.
+func (prog *Program[T]) playMacro(name rune, count int) {
+	macros := &prog.state.macros
+
+	if name == '@' {
+		name = macros.lastPlayed
+		if name == 0 {
+			prog.setError(fmt.Errorf("No previously used register"))
+			return
+		}
+	}
+	if !isNamedRegister(name) {
+		prog.commandFailed()
+		return
+	}
+
+	reg := prog.state.registers.named[unicode.ToLower(name)-'a']
+	if reg.isEmpty() {
+		prog.setError(fmt.Errorf("Nothing in register %c", name))
+		return
+	}
+	if macros.depth >= maxMacroDepth {
+		prog.setError(fmt.Errorf("Macros are playing each other too deeply"))
+		return
+	}
+
+	macros.lastPlayed = name
+	if macros.depth == 0 {
+		macros.failed = false
+	}
+
+	// Keeping the keys of the `@` command apart from the keys that
+	// are played, so `.` can still repeat a change made by the macro
+	d := &prog.state.dot
+	outerKeys, outerCountKeys, outerSkip := d.keys, d.countKeys, d.skipCommand
+	d.keys, d.countKeys, d.skipCommand = nil, nil, false
+
+	macros.depth++
+	defer func() {
+		macros.depth--
+		d.keys, d.countKeys, d.skipCommand = outerKeys, outerCountKeys, outerSkip
+		if macros.depth == 0 {
+			macros.failed = false
+		}
+	}()
+
+	keys := []rune(strings.Join(reg.text, "\n"))
+
+	for i := 0; i < count && !macros.failed; i++ {
+		it := NewStaticInputIterator(keys)
+		for !macros.failed && !prog.state.shouldExit {
+			done, input, _ := it.Next()
+			if done {
+				break
+			}
+			processInput(prog, input)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRecordMacro(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("qaxlq")
+
+	reg := p.state.registers.named[0]
+	if len(reg.text) != 1 || reg.text[0] != "xl" {
+		t.Errorf("wanted register a to hold `xl`, got %q", reg.text)
+	}
+	if p.state.macros.recording != 0 {
+		t.Errorf("wanted recording to stop")
+	}
+}
+
+func TestRecordMacroAppends(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("qaxqqAjq")
+
+	if reg := p.state.registers.named[0]; len(reg.text) != 1 || reg.text[0] != "xj" {
+		t.Errorf("wanted register a to hold `xj`, got %q", reg.text)
+	}
+}
+
+func TestRecordMacroLeavesUnnamedRegister(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ylqalqp")
+	p.assertBufferContent(t, "abac")
+}
+
+func TestPlayMacro(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a1\na2\na3\na4", "qa0xjq@a", "1\n2\na3\na4"},
+		{"a1\na2\na3\na4", "qa0xjq2@a", "1\n2\n3\na4"},
+		{"a1\na2\na3\na4", "qa0xjq@a@@", "1\n2\n3\na4"},
+		{"foo\nbar", "qa0i!\x1bjq@a", "!foo\n!bar"},
+	})
+}
+
+func TestPlayMacroStopsAtFailedMotion(t *testing.T) {
+	// The `j` fails on the last line, so the count isn't used up
+	p := testingProgramFromBuf("a1\na2\na3")
+	p.processKeys("qa0xjq9@a")
+	p.assertBufferContent(t, "1", "2", "3")
+
+	p = testingProgramFromBuf("a1\na2\na3")
+	p.processKeys("qajq9@a")
+	p.assertLogicalPos(t, 0, 2)
+}
+
+func TestPlayRecursiveMacro(t *testing.T) {
+	// Clearing the register first, so the macro doesn't
+	// play anything while it's being recorded
+	p := testingProgramFromBuf("a1\na2\na3\na4")
+	p.processKeys("qaqqa0xj@aq@a")
+	p.assertBufferContent(t, "1", "2", "3", "4")
+}
+
+func TestPlayMacroPlayingItself(t *testing.T) {
+	// Nothing in this macro fails, so only the depth limit stops it
+	p := testingProgramFromBuf("abc")
+	p.state.registers.named[0] = Register{text: []string{"@a"}, kind: Charwise}
+	p.processKeys("@a")
+
+	if !p.state.statusIsError {
+		t.Errorf("wanted an error about macros playing too deeply")
+	}
+}
+
+func TestPlayEmptyRegister(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("@b")
+
+	if p.state.statusMessage != "Nothing in register b" {
+		t.Errorf("wanted an error about register b, got %q", p.state.statusMessage)
+	}
+}
+
+func TestMacroUndoAndRepeat(t *testing.T) {
+	// Each change in a macro is its own undo step
+	p := testingProgramFromBuf("abcdef")
+	p.processKeys("qaxxq@au")
+	p.assertBufferContent(t, "def")
+
+	// `.` repeats the last change made by the macro
+	p = testingProgramFromBuf("abcdef")
+	p.processKeys("qadlq@a.")
+	p.assertBufferContent(t, "def")
+}
+
+func TestQDoesNotQuit(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("q")
+	if p.state.shouldExit {
+		t.Errorf("wanted q to wait for a register, not quit")
+	}
+
+	p.settings.normalModeKeybind.closeBuffer = 'Q'
+	p.processKeys("\x1bQ")
+	if !p.state.shouldExit {
+		t.Errorf("wanted the close key to quit")
+	}
+}
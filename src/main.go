@@ -6,6 +6,7 @@ import (
 	xterm "golang.org/x/term"
 	"os"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -32,28 +33,10 @@ func main() {
 		return
 	}
 
-	file, err := os.Open(filepath)
+	lines, err := readFileAsBufferLines(filepath)
 
 	if err != nil {
-		fmt.Printf("Error opening file %s: %v\n", filepath, err)
-		return
-	}
-
-	// Ensuring the file is closed when the program exits
-	defer file.Close()
-
-	// Loading the file contents into a list of strings, line by line
-	lines := []BufferLine{}
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		lines = append(lines, BufferLine{
-			content: scanner.Text(),
-		})
-	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error scanning file %s\n", err)
+		fmt.Printf("Error loading file: %v\n", err)
 		return
 	}
 
@@ -62,18 +45,20 @@ func main() {
 			filepath:          filepath,
 			lines:             lines,
 			topVisibleLineIdx: 0,
+			modTime:           fileModTime(filepath),
 		},
 	}
 
 	program := Program[ANSI]{
 		logger:   getLogger("./logfile.log.txt"),
 		state:    ProgramState{},
-		term:     ANSI{},
+		term:     ANSI{out: bufio.NewWriterSize(os.Stdout, 64*1024)},
 		settings: defaultSettings(),
 	}
 
 	program.state.buffers = buffers
 	program.setCWD(filepath)
+	program.loadUndoFile(&program.state.buffers[0])
 
 	// Saving the current state of the terminal,
 	// and re-loading it when this program exits
@@ -85,74 +70,107 @@ func main() {
 
 	// Resetting cursor position and terminal state after the program closes.
 	// This isn't exactly true, because we're not resetting it exactly as it was.
-	defer program.term.setCursorPosition(0, 0)
+	defer func() {
+		program.term.disableMouseAndPaste()
+		program.term.setCursorPosition(0, 0)
+		program.term.flush()
+	}()
 	defer xterm.Restore(int(os.Stdin.Fd()), oldTerminalState)
 
+	program.term.enableMouseAndPaste()
+	program.term.requestModeReport(synchronizedOutputMode)
+
 	initializeState(&program)
 
-	inputIterator := NewStdinIterator()
+	loop := newEventLoop(
+		InputProducer{it: NewStdinIterator()},
+		newResizeProducer(),
+		TickProducer{interval: time.Second},
+	)
 
-	runMainLoop(&program, inputIterator)
+	runMainLoop(&program, loop)
 }
 
-func runMainLoop[T Terminal](prog *Program[T], inputIterator InputIterator) {
+// Redrawing and handling events until the input ends or the program
+// exits, and then stopping everything that was sending events
+func runMainLoop[T Terminal](prog *Program[T], loop *EventLoop) {
+	prog.state.events = loop
+	defer func() {
+		prog.state.events = nil
+		loop.stop()
+	}()
+
 	for {
 		prog.updateTopChrome()
 
-		if true || prog.state.needsRedraw {
-			redraw(prog)
-		}
+		// Redrawing after every event, which is cheap
+		// since only the cells that changed are sent
+		redraw(prog)
 
-		done, input, err := inputIterator.Next()
-		if err != nil {
-			prog.logger(fmt.Sprintf("Error reading input: %v", err))
-			break
-		}
-		if done {
-			break
+		if !handleEvent(prog, loop.next()) {
+			return
 		}
+	}
+}
 
-		if prog.state.currentMode == NormalMode {
-			normalMode(input, prog)
-		} else if prog.state.currentMode == InsertMode {
-			insertMode(input, prog)
-		}
+// Handling one input, along with the undo steps
+// and the change for `.` that it's part of
+func processInput[T Terminal](prog *Program[T], input rune) {
+	prog.markUndoCursor()
 
-		if prog.state.shouldExit {
-			return
-		}
+	modeBefore := prog.state.currentMode
+	dispatchInput(prog, input)
+	prog.recordChangeKey(input, modeBefore)
+
+	prog.commitUndoSteps()
+}
+
+// Handling one input in whichever mode the program is in
+func dispatchInput[T Terminal](prog *Program[T], input rune) {
+	if prog.state.currentMode == NormalMode {
+		normalMode(input, prog)
+	} else if prog.state.currentMode == InsertMode {
+		insertMode(input, prog)
+	} else if prog.state.currentMode == CommandMode {
+		commandMode(input, prog)
+	} else if prog.state.currentMode == VisualMode {
+		visualMode(input, prog)
+	} else if prog.state.currentMode == ConfirmMode {
+		confirmMode(input, prog)
+	} else if prog.state.currentMode == ReplaceMode {
+		replaceMode(input, prog)
 	}
 }
 
+// Drawing the whole frame into the screen's back grid, and then
+// sending the terminal only the cells that changed since the last one
 func redraw[T Terminal](prog *Program[T]) {
 	s := &prog.state
 	settings := &prog.settings
+	screen := &s.screen
 
-	prog.term.clearScreen()
-	prog.setVisualCursorPosition(0, 0)
+	screen.clear()
 
-	// Printing top chrome content
+	// Drawing top chrome content
 	for i := 0; i < s.topChromeHeight; i++ {
-		line := s.topChromeContent[i]
-		prog.term.printf("%s", line)
-		prog.setVisualCursorPosition(s.leftChromeWidth, s.visualCursorY+1)
+		screen.drawText(0, i, []rune(s.topChromeContent[i]), StyleNormal, screen.width)
 	}
 
 	tab := prog.state.tabs[prog.state.activeTabIdx]
 	panel := &tab.panels[tab.activePanelIdx]
-	prog.setVisualCursorPosition(panel.topLeftX, panel.topLeftY)
 	buffer := &s.buffers[panel.bufferIdx]
 
 	visualCursorX := 0
 	visualCursorY := 0
 
+	selectedColumns := prog.selectedColumnsFunc()
+	searchMatchColumns := prog.searchMatchColumnsFunc()
+
 	for idx, panel := range tab.panels {
 		isActivePanel := idx == tab.activePanelIdx
 
-		prog.setVisualCursorPosition(panel.topLeftX, panel.topLeftY)
-
 		// Drawing an individual panel
-		for y := 0; y <= panel.height; y++ {
+		for y := 0; y < panel.height; y++ {
 
 			lineIdx := y + buffer.topVisibleLineIdx
 
@@ -172,16 +190,41 @@ func redraw[T Terminal](prog *Program[T]) {
 				visualCursorX = panel.topLeftX + x
 			}
 
-			// Doing whitespace-related formatting, and printing the current line
-			line = replaceTabsWithSpaces(line, settings.tabstop, settings.tabchar)
-			lastCharIdx := min(panel.width, len(line))
-			prog.term.printf("%s", line[:lastCharIdx])
+			// Doing whitespace-related formatting, and drawing the current line
+			runes := []rune(replaceTabsWithSpaces(line, settings.tabstop, settings.tabchar))
+			screenY := panel.topLeftY + y
 
-			prog.setVisualCursorPosition(panel.topLeftX, s.visualCursorY+1)
+			if from, to, ok := selectedColumns(lineIdx); isActivePanel && ok {
+				prog.drawHighlighted(panel.topLeftX, screenY, runes, []ColumnSpan{{from, to}}, panel.width)
+			} else if spans := searchMatchColumns(lineIdx); isActivePanel && len(spans) > 0 {
+				prog.drawHighlighted(panel.topLeftX, screenY, runes, spans, panel.width)
+			} else {
+				screen.drawText(panel.topLeftX, screenY, runes, StyleNormal, panel.width)
+			}
 		}
 	}
 
-	prog.setVisualCursorPosition(visualCursorX, visualCursorY)
+	// Drawing bottom chrome content, which is either
+	// the command line being typed, or the last status
+	bottomChromeY := s.termHeight - s.bottomChromeHeight
+	bottomChrome := s.statusMessage
+
+	if s.currentMode == CommandMode {
+		cl := &s.commandLine
+		bottomChrome = string(cl.prompt) + string(cl.text)
+		visualCursorX = charWidth(cl.prompt) + textWidth(cl.text[:cl.cursorX])
+		visualCursorY = bottomChromeY
+	} else if s.currentMode == VisualMode && s.statusMessage == "" {
+		bottomChrome = visualModeNames[panel.visualKind]
+	} else if s.macros.recording != 0 && s.statusMessage == "" {
+		bottomChrome = fmt.Sprintf("recording @%c", s.macros.recording)
+	}
+	screen.drawText(0, bottomChromeY, []rune(bottomChrome), StyleNormal, screen.width)
+
+	flushScreen(screen, prog.term, visualCursorX, visualCursorY, settings.syncoutput)
+
+	s.lastVisualCursorX, s.lastVisualCursorY = s.visualCursorX, s.visualCursorY
+	s.visualCursorX, s.visualCursorY = visualCursorX, visualCursorY
 	s.needsRedraw = false
 }
 
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// How many entries the jump list and change list keep
+const maxJumps = 100
+
+// A position that was jumped away from, in any buffer
+type Jump struct {
+	bufferIdx int
+	pos       Position
+}
+
+// The positions of recent changes in a buffer, which `g;` and `g,` go
+// back and forth through. idx is the entry last moved to, or len(positions).
+type ChangeList struct {
+	positions []Position
+	idx       int
+}
+
+// Marks are positions in a buffer that are set with `m` and jumped to
+// with `'` or a backtick. Lowercase marks belong to a buffer, and uppercase
+// marks to a file, so they can be jumped to from anywhere. The `'` mark is
+// where the last jump started, and `.` is where the last change was.
+func isMarkName(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || r == '\'' || r == '`' || r == '.'
+}
+
+func (b *Buffer) setMark(name rune, pos Position) {
+	if b.marks == nil {
+		b.marks = map[rune]Position{}
+	}
+	if name == '`' {
+		name = '\''
+	}
+	b.marks[name] = pos
+}
+
+func (b *Buffer) getMark(name rune) (Position, bool) {
+	if name == '`' {
+		name = '\''
+	}
+	pos, ok := b.marks[name]
+	return pos, ok
+}
+
+// Keeping marks on their lines as a line is inserted (delta 1) or
+// removed (delta -1) at lineNum. Marks on a removed line go with it,
+// and changes on it move to the line that takes its place.
+func (b *Buffer) adjustMarks(lineNum int, delta int) {
+	for name, pos := range b.marks {
+		switch {
+		case delta < 0 && pos.y == lineNum:
+			delete(b.marks, name)
+		case pos.y > lineNum || (delta > 0 && pos.y == lineNum):
+			b.marks[name] = Position{pos.x, pos.y + delta}
+		}
+	}
+
+	for i, pos := range b.changes.positions {
+		if pos.y > lineNum || (delta > 0 && pos.y == lineNum) {
+			b.changes.positions[i].y += delta
+		}
+	}
+}
+
+// Setting a mark at the cursor. An uppercase mark is moved from
+// whichever buffer had it, since only one file can have it.
+func (prog *Program[T]) setMarkAtCursor(name rune) {
+	if !isMarkName(name) || name == '.' {
+		prog.commandFailed()
+		return
+	}
+
+	panel := prog.getActivePanel()
+	pos := Position{panel.logicalCursorX, panel.logicalCursorY}
+
+	if unicode.IsUpper(name) {
+		if idx, ok := prog.bufferOfFileMark(name); ok {
+			delete(prog.state.buffers[idx].marks, name)
+		}
+		if prog.state.fileMarks == nil {
+			prog.state.fileMarks = map[rune]string{}
+		}
+		prog.state.fileMarks[name] = prog.getActiveBuffer().filepath
+	}
+
+	prog.getActiveBuffer().setMark(name, pos)
+}
+
+// Finding a mark in the buffer a motion is in, and keeping it on an
+// existing char, since the text under it may have shrunk since
+func findMark(m *MotionContext, name rune) (Position, bool) {
+	pos, ok := m.buffer.getMark(name)
+	if !ok {
+		m.setStatus(true, "Mark not set")
+		return m.cursor, false
+	}
+
+	y := max(min(pos.y, m.lastLineIdx()), 0)
+	x := max(min(pos.x, m.lastCharIdx(y)), 0)
+	return Position{x, y}, true
+}
+
+func motionMarkLine(m *MotionContext, count int, name rune) (Position, bool) {
+	pos, ok := findMark(m, name)
+	if !ok {
+		return pos, false
+	}
+	line := m.line(pos.y)
+	return Position{firstNonBlank(string(line)), pos.y}, true
+}
+
+func motionMark(m *MotionContext, count int, name rune) (Position, bool) {
+	return findMark(m, name)
+}
+
+// Switching to the file that has an uppercase mark, before jumping to it.
+// Returning whether the file was switched to.
+func (prog *Program[T]) enterFileOfMark(name rune) bool {
+	idx, ok := prog.bufferOfFileMark(name)
+	if !ok || idx == prog.getActivePanel().bufferIdx {
+		return false
+	}
+
+	prog.recordJump()
+	prog.switchToBuffer(idx)
+	return true
+}
+
+// Finding the buffer of the file that has an uppercase mark
+func (prog *Program[T]) bufferOfFileMark(name rune) (int, bool) {
+	path, ok := prog.state.fileMarks[name]
+	if !ok {
+		return 0, false
+	}
+	return prog.findBuffer(path)
+}
+
+func (prog *Program[T]) switchToBuffer(idx int) {
+	prog.getActivePanel().bufferIdx = idx
+	prog.state.needsRedraw = true
+}
+
+// Remembering the cursor before a jump, in the jump list and as the
+// `'` mark. An older entry for the same line is replaced.
+func (prog *Program[T]) recordJump() {
+	panel := prog.getActivePanel()
+	pos := Position{panel.logicalCursorX, panel.logicalCursorY}
+	prog.getActiveBuffer().setMark('\'', pos)
+
+	jumps := []Jump{}
+	for _, jump := range panel.jumps {
+		if jump.bufferIdx != panel.bufferIdx || jump.pos.y != pos.y {
+			jumps = append(jumps, jump)
+		}
+	}
+	jumps = append(jumps, Jump{bufferIdx: panel.bufferIdx, pos: pos})
+
+	if len(jumps) > maxJumps {
+		jumps = jumps[len(jumps)-maxJumps:]
+	}
+	panel.jumps = jumps
+	panel.jumpIdx = len(jumps)
+}
+
+// Going back through the jump list (step < 0), or forward again (step > 0)
+func (prog *Program[T]) jump(step int) {
+	panel := prog.getActivePanel()
+
+	// Remembering where the cursor is when first going back, so that
+	// going forward again can return to it
+	if step < 0 && panel.jumpIdx >= len(panel.jumps) {
+		prog.recordJump()
+		panel.jumpIdx = len(panel.jumps) - 1
+	}
+
+	target := panel.jumpIdx + step
+	if target < 0 || target >= len(panel.jumps) {
+		prog.commandFailed()
+		return
+	}
+	panel.jumpIdx = target
+
+	jump := panel.jumps[target]
+	if jump.bufferIdx >= len(prog.state.buffers) {
+		return
+	}
+	if jump.bufferIdx != panel.bufferIdx {
+		prog.switchToBuffer(jump.bufferIdx)
+	}
+	prog.moveToClamped(jump.pos)
+}
+
+// Moving the cursor to a position that may no longer
+// exist, or to the nearest one that does
+func (prog *Program[T]) moveToClamped(pos Position) {
+	buffer := prog.getActiveBuffer()
+	y := max(min(pos.y, len(buffer.lines)-1), 0)
+	x := max(min(pos.x, runeCount(buffer.lineContent(y))-1), 0)
+	prog.setLogicalCursorPosition(x, y)
+	prog.scrollToCursor()
+}
+
+// Remembering where a change was made. A change on the
+// same line as the last one replaces it.
+func (b *Buffer) recordChange(pos Position) {
+	list := &b.changes
+	if n := len(list.positions); n > 0 && list.positions[n-1].y == pos.y {
+		list.positions[n-1] = pos
+	} else {
+		list.positions = append(list.positions, pos)
+	}
+
+	if len(list.positions) > maxJumps {
+		list.positions = list.positions[len(list.positions)-maxJumps:]
+	}
+	list.idx = len(list.positions)
+	b.setMark('.', pos)
+}
+
+// Going to an older change (step < 0), or a newer one (step > 0)
+func (prog *Program[T]) goToChange(step int) {
+	list := &prog.getActiveBuffer().changes
+
+	switch target := list.idx + step; {
+	case len(list.positions) == 0:
+		prog.setError(fmt.Errorf("Change list is empty"))
+	case target < 0 && list.idx == 0:
+		prog.setError(fmt.Errorf("At start of changelist"))
+	case target >= len(list.positions) && list.idx >= len(list.positions)-1:
+		prog.setError(fmt.Errorf("At end of changelist"))
+	default:
+		list.idx = max(min(target, len(list.positions)-1), 0)
+		prog.moveToClamped(list.positions[list.idx])
+	}
+}
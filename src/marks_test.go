@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarks(t *testing.T) {
+	p := testingProgramFromBuf("  foo\nbar\nbaz qux")
+	p.processKeys("jlmaG$'a")
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processKeys("G$`a")
+	p.assertLogicalPos(t, 1, 1)
+
+	p.processKeys("gg$mbG'b")
+	p.assertLogicalPos(t, 2, 0)
+}
+
+func TestMarkNotSet(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar")
+	p.processKeys("j'z")
+	p.assertLogicalPos(t, 0, 1)
+	if p.state.statusMessage != "Mark not set" {
+		t.Errorf("wanted an error about the mark, got %q", p.state.statusMessage)
+	}
+}
+
+func TestMarksWithOperators(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a\nb\nc\nd", "majjd'a", "d"},
+		{"foo bar baz", "wmaw`a", "foo bar baz"},
+		{"foo bar baz", "wmawd`a", "foo baz"},
+		{"foo bar baz", "wwmabd`a", "foo baz"},
+	})
+}
+
+func TestMarksFollowLines(t *testing.T) {
+	// Inserting and removing lines above the mark moves it
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("Gmaggyyp'a")
+	p.assertLogicalPos(t, 0, 4)
+	p.processKeys("ggdd'a")
+	p.assertLogicalPos(t, 0, 3)
+
+	// Deleting the mark's line removes the mark
+	p = testingProgramFromBuf("a\nb\nc")
+	p.processKeys("jmaddgg'a")
+	p.assertLogicalPos(t, 0, 0)
+	if p.state.statusMessage != "Mark not set" {
+		t.Errorf("wanted the mark to go with its line, got %q", p.state.statusMessage)
+	}
+
+	// Undoing puts the lines back, and the mark moves with them
+	p = testingProgramFromBuf("a\nb\nc")
+	p.processKeys("Gmaggddu'a")
+	p.assertLogicalPos(t, 0, 2)
+}
+
+func TestPreviousContextMark(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("jG''")
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processKeys("''")
+	p.assertLogicalPos(t, 0, 3)
+}
+
+func TestFileMarks(t *testing.T) {
+	p, path := testingProgramFromFile(t, "a\nb\nc\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	os.WriteFile(other, []byte("one\ntwo\nthree\n"), 0644)
+
+	p.processKeys("jmA:e " + other + "\njjmB'A")
+	if p.getActivePanel().bufferIdx != 0 {
+		t.Fatalf("wanted 'A to go back to the first file")
+	}
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processKeys("'B")
+	if p.getActivePanel().bufferIdx != 1 {
+		t.Fatalf("wanted 'B to go to the other file")
+	}
+	p.assertLogicalPos(t, 0, 2)
+
+	// Setting an uppercase mark again moves it to the new file
+	p.processKeys("ggmA'A")
+	if p.getActivePanel().bufferIdx != 1 {
+		t.Errorf("wanted the mark to have moved")
+	}
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestMarksSurviveReload(t *testing.T) {
+	p, path := testingProgramFromFile(t, "a\nb\nc\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	os.WriteFile(other, []byte("one\n"), 0644)
+
+	p.processKeys("jmajmB:e!\n")
+	p.processKeys("'a")
+	p.assertLogicalPos(t, 0, 1)
+
+	// The file mark still finds the file, after going elsewhere
+	p.processKeys(":e " + other + "\n'B")
+	if p.getActivePanel().bufferIdx != 0 {
+		t.Fatalf("wanted 'B to go back to the reloaded file")
+	}
+	p.assertLogicalPos(t, 0, 2)
+}
+
+func TestJumpList(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd\ne")
+	p.processKeys("jG")
+	p.processKeys("ggjj")
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 4)
+
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 1)
+
+	// Nothing older to go back to
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processInputs(RuneTab)
+	p.assertLogicalPos(t, 0, 4)
+
+	// Ctrl-I returns to where the first Ctrl-O started
+	p.processInputs(RuneTab)
+	p.assertLogicalPos(t, 0, 2)
+
+	// Small moves like `j` aren't jumps
+	p = testingProgramFromBuf("a\nb\nc")
+	p.processKeys("jj")
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 2)
+}
+
+func TestJumpListSearches(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nfoo\nbar")
+	p.processKeys("/bar\nn")
+	p.assertLogicalPos(t, 0, 3)
+
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 1)
+	p.processInputs(RuneCtrlO)
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestJumpListAcrossFiles(t *testing.T) {
+	p, path := testingProgramFromFile(t, "a\nb\nc\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	os.WriteFile(other, []byte("one\ntwo\n"), 0644)
+
+	p.processKeys("jj:e " + other + "\n")
+	p.processInputs(RuneCtrlO)
+	if p.getActivePanel().bufferIdx != 0 {
+		t.Fatalf("wanted Ctrl-O to go back to the first file")
+	}
+	p.assertLogicalPos(t, 0, 2)
+
+	p.processInputs(RuneTab)
+	if p.getActivePanel().bufferIdx != 1 {
+		t.Errorf("wanted Ctrl-I to return to the other file")
+	}
+}
+
+func TestChangeList(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd\ne")
+	p.processKeys("xjjjxgg")
+	p.processKeys("g;")
+	p.assertLogicalPos(t, 0, 3)
+
+	p.processKeys("g;")
+	p.assertLogicalPos(t, 0, 0)
+
+	p.processKeys("g;")
+	if p.state.statusMessage != "At start of changelist" {
+		t.Errorf("wanted an error at the start, got %q", p.state.statusMessage)
+	}
+
+	p.processKeys("g,")
+	p.assertLogicalPos(t, 0, 3)
+
+	// The `.` mark is the last change
+	p.processKeys("gg`.")
+	p.assertLogicalPos(t, 0, 3)
+
+	p = testingProgramFromBuf("a")
+	p.processKeys("g;")
+	if p.state.statusMessage != "Change list is empty" {
+		t.Errorf("wanted an error for an empty list, got %q", p.state.statusMessage)
+	}
+}
+
+func TestChangeListFollowsLines(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys("Gxggyypgg")
+	p.processKeys("2g;")
+	p.assertLogicalPos(t, 0, 3)
+}
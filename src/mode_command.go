@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+)
+
+// The line typed at the bottom of the screen, after a prompt like ':'
+type CommandLine struct {
+	prompt  rune
+	text    []rune
+	cursorX int
+
+	// The history entry being shown, and the text that was typed before
+	// browsing started. Only entries starting with that text are shown.
+	historyIdx    int
+	historyPrefix string
+
+	// The candidates being cycled through with Tab,
+	// and where the completed word starts
+	completions     []string
+	completionIdx   int
+	completionStart int
+}
+
+const maxCommandHistory = 100
+
+func (prog *Program[T]) openCommandLine(prompt rune) {
+	prog.state.statusMessage = ""
+	prog.state.commandLine = CommandLine{
+		prompt:     prompt,
+		historyIdx: len(*prog.commandLineHistory(prompt)),
+	}
+	prog.changeMode(CommandMode)
+}
+
+// Searches and commands each have their own history
+func (prog *Program[T]) commandLineHistory(prompt rune) *[]string {
+	if isSearchPrompt(prompt) {
+		return &prog.state.searchHistory
+	}
+	return &prog.state.commandHistory
+}
+
+// Leaving the command line without running it, which puts
+// the cursor back if a search had moved it
+func (prog *Program[T]) cancelCommandLine() {
+	if isSearchPrompt(prog.state.commandLine.prompt) {
+		prog.restoreSearchOrigin()
+	}
+	prog.changeMode(NormalMode)
+}
+
+func commandMode[T Terminal](input rune, prog *Program[T]) {
+	s := &prog.state
+	cl := &s.commandLine
+	history := prog.commandLineHistory(cl.prompt)
+	s.needsRedraw = true
+
+	// Any key other than Tab ends a round of completion
+	if input != RuneTab {
+		cl.completions = nil
+	}
+
+	switch {
+	case input == RuneEscape:
+		prog.cancelCommandLine()
+		return
+
+	case input == RuneEnter || input == RuneCarriageReturn:
+		line := string(cl.text)
+		prog.changeMode(NormalMode)
+		*history = appendHistory(*history, line)
+		if isSearchPrompt(cl.prompt) {
+			prog.runSearch(line, cl.prompt == '/')
+		} else {
+			prog.executeCommandLine(line)
+		}
+		return
+
+	case input == RuneBackspace || input == RuneDelete:
+		// Leaving command mode when backspacing over the prompt
+		if len(cl.text) == 0 {
+			prog.cancelCommandLine()
+			return
+		}
+		if cl.cursorX > 0 {
+			cl.text = append(cl.text[:cl.cursorX-1], cl.text[cl.cursorX:]...)
+			cl.cursorX--
+		}
+
+	case input == RuneLeftArrow:
+		cl.cursorX = max(cl.cursorX-1, 0)
+
+	case input == RuneRightArrow:
+		cl.cursorX = min(cl.cursorX+1, len(cl.text))
+
+	case input == RuneHome:
+		cl.cursorX = 0
+
+	case input == RuneEnd:
+		cl.cursorX = len(cl.text)
+
+	case input == RuneUpArrow:
+		cl.browseHistory(*history, -1)
+
+	case input == RuneDownArrow:
+		cl.browseHistory(*history, 1)
+
+	case input == RuneTab && cl.prompt == ':':
+		prog.completeCommandLine()
+
+	case isStandardUnicode(input):
+		cl.insert(string(input))
+	}
+
+	// Searching as the pattern is typed
+	if isSearchPrompt(cl.prompt) {
+		prog.previewSearch()
+	}
+}
+
+func (cl *CommandLine) insert(text string) {
+	runes := []rune(text)
+	updated := append([]rune{}, cl.text[:cl.cursorX]...)
+	updated = append(updated, runes...)
+	cl.text = append(updated, cl.text[cl.cursorX:]...)
+	cl.cursorX += len(runes)
+}
+
+func (cl *CommandLine) setText(text string) {
+	cl.text = []rune(text)
+	cl.cursorX = len(cl.text)
+}
+
+// Moving through the history by one matching entry, in the given direction.
+// Moving past the newest entry restores whatever was typed originally.
+func (cl *CommandLine) browseHistory(history []string, direction int) {
+	if cl.historyIdx == len(history) {
+		cl.historyPrefix = string(cl.text)
+	}
+
+	for idx := cl.historyIdx + direction; idx >= 0 && idx <= len(history); idx += direction {
+		if idx == len(history) {
+			cl.historyIdx = idx
+			cl.setText(cl.historyPrefix)
+			return
+		}
+
+		if strings.HasPrefix(history[idx], cl.historyPrefix) {
+			cl.historyIdx = idx
+			cl.setText(history[idx])
+			return
+		}
+	}
+}
+
+// Adding a line to the end of a history, removing older duplicates of it
+func appendHistory(history []string, line string) []string {
+	if strings.TrimSpace(line) == "" {
+		return history
+	}
+
+	updated := []string{}
+	for _, entry := range history {
+		if entry != line {
+			updated = append(updated, entry)
+		}
+	}
+	updated = append(updated, line)
+
+	if len(updated) > maxCommandHistory {
+		updated = updated[len(updated)-maxCommandHistory:]
+	}
+
+	return updated
+}
+
+// Replacing the word before the cursor with the next completion candidate
+func (prog *Program[T]) completeCommandLine() {
+	cl := &prog.state.commandLine
+
+	if cl.completions == nil {
+		start, candidates := prog.commandLineCompletions(cl.text[:cl.cursorX])
+		if len(candidates) == 0 {
+			return
+		}
+		cl.completions = candidates
+		cl.completionStart = start
+		cl.completionIdx = 0
+	} else {
+		cl.completionIdx = (cl.completionIdx + 1) % len(cl.completions)
+	}
+
+	completion := []rune(cl.completions[cl.completionIdx])
+	rest := cl.text[cl.cursorX:]
+
+	updated := append([]rune{}, cl.text[:cl.completionStart]...)
+	updated = append(updated, completion...)
+	cl.cursorX = len(updated)
+	cl.text = append(updated, rest...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd\ne")
+	p.processKeys(":2,4d\n")
+	p.assertBufferContent(t, "a", "e")
+	p.assertLogicalPos(t, 0, 1)
+}
+
+func TestDeleteEverythingLeavesOneLine(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys(":%d\n")
+	p.assertBufferContent(t, "")
+
+	if len(p.getActiveBuffer().lines) != 1 {
+		t.Errorf("expected a single empty line")
+	}
+}
+
+func TestGoToLine(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys(":3\n")
+	p.assertLogicalPos(t, 0, 2)
+}
+
+func TestUnknownCommandIsReported(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":frobnicate\n")
+
+	if !p.state.statusIsError {
+		t.Errorf("expected an error in the status")
+	}
+}
+
+func TestSetSettings(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":set tabstop=8 nocxo\n")
+
+	if p.settings.tabstop != 8 {
+		t.Errorf("wanted tabstop=8, got %d", p.settings.tabstop)
+	}
+	if p.settings.cursor_x_overflow {
+		t.Errorf("expected cursorxoverflow to be disabled")
+	}
+
+	p.processKeys(":se cxo! ts?\n")
+
+	if !p.settings.cursor_x_overflow {
+		t.Errorf("expected cursorxoverflow to be toggled back on")
+	}
+	if p.state.statusMessage != "tabstop=8" {
+		t.Errorf("wanted status `tabstop=8`, got `%s`", p.state.statusMessage)
+	}
+}
+
+func TestSetRejectsInvalidValues(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":set ts=0\n")
+
+	if p.settings.tabstop != 4 || !p.state.statusIsError {
+		t.Errorf("expected tabstop=0 to be rejected")
+	}
+}
+
+func TestCommandNameCompletion(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":wr\t")
+
+	if actual := string(p.state.commandLine.text); actual != "write" {
+		t.Errorf("wanted `write`, got `%s`", actual)
+	}
+}
+
+func TestCompletionCyclesThroughCandidates(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys(":set tab\t")
+
+	if actual := string(p.state.commandLine.text); actual != "set tabchar" {
+		t.Errorf("wanted `set tabchar`, got `%s`", actual)
+	}
+
+	p.processKeys("\t")
+
+	if actual := string(p.state.commandLine.text); actual != "set tabnamesfull" {
+		t.Errorf("wanted `set tabnamesfull`, got `%s`", actual)
+	}
+}
+
+func TestCommandHistory(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys(":set ts=2\n:3\n:")
+	p.processInputs(RuneUpArrow)
+
+	if actual := string(p.state.commandLine.text); actual != "3" {
+		t.Errorf("wanted `3`, got `%s`", actual)
+	}
+
+	p.processInputs(RuneUpArrow)
+
+	if actual := string(p.state.commandLine.text); actual != "set ts=2" {
+		t.Errorf("wanted `set ts=2`, got `%s`", actual)
+	}
+
+	p.processInputs(RuneDownArrow, RuneDownArrow)
+
+	if actual := string(p.state.commandLine.text); actual != "" {
+		t.Errorf("wanted the original empty text, got `%s`", actual)
+	}
+}
+
+func TestCommandHistoryMatchesTypedPrefix(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys(":set ts=2\n:3\n:se")
+	p.processInputs(RuneUpArrow)
+
+	if actual := string(p.state.commandLine.text); actual != "set ts=2" {
+		t.Errorf("wanted `set ts=2`, got `%s`", actual)
+	}
+}
@@ -1,5 +1,83 @@
 package main
 
+// Starting an insert session with a count, like `3ix<Esc>`, repeats
+// the keys typed in it once it's left. Each repeat of `o` or `O`
+// opens another line first.
+type InsertRepeat struct {
+	count     int
+	opensLine bool
+	keys      []rune
+}
+
+func (prog *Program[T]) startInsert(count int, opensLine bool) {
+	prog.state.insertRepeat = nil
+	if count > 1 {
+		prog.state.insertRepeat = &InsertRepeat{count: count, opensLine: opensLine}
+	}
+	prog.changeMode(InsertMode)
+}
+
+// Entering insert mode after moving the cursor, where `A` is `$` and
+// then inserting after the cursor. An empty motionKey stays put.
+func (prog *Program[T]) insertAfterMotion(motionKey string, afterCursor bool, count int) {
+	if motionKey != "" {
+		if target, ok := Motions[motionKey].move(prog.motionContext(), 1); ok {
+			prog.setLogicalCursorPosition(target.x, target.y)
+		}
+	}
+
+	if afterCursor {
+		panel := prog.getActivePanel()
+		lineLength := runeCount(prog.getActiveBuffer().lineContent(panel.logicalCursorY))
+		prog.setLogicalCursorPosition(min(panel.logicalCursorX+1, lineLength), panel.logicalCursorY)
+	}
+
+	prog.startInsert(count, false)
+}
+
+// Opening a line below or above the cursor's line, indented
+// the same way, and moving to the end of its indent
+func (prog *Program[T]) openLine(below bool) {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+
+	y := panel.logicalCursorY
+	indent := leadingWhitespace(buffer.lineContent(y))
+	if below {
+		y++
+	}
+
+	buffer.insertLine(y, indent)
+	prog.setLogicalCursorPosition(runeCount(indent), y)
+}
+
+// Typing the keys of an insert session again, for the rest of its count
+func (prog *Program[T]) finishInsertRepeat() {
+	rep := prog.state.insertRepeat
+	prog.state.insertRepeat = nil
+	if rep == nil {
+		return
+	}
+
+	for i := 1; i < rep.count; i++ {
+		if rep.opensLine {
+			prog.openLine(true)
+		}
+		for _, key := range rep.keys {
+			insertMode(key, prog)
+		}
+	}
+}
+
+// Moving the cursor back onto the last char that was typed, like vim
+// does when leaving insert mode, so it isn't left past the end of a line
+func (prog *Program[T]) stepBackFromInsert() {
+	panel := prog.getActivePanel()
+	line := prog.getActiveBuffer().lineContent(panel.logicalCursorY)
+	x := max(min(panel.logicalCursorX-1, runeCount(line)-1), 0)
+	prog.setLogicalCursorPosition(x, panel.logicalCursorY)
+}
+
 func splice(original string, position int, r rune) string {
 	slice := []rune(original)
 
@@ -14,10 +92,17 @@ func splice(original string, position int, r rune) string {
 
 func insertMode[T Terminal](input rune, prog *Program[T]) {
 	if input == RuneEscape {
+		prog.finishBlockInsert()
+		prog.finishInsertRepeat()
 		prog.changeMode(NormalMode)
+		prog.stepBackFromInsert()
 		return
 	}
 
+	if rep := prog.state.insertRepeat; rep != nil {
+		rep.keys = append(rep.keys, input)
+	}
+
 	panel := prog.getActivePanel()
 	buffer := prog.getActiveBuffer()
 	line := buffer.lines[panel.logicalCursorY].content
@@ -60,8 +145,9 @@ func insertMode[T Terminal](input rune, prog *Program[T]) {
 	}
 
 	if input == RuneEnter || input == RuneCarriageReturn {
-		left := line[:panel.logicalCursorX]
-		right := line[panel.logicalCursorX:]
+		runes := []rune(line)
+		left := string(runes[:panel.logicalCursorX])
+		right := string(runes[panel.logicalCursorX:])
 
 		buffer.updateLine(panel.logicalCursorY, left)
 		buffer.insertLine(panel.logicalCursorY+1, right)
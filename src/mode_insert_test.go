@@ -27,3 +27,67 @@ func TestBackspaceCanJoinLines(t *testing.T) {
 	p.processInputs('j', 'i', RuneBackspace)
 	p.assertBufferContent(t, "abcdef")
 }
+
+func TestInsertEntryCommands(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"abc", "ax\x1b", "axbc"},
+		{"abc", "$ax\x1b", "abcx"},
+		{"", "ax\x1b", "x"},
+		{"  abc", "$Ix\x1b", "  xabc"},
+		{"abc def", "Ax\x1b", "abc defx"},
+		{"a\nb", "ox\x1b", "a\nx\nb"},
+		{"a\nb", "jOx\x1b", "a\nx\nb"},
+		{"a", "Ox\x1b", "x\na"},
+	})
+}
+
+func TestOpenLineKeepsIndent(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"  a", "ox\x1b", "  a\n  x"},
+		{"\ta", "Ox\x1b", "\tx\n\ta"},
+	})
+
+	p := testingProgramFromBuf("    a")
+	p.processKeys("o")
+	p.assertLogicalPos(t, 4, 1)
+}
+
+func TestInsertEntryCounts(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a", "3ix\x1b", "xxxa"},
+		{"a", "2Axy\x1b", "axyxy"},
+		{"a\nb", "3ox\x1b", "a\nx\nx\nx\nb"},
+		{"a", "2Ox\x1b", "x\nx\na"},
+		{"  a", "2ox\x1b", "  a\n  x\n  x"},
+	})
+}
+
+func TestInsertEntryIsOneUndoStep(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys("3ox\x1bu")
+	p.assertBufferContent(t, "a")
+
+	p.processKeys("Axy\x1bu")
+	p.assertBufferContent(t, "a")
+}
+
+func TestInsertEntryRepeats(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a\nb", "Ax\x1bj.", "ax\nbx"},
+		{"a", "2ox\x1b.", "a\nx\nx\nx\nx"},
+	})
+}
+
+func TestEscapeStepsBackOntoTypedChar(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"ab", "Axy\x1bx", "abx"},
+		{"ab", "axy\x1bx", "axb"},
+		{"ab", "ixy\x1bx", "xab"},
+		{"ab", "i\x1bx", "b"},
+		{"", "A\x1bx", ""},
+	})
+
+	p := testingProgramFromBuf("ab")
+	p.processKeys("Axy\x1b")
+	p.assertLogicalPos(t, 3, 0)
+}
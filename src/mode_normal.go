@@ -1,5 +1,10 @@
 package main
 
+import (
+	"strings"
+	"unicode"
+)
+
 type NormalModeKeyBindings struct {
 	cursorUp        rune
 	cursorDown      rune
@@ -12,6 +17,11 @@ type NormalModeKeyBindings struct {
 	insertBelow     rune
 	insertLineStart rune
 	insertLineEnd   rune
+	commandLine     rune
+	undo            rune
+	redo            rune
+	putOlder        rune
+	putNewer        rune
 }
 
 var DefaultNormalModeKeyBindings = NormalModeKeyBindings{
@@ -19,42 +29,464 @@ var DefaultNormalModeKeyBindings = NormalModeKeyBindings{
 	cursorDown:  'j',
 	cursorLeft:  'h',
 	cursorRight: 'l',
-	closeBuffer: 'q',
-	insertLeft:  'i',
 
-	// TODO these should probably be macros that move the cursor, then insertLeft
+	insertLeft:      'i',
 	insertRight:     'a',
-	insertAbove:     'o',
-	insertBelow:     'O',
+	insertAbove:     'O',
+	insertBelow:     'o',
 	insertLineStart: 'I',
 	insertLineEnd:   'A',
+
+	commandLine: ':',
+	undo:        'u',
+	redo:        RuneCtrlR,
+	putOlder:    RuneCtrlP,
+	putNewer:    RuneCtrlN,
+}
+
+// Translating a configured key into the key that
+// the command tables use for the same command
+func (keys *NormalModeKeyBindings) canonicalKey(input rune) rune {
+	switch input {
+	case keys.cursorUp, RuneUpArrow:
+		return 'k'
+	case keys.cursorDown, RuneDownArrow:
+		return 'j'
+	case keys.cursorLeft, RuneLeftArrow:
+		return 'h'
+	case keys.cursorRight, RuneRightArrow:
+		return 'l'
+	case keys.insertLeft:
+		return 'i'
+	case keys.insertRight:
+		return 'a'
+	case keys.insertAbove:
+		return 'O'
+	case keys.insertBelow:
+		return 'o'
+	case keys.insertLineStart:
+		return 'I'
+	case keys.insertLineEnd:
+		return 'A'
+	case keys.commandLine:
+		return ':'
+	case keys.undo:
+		return 'u'
+	case keys.redo:
+		return RuneCtrlR
+	case keys.putOlder:
+		return RuneCtrlP
+	case keys.putNewer:
+		return RuneCtrlN
+	}
+	return input
+}
+
+// A normal mode command is typed as [count]operator[count]motion,
+// or as [count]command. This tracks how much of it has been typed.
+type NormalCommandState struct {
+	count1   int
+	operator string
+	count2   int
+
+	// The keys of the operator, motion or command being typed
+	keys []rune
+
+	// Typing `"` picks the register that the command uses
+	awaitingRegister bool
+	hasRegister      bool
+
+	// Motions like `f` wait for one more key, which is
+	// their argument rather than part of a command
+	awaitingChar bool
+	hasCharArg   bool
+	charArg      rune
+}
+
+func (st *NormalCommandState) count() int {
+	return max(st.count1, 1) * max(st.count2, 1)
+}
+
+func (st *NormalCommandState) hasCount() bool {
+	return st.count1 > 0 || st.count2 > 0
+}
+
+// Whether nothing of a command has been typed yet, either
+// because it was just run, or because it was given up on
+func (st *NormalCommandState) isIdle() bool {
+	return len(st.keys) == 0 && st.operator == "" && !st.hasCount() &&
+		!st.hasRegister && !st.awaitingRegister && !st.awaitingChar
+}
+
+// Waiting for the char argument of a motion like `f`, or binding
+// it once typed. Returning false while still waiting.
+func (st *NormalCommandState) bindCharArg(motion Motion) (Motion, bool) {
+	if motion.moveWithChar == nil {
+		return motion, true
+	}
+	if !st.hasCharArg {
+		st.awaitingChar = true
+		return motion, false
+	}
+	return motion.withChar(st.charArg), true
+}
+
+// Commands that are neither operators nor motions
+type NormalCommand[T Terminal] struct {
+	run func(prog *Program[T], count int)
+
+	// Commands like `q` take one more key as their argument, and use this
+	runWithChar func(prog *Program[T], count int, char rune)
+
+	// Commands like `.` change the buffer, but shouldn't
+	// become the change that `.` repeats
+	notRepeatable bool
+}
+
+func normalCommandTable[T Terminal]() map[string]NormalCommand[T] {
+	return map[string]NormalCommand[T]{
+		"i": {run: func(prog *Program[T], count int) {
+			prog.insertAfterMotion("", false, count)
+		}},
+		"a": {run: func(prog *Program[T], count int) {
+			prog.insertAfterMotion("", true, count)
+		}},
+		"I": {run: func(prog *Program[T], count int) {
+			prog.insertAfterMotion("^", false, count)
+		}},
+		"A": {run: func(prog *Program[T], count int) {
+			prog.insertAfterMotion("$", true, count)
+		}},
+		"o": {run: func(prog *Program[T], count int) {
+			prog.openLine(true)
+			prog.startInsert(count, true)
+		}},
+		"O": {run: func(prog *Program[T], count int) {
+			prog.openLine(false)
+			prog.startInsert(count, true)
+		}},
+		"R": {run: func(prog *Program[T], count int) {
+			prog.startReplace()
+		}},
+		"r": {runWithChar: func(prog *Program[T], count int, char rune) {
+			prog.replaceChars(char, count)
+		}},
+		":": {run: func(prog *Program[T], count int) {
+			prog.openCommandLine(':')
+		}},
+		"/": {run: func(prog *Program[T], count int) {
+			prog.openSearch(true, count)
+		}},
+		"?": {run: func(prog *Program[T], count int) {
+			prog.openSearch(false, count)
+		}},
+		"u": {run: func(prog *Program[T], count int) {
+			prog.undo(count)
+		}},
+		string(RuneCtrlR): {run: func(prog *Program[T], count int) {
+			prog.redo(count)
+		}},
+		"g-": {run: func(prog *Program[T], count int) {
+			prog.undoChronologically(-count)
+		}},
+		"g+": {run: func(prog *Program[T], count int) {
+			prog.undoChronologically(count)
+		}},
+		"x": {run: func(prog *Program[T], count int) {
+			prog.operateWithMotion("d", "l", count)
+		}},
+		"X": {run: func(prog *Program[T], count int) {
+			prog.operateWithMotion("d", "h", count)
+		}},
+		"p": {run: func(prog *Program[T], count int) {
+			prog.putRegister(true, count)
+		}},
+		"P": {run: func(prog *Program[T], count int) {
+			prog.putRegister(false, count)
+		}},
+		string(RuneCtrlP): {notRepeatable: true, run: func(prog *Program[T], count int) {
+			prog.cyclePut(count)
+		}},
+		string(RuneCtrlN): {notRepeatable: true, run: func(prog *Program[T], count int) {
+			prog.cyclePut(-count)
+		}},
+		".": {notRepeatable: true, run: func(prog *Program[T], count int) {
+			prog.repeatLastChange(count)
+		}},
+		"v": {run: func(prog *Program[T], count int) {
+			prog.startVisual(Charwise)
+		}},
+		"V": {run: func(prog *Program[T], count int) {
+			prog.startVisual(Linewise)
+		}},
+		string(RuneCtrlV): {run: func(prog *Program[T], count int) {
+			prog.startVisual(Blockwise)
+		}},
+		"gv": {run: func(prog *Program[T], count int) {
+			prog.restoreVisual()
+		}},
+		"q": {notRepeatable: true, runWithChar: func(prog *Program[T], count int, char rune) {
+			prog.startRecording(char)
+		}},
+		"@": {notRepeatable: true, runWithChar: func(prog *Program[T], count int, char rune) {
+			prog.playMacro(char, count)
+		}},
+		"m": {runWithChar: func(prog *Program[T], count int, char rune) {
+			prog.setMarkAtCursor(char)
+		}},
+		string(RuneCtrlO): {run: func(prog *Program[T], count int) {
+			prog.jump(-count)
+		}},
+		string(RuneTab): {run: func(prog *Program[T], count int) {
+			prog.jump(count)
+		}},
+		"g;": {run: func(prog *Program[T], count int) {
+			prog.goToChange(-count)
+		}},
+		"g,": {run: func(prog *Program[T], count int) {
+			prog.goToChange(count)
+		}},
+	}
+}
+
+// Checking whether some longer key sequence starts with the typed keys
+func isNormalKeyPrefix[T Terminal](keys string) bool {
+	hasPrefix := func(candidate string) bool {
+		return len(candidate) > len(keys) && strings.HasPrefix(candidate, keys)
+	}
+
+	for candidate := range Motions {
+		if hasPrefix(candidate) {
+			return true
+		}
+	}
+	for candidate := range operatorTable[T]() {
+		if hasPrefix(candidate) {
+			return true
+		}
+	}
+	for candidate := range normalCommandTable[T]() {
+		if hasPrefix(candidate) {
+			return true
+		}
+	}
+	return false
 }
 
 func normalMode[T Terminal](input rune, prog *Program[T]) {
-	keys := &prog.settings.normalModeKeybind
+	if input == RuneEscape {
+		prog.state.normalCommand = NormalCommandState{}
+		prog.state.registers.selected = 0
+		return
+	}
+
+	// Closing with the configured key, which is unbound by default
+	closeKey := prog.settings.normalModeKeybind.closeBuffer
+	if closeKey != 0 && input == closeKey && prog.state.normalCommand.isIdle() {
+		if err := exQuit(prog, &ExCommandLine{}); err != nil {
+			prog.setError(err)
+		}
+		return
+	}
+
+	if keys, ok := prog.typeNormalKey(input); ok {
+		prog.runNormalKeys(keys)
+	}
+
+	// Forgetting the picked register once its command is done
+	if prog.state.normalCommand.isIdle() {
+		prog.state.registers.selected = 0
+	}
+}
+
+// Adding a typed key to the command being typed, which is shared by
+// normal and visual mode. Returning the keys of the operator, motion or
+// command so far, or false if the key was a count.
+func (prog *Program[T]) typeNormalKey(input rune) (string, bool) {
+	st := &prog.state.normalCommand
+
+	if st.awaitingChar {
+		st.awaitingChar = false
+		st.hasCharArg = true
+		st.charArg = input
+		return string(st.keys), true
+	}
+
+	if st.awaitingRegister {
+		st.awaitingRegister = false
+		if isRegisterName(input) {
+			st.hasRegister = true
+			prog.state.registers.selected = input
+		}
+		return "", false
+	}
+
+	if len(st.keys) == 0 && st.operator == "" && input == '"' {
+		st.awaitingRegister = true
+		return "", false
+	}
+
+	// Accumulating counts, where a leading 0 is a motion rather than a count
+	if len(st.keys) == 0 && unicode.IsDigit(input) && input <= '9' {
+		count := &st.count1
+		if st.operator != "" {
+			count = &st.count2
+		}
+		if input != '0' || *count > 0 {
+			*count = min(*count*10+int(input-'0'), 99999999)
+			prog.state.dot.isCountKey = true
+			return "", false
+		}
+	}
+
+	if len(st.keys) == 0 {
+		input = prog.settings.normalModeKeybind.canonicalKey(input)
+	}
+	st.keys = append(st.keys, input)
+	return string(st.keys), true
+}
+
+// Running the keys typed so far, if they make up a whole command
+func (prog *Program[T]) runNormalKeys(keys string) {
+	st := &prog.state.normalCommand
+
+	if st.operator != "" {
+		prog.continueOperator(keys)
+		return
+	}
+
+	if _, ok := operatorTable[T]()[keys]; ok {
+		st.operator = keys
+		st.keys = nil
+		return
+	}
+
+	if motion, ok := Motions[keys]; ok {
+		if motion, ok = st.bindCharArg(motion); !ok {
+			return
+		}
+
+		// The jump was remembered before leaving the other file
+		if motion.jumpsToMark && prog.enterFileOfMark(st.charArg) {
+			motion.isJump = false
+		}
+
+		m := prog.motionContext()
+		m.hasCount = st.hasCount()
+		if !prog.moveWithMotion(motion, m, st.count()) {
+			prog.commandFailed()
+		}
+		*st = NormalCommandState{}
+		return
+	}
+
+	// Typing `q` while recording a macro stops recording,
+	// rather than waiting for a register to record into
+	if keys == "q" && prog.state.macros.recording != 0 {
+		*st = NormalCommandState{}
+		prog.stopRecording()
+		return
+	}
+
+	if cmd, ok := normalCommandTable[T]()[keys]; ok {
+		if cmd.runWithChar != nil && !st.hasCharArg {
+			st.awaitingChar = true
+			return
+		}
 
-	if input == keys.insertLeft {
-		prog.changeMode(InsertMode)
+		count := st.count()
+		char := st.charArg
+		prog.state.dot.noteCommand(cmd.notRepeatable, st.hasCount())
+		*st = NormalCommandState{}
+
+		if cmd.runWithChar != nil {
+			cmd.runWithChar(prog, count, char)
+		} else {
+			cmd.run(prog, count)
+		}
 		return
 	}
 
-	if input == keys.cursorDown || input == RuneDownArrow {
-		prog.moveCursorDown()
+	// Waiting for more keys, or giving up on keys that can't become a command
+	if !isNormalKeyPrefix[T](keys) {
+		*st = NormalCommandState{}
 	}
+}
+
+// Handling the keys typed after an operator, which are either
+// a motion, a text object, or the operator again, like `dd`
+func (prog *Program[T]) continueOperator(keys string) {
+	st := &prog.state.normalCommand
+	opKey := st.operator
+	count := st.count()
+	hasCount := st.hasCount()
 
-	if input == keys.cursorUp || input == RuneUpArrow {
-		prog.moveCursorUp()
+	isDoubled := keys == opKey || keys == opKey[len(opKey)-1:]
+
+	if isDoubled {
+		*st = NormalCommandState{}
+		prog.operateOnLines(opKey, count)
+		return
 	}
 
-	if input == keys.cursorLeft || input == RuneLeftArrow {
-		prog.moveCursorLeft()
+	if motion, ok := Motions[keys]; ok {
+		if motion, ok = st.bindCharArg(motion); !ok {
+			return
+		}
+		*st = NormalCommandState{}
+
+		if opKey == "c" && (keys == "w" || keys == "W") {
+			if changeWord, ok := prog.motionContext().changeWordMotion(keys == "W"); ok {
+				motion = changeWord
+			}
+		}
+
+		if !prog.operateWithMotionDef(opKey, motion, count, hasCount) {
+			prog.commandFailed()
+		}
+		return
 	}
 
-	if input == keys.cursorRight || input == RuneRightArrow {
-		prog.moveCursorRight()
+	if object, ok := TextObjects[keys]; ok {
+		*st = NormalCommandState{}
+		if r, ok := object.selectRange(prog.motionContext(), count); ok {
+			prog.applyOperator(operatorTable[T]()[opKey], r)
+		} else {
+			prog.commandFailed()
+		}
+		return
 	}
 
-	if input == keys.closeBuffer {
-		prog.state.shouldExit = true
+	if !isNormalKeyPrefix[T](keys) && !isTextObjectPrefix(keys) {
+		*st = NormalCommandState{}
 	}
 }
+
+// Applying an operator to count lines, starting at the cursor's line
+func (prog *Program[T]) operateOnLines(opKey string, count int) {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+
+	start := Position{0, panel.logicalCursorY}
+	end := Position{0, min(panel.logicalCursorY+count-1, len(buffer.lines)-1)}
+
+	prog.applyOperator(operatorTable[T]()[opKey], newTextRange(start, end, Linewise, false))
+}
+
+func (prog *Program[T]) operateWithMotion(opKey string, motionKey string, count int) bool {
+	return prog.operateWithMotionDef(opKey, Motions[motionKey], count, true)
+}
+
+func (prog *Program[T]) operateWithMotionDef(opKey string, motion Motion, count int, hasCount bool) bool {
+	m := prog.motionContext()
+	m.hasCount = hasCount
+	m.operatorPending = true
+
+	target, ok := motion.move(m, count)
+	if !ok {
+		return false
+	}
+
+	prog.applyOperator(operatorTable[T]()[opKey], m.motionRange(motion, target))
+	return true
+}
@@ -0,0 +1,122 @@
+package main
+
+// What a key typed in ReplaceMode did, so that Backspace can undo it.
+// A typed char either overwrote original, or was added past the end of
+// the line. Enter breaks the line instead of overwriting anything.
+type ReplacedChar struct {
+	original  rune
+	appended  bool
+	lineBreak bool
+}
+
+func (prog *Program[T]) startReplace() {
+	prog.state.replaced = nil
+	prog.changeMode(ReplaceMode)
+}
+
+func replaceMode[T Terminal](input rune, prog *Program[T]) {
+	if input == RuneEscape {
+		prog.state.replaced = nil
+		prog.changeMode(NormalMode)
+		prog.stepBackFromInsert()
+		return
+	}
+
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	x, y := panel.logicalCursorX, panel.logicalCursorY
+	line := []rune(buffer.lineContent(y))
+
+	if isStandardUnicode(input) {
+		replaced := ReplacedChar{appended: x >= len(line)}
+		if replaced.appended {
+			line = append(line, input)
+		} else {
+			replaced.original = line[x]
+			line[x] = input
+		}
+
+		buffer.updateLine(y, string(line))
+		prog.state.replaced = append(prog.state.replaced, replaced)
+		prog.setLogicalCursorPosition(x+1, y)
+		return
+	}
+
+	if input == RuneEnter || input == RuneCarriageReturn {
+		buffer.updateLine(y, string(line[:x]))
+		buffer.insertLine(y+1, string(line[x:]))
+		prog.state.replaced = append(prog.state.replaced, ReplacedChar{lineBreak: true})
+		prog.setLogicalCursorPosition(0, y+1)
+		return
+	}
+
+	if input == RuneBackspace || input == RuneDelete {
+		prog.backspaceReplaced()
+	}
+}
+
+// Moving back over the last char typed in ReplaceMode, and putting
+// back what it overwrote. Before the first typed char, Backspace
+// only moves the cursor, since there's nothing to restore.
+func (prog *Program[T]) backspaceReplaced() {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	x, y := panel.logicalCursorX, panel.logicalCursorY
+
+	n := len(prog.state.replaced)
+	if n == 0 {
+		if x > 0 {
+			prog.setLogicalCursorPosition(x-1, y)
+		}
+		return
+	}
+
+	replaced := prog.state.replaced[n-1]
+	prog.state.replaced = prog.state.replaced[:n-1]
+
+	if replaced.lineBreak {
+		prevLine := buffer.lineContent(y - 1)
+		buffer.updateLine(y-1, prevLine+buffer.lineContent(y))
+		buffer.removeLine(y)
+		prog.setLogicalCursorPosition(runeCount(prevLine), y-1)
+		return
+	}
+
+	line := []rune(buffer.lineContent(y))
+	if replaced.appended {
+		line = append(line[:x-1], line[x:]...)
+	} else {
+		line[x-1] = replaced.original
+	}
+	buffer.updateLine(y, string(line))
+	prog.setLogicalCursorPosition(x-1, y)
+}
+
+// Replacing count chars from the cursor with char, like `3rx`. Nothing
+// is replaced if the line doesn't have that many chars left. Replacing
+// with Enter breaks the line once, in place of all of them.
+func (prog *Program[T]) replaceChars(char rune, count int) {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	x, y := panel.logicalCursorX, panel.logicalCursorY
+	line := []rune(buffer.lineContent(y))
+
+	isLineBreak := char == RuneEnter || char == RuneCarriageReturn
+	if x+count > len(line) || (!isStandardUnicode(char) && !isLineBreak) {
+		prog.commandFailed()
+		return
+	}
+
+	if isLineBreak {
+		buffer.updateLine(y, string(line[:x]))
+		buffer.insertLine(y+1, string(line[x+count:]))
+		prog.setLogicalCursorPosition(0, y+1)
+		return
+	}
+
+	for i := x; i < x+count; i++ {
+		line[i] = char
+	}
+	buffer.updateLine(y, string(line))
+	prog.setLogicalCursorPosition(x+count-1, y)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReplaceMode(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"abcd", "Rxy\x1b", "xycd"},
+		{"abc", "lRwxyz\x1b", "awxyz"},
+		{"ab\ncd", "Rx\ny\x1b", "x\ny\ncd"},
+	})
+
+	p := testingProgramFromBuf("abc")
+	p.processKeys("R")
+	if p.state.currentMode != ReplaceMode {
+		t.Fatalf("wanted R to enter ReplaceMode")
+	}
+	p.processKeys("xy")
+	p.assertLogicalPos(t, 2, 0)
+}
+
+func TestReplaceModeEscapeStepsBack(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"ab", "lRxyz\x1bx", "axy"},
+		{"abcd", "Rxy\x1bx", "xcd"},
+	})
+}
+
+func TestReplaceModeBackspace(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("lRxyz")
+	p.assertBufferContent(t, "axyz")
+
+	// Backspace puts back what was typed over, and removes what was added
+	p.processInputs(RuneBackspace)
+	p.assertBufferContent(t, "axy")
+	p.processInputs(RuneBackspace, RuneBackspace)
+	p.assertBufferContent(t, "abc")
+	p.assertLogicalPos(t, 1, 0)
+
+	// Before where the replace started, it only moves the cursor
+	p.processInputs(RuneBackspace)
+	p.assertBufferContent(t, "abc")
+	p.assertLogicalPos(t, 0, 0)
+
+	p = testingProgramFromBuf("ab\ncd")
+	p.processKeys("Rx\n")
+	p.processInputs(RuneBackspace, RuneBackspace)
+	p.assertBufferContent(t, "ab", "cd")
+}
+
+func TestReplaceModeUndoAndRepeat(t *testing.T) {
+	p := testingProgramFromBuf("abcd\nabcd")
+	p.processKeys("Rxy\x1bu")
+	p.assertBufferContent(t, "abcd", "abcd")
+
+	p.processKeys("Rxy\x1bj0.")
+	p.assertBufferContent(t, "xycd", "xycd")
+}
+
+func TestReplaceChar(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"abc", "rx", "xbc"},
+		{"abcd", "l2rx", "axxd"},
+		{"abc", "4rx", "abc"},
+		{"abc", "r\x1bx", "bc"},
+		{"abcd", "l2r\n", "a\nd"},
+		{"abcd", "rx$.", "xbcx"},
+	})
+
+	p := testingProgramFromBuf("abcd")
+	p.processKeys("3rx")
+	p.assertLogicalPos(t, 2, 0)
+}
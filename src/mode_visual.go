@@ -0,0 +1,414 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// A selection made in VisualMode, from the anchor to the cursor
+type VisualSelection struct {
+	anchor Position
+	cursor Position
+	kind   RangeKind
+	exists bool
+}
+
+// Changing a block inserts on its first line, and this
+// remembers enough to repeat the insert on the other lines
+type BlockInsert struct {
+	start     Position
+	before    string
+	lineCount int
+
+	// Where the text goes on the other lines
+	targets []Position
+}
+
+func (prog *Program[T]) startVisual(kind RangeKind) {
+	panel := prog.getActivePanel()
+	panel.visualAnchor = Position{panel.logicalCursorX, panel.logicalCursorY}
+	panel.visualKind = kind
+	prog.changeMode(VisualMode)
+}
+
+func (prog *Program[T]) exitVisual() {
+	panel := prog.getActivePanel()
+	panel.lastVisual = VisualSelection{
+		anchor: panel.visualAnchor,
+		cursor: Position{panel.logicalCursorX, panel.logicalCursorY},
+		kind:   panel.visualKind,
+		exists: true,
+	}
+	prog.state.normalCommand = NormalCommandState{}
+	prog.changeMode(NormalMode)
+}
+
+// Selecting the last selection again, like `gv`
+func (prog *Program[T]) restoreVisual() {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	last := panel.lastVisual
+	if !last.exists {
+		return
+	}
+
+	// Keeping both ends inside the buffer, which may have shrunk since
+	clamp := func(pos Position) Position {
+		y := max(min(pos.y, len(buffer.lines)-1), 0)
+		x := max(min(pos.x, runeCount(buffer.lineContent(y))-1), 0)
+		return Position{x, y}
+	}
+
+	cursor := clamp(last.cursor)
+	prog.setLogicalCursorPosition(cursor.x, cursor.y)
+	prog.scrollToCursor()
+	prog.startVisual(last.kind)
+	panel.visualAnchor = clamp(last.anchor)
+}
+
+// Switching between kinds of selection, or leaving VisualMode
+// when the key of the current kind is typed again
+func (prog *Program[T]) toggleVisual(kind RangeKind) {
+	panel := prog.getActivePanel()
+	if panel.visualKind == kind {
+		prog.exitVisual()
+		return
+	}
+	panel.visualKind = kind
+	prog.state.needsRedraw = true
+}
+
+func visualCommandTable[T Terminal]() map[string]NormalCommand[T] {
+	operate := func(opKey string) NormalCommand[T] {
+		return NormalCommand[T]{run: func(prog *Program[T], count int) {
+			prog.operateOnSelection(opKey, count)
+		}}
+	}
+
+	return map[string]NormalCommand[T]{
+		"o": {run: func(prog *Program[T], count int) {
+			panel := prog.getActivePanel()
+			anchor := panel.visualAnchor
+			panel.visualAnchor = Position{panel.logicalCursorX, panel.logicalCursorY}
+			prog.setLogicalCursorPosition(anchor.x, anchor.y)
+			prog.scrollToCursor()
+		}},
+		"v": {run: func(prog *Program[T], count int) {
+			prog.toggleVisual(Charwise)
+		}},
+		"V": {run: func(prog *Program[T], count int) {
+			prog.toggleVisual(Linewise)
+		}},
+		string(RuneCtrlV): {run: func(prog *Program[T], count int) {
+			prog.toggleVisual(Blockwise)
+		}},
+		"d": operate("d"),
+		"x": operate("d"),
+		"y": operate("y"),
+		"c": operate("c"),
+		"s": operate("c"),
+		">": operate(">"),
+		"<": operate("<"),
+		"~": operate("g~"),
+		"u": operate("gu"),
+		"U": operate("gU"),
+	}
+}
+
+func isVisualKeyPrefix[T Terminal](keys string) bool {
+	for candidate := range visualCommandTable[T]() {
+		if len(candidate) > len(keys) && strings.HasPrefix(candidate, keys) {
+			return true
+		}
+	}
+	return isNormalKeyPrefix[T](keys) || isTextObjectPrefix(keys)
+}
+
+func visualMode[T Terminal](input rune, prog *Program[T]) {
+	if input == RuneEscape {
+		prog.exitVisual()
+		return
+	}
+
+	if keys, ok := prog.typeNormalKey(input); ok {
+		prog.runVisualKeys(keys)
+	}
+
+	if prog.state.normalCommand.isIdle() {
+		prog.state.registers.selected = 0
+	}
+}
+
+// Running the keys typed so far in VisualMode, where motions
+// move the cursor end of the selection, and operators act on it
+func (prog *Program[T]) runVisualKeys(keys string) {
+	st := &prog.state.normalCommand
+
+	if cmd, ok := visualCommandTable[T]()[keys]; ok {
+		count := st.count()
+		*st = NormalCommandState{}
+		cmd.run(prog, count)
+		return
+	}
+
+	if motion, ok := Motions[keys]; ok {
+		if motion, ok = st.bindCharArg(motion); !ok {
+			return
+		}
+		m := prog.motionContext()
+		m.hasCount = st.hasCount()
+		if !prog.moveWithMotion(motion, m, st.count()) {
+			prog.commandFailed()
+		}
+		*st = NormalCommandState{}
+		return
+	}
+
+	if object, ok := TextObjects[keys]; ok {
+		count := st.count()
+		*st = NormalCommandState{}
+		if r, ok := object.selectRange(prog.motionContext(), count); ok {
+			prog.selectRange(r)
+		} else {
+			prog.commandFailed()
+		}
+		return
+	}
+
+	if !isVisualKeyPrefix[T](keys) {
+		*st = NormalCommandState{}
+	}
+}
+
+// Making a text object's range the selection
+func (prog *Program[T]) selectRange(r TextRange) {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+
+	if r.isEmpty() {
+		return
+	}
+
+	// Selections include their last char, so exclusive ranges end a char sooner
+	end := r.end
+	if r.kind == Charwise && !r.inclusive {
+		if end.x > 0 {
+			end.x--
+		} else if end.y > r.start.y {
+			end.y--
+			end.x = max(runeCount(buffer.lineContent(end.y))-1, 0)
+		}
+	}
+
+	if r.kind == Linewise && panel.visualKind == Charwise {
+		panel.visualKind = Linewise
+	}
+
+	panel.visualAnchor = r.start
+	prog.setLogicalCursorPosition(end.x, end.y)
+	prog.scrollToCursor()
+}
+
+// Whether the selection's cursor end was moved to the end of the
+// line with `$`, which stretches a block to the end of every line
+func (panel *Panel) isPinnedToLineEnd() bool {
+	return panel.pinnedVisualCursorX == math.MaxInt && !panel.repinVisualX
+}
+
+// Returning the range that the selection covers. The x's of a block
+// are visual columns, since its lines may have tabs in different places.
+func (prog *Program[T]) visualRange() TextRange {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	anchor := panel.visualAnchor
+	cursor := Position{panel.logicalCursorX, panel.logicalCursorY}
+
+	switch panel.visualKind {
+	case Linewise:
+		return newTextRange(Position{0, anchor.y}, Position{0, cursor.y}, Linewise, false)
+
+	case Blockwise:
+		// Finding the columns that the chars at both ends cover
+		columns := func(pos Position) (int, int) {
+			line := buffer.lineContent(pos.y)
+			left := getVisualX(line, pos.x, &prog.settings)
+			width := 1
+			if runes := []rune(line); pos.x < len(runes) {
+				width = runeWidth(runes[pos.x], &prog.settings)
+			}
+			return left, left + width - 1
+		}
+
+		anchorLeft, anchorRight := columns(anchor)
+		cursorLeft, cursorRight := columns(cursor)
+		right := max(anchorRight, cursorRight)
+		if panel.isPinnedToLineEnd() {
+			right = math.MaxInt
+		}
+
+		return TextRange{
+			start:     Position{min(anchorLeft, cursorLeft), min(anchor.y, cursor.y)},
+			end:       Position{right, max(anchor.y, cursor.y)},
+			kind:      Blockwise,
+			inclusive: true,
+		}
+	}
+
+	return newTextRange(anchor, cursor, Charwise, true)
+}
+
+// Splitting a block into a charwise range on each of its lines,
+// covering the chars that overlap the block's columns. Lines that
+// are too short to reach the block get an empty range at their end.
+func (prog *Program[T]) blockSpans(r TextRange) []TextRange {
+	buffer := prog.getActiveBuffer()
+	spans := []TextRange{}
+
+	for y := r.start.y; y <= r.end.y; y++ {
+		runes := []rune(buffer.lineContent(y))
+		startX, endX := -1, len(runes)
+		visualX := 0
+
+		for x, char := range runes {
+			width := runeWidth(char, &prog.settings)
+			if visualX+width > r.start.x && visualX <= r.end.x {
+				if startX == -1 {
+					startX = x
+				}
+				endX = x + 1
+			}
+			visualX += width
+		}
+
+		if startX == -1 {
+			startX = len(runes)
+		}
+		spans = append(spans, TextRange{
+			start: Position{startX, y},
+			end:   Position{endX, y},
+			kind:  Charwise,
+		})
+	}
+
+	return spans
+}
+
+// Splitting any range into an exclusive charwise range on each of its lines
+func (prog *Program[T]) lineSpans(r TextRange) []TextRange {
+	if r.kind == Blockwise {
+		return prog.blockSpans(r)
+	}
+
+	buffer := prog.getActiveBuffer()
+	spans := []TextRange{}
+
+	for y := r.start.y; y <= r.end.y; y++ {
+		startX, endX := 0, runeCount(buffer.lineContent(y))
+		if r.kind == Charwise && y == r.start.y {
+			startX = min(r.start.x, endX)
+		}
+		if r.kind == Charwise && y == r.end.y {
+			endX = max(buffer.exclusiveEndX(r), startX)
+		}
+		spans = append(spans, TextRange{
+			start: Position{startX, y},
+			end:   Position{endX, y},
+			kind:  Charwise,
+		})
+	}
+
+	return spans
+}
+
+// Applying an operator to the selection, and leaving VisualMode
+func (prog *Program[T]) operateOnSelection(opKey string, count int) {
+	r := prog.visualRange()
+	prog.exitVisual()
+
+	op := operatorTable[T]()[opKey]
+
+	// Shifting by count levels of indent, rather than once
+	repeats := 1
+	if opKey == ">" || opKey == "<" {
+		repeats = count
+	}
+	for i := 0; i < repeats; i++ {
+		prog.applyOperator(op, r)
+	}
+}
+
+// Repeating the text typed into the first line of a changed block
+// on the block's other lines, once the insert is done
+func (prog *Program[T]) finishBlockInsert() {
+	bi := prog.state.blockInsert
+	prog.state.blockInsert = nil
+	if bi == nil {
+		return
+	}
+
+	// Giving up if the insert did more than add text to the first line
+	buffer := prog.getActiveBuffer()
+	line := []rune(buffer.lineContent(bi.start.y))
+	inserted := len(line) - runeCount(bi.before)
+	if len(buffer.lines) != bi.lineCount || inserted <= 0 || bi.start.x+inserted > len(line) {
+		return
+	}
+
+	text := []string{string(line[bi.start.x : bi.start.x+inserted])}
+	for _, target := range bi.targets {
+		buffer.insertText(target, text)
+	}
+}
+
+var visualModeNames = map[RangeKind]string{
+	Charwise:  "-- VISUAL --",
+	Linewise:  "-- VISUAL LINE --",
+	Blockwise: "-- VISUAL BLOCK --",
+}
+
+// Returning a function that finds the visual columns of the selection
+// on a line, from inclusive to exclusive, for drawing its highlight
+func (prog *Program[T]) selectedColumnsFunc() func(y int) (int, int, bool) {
+	if prog.state.currentMode != VisualMode {
+		return func(y int) (int, int, bool) { return 0, 0, false }
+	}
+
+	buffer := prog.getActiveBuffer()
+	r := prog.visualRange()
+	spans := prog.lineSpans(r)
+
+	return func(y int) (int, int, bool) {
+		if y < r.start.y || y > r.end.y {
+			return 0, 0, false
+		}
+
+		span := spans[y-r.start.y]
+		line := buffer.lineContent(y)
+		from := getVisualX(line, span.start.x, &prog.settings)
+		to := getVisualX(line, span.end.x, &prog.settings)
+
+		// Showing the line break of a selected line as a selected space
+		if r.kind != Blockwise && (span.end.x == runeCount(line) && (y < r.end.y || r.kind == Linewise)) {
+			to++
+		}
+		return from, to, true
+	}
+}
+
+// Visual columns of a line, from inclusive to exclusive
+type ColumnSpan struct {
+	from int
+	to   int
+}
+
+// Drawing a line at screenX, screenY with the columns of each span
+// highlighted, including columns past the end of the line
+func (prog *Program[T]) drawHighlighted(screenX, screenY int, runes []rune, spans []ColumnSpan, width int) {
+	screen := &prog.state.screen
+	screen.drawText(screenX, screenY, runes, StyleNormal, width)
+
+	for _, span := range spans {
+		from, to := max(span.from, 0), min(span.to, width)
+		screen.setStyle(screenX+from, screenY, to-from, StyleHighlight)
+	}
+}
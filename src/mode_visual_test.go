@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVisualDelete(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"foo bar baz", "wvlld", "foo  baz"},
+		{"foo bar baz", "wvhd", "fooar baz"},
+		{"foo\nbar\nbaz", "lvjd", "fr\nbaz"},
+		{"foo\nbar\nbaz", "jVd", "foo\nbaz"},
+		{"foo\nbar\nbaz", "Vjd", "baz"},
+		{"foo bar baz", "wvex", "foo  baz"},
+		{"foo bar baz", "wviwd", "foo  baz"},
+		{"f(a, b)", "fbvi(d", "f()"},
+		{"a\nb\n\nc", "vipd", "\nc"},
+	})
+}
+
+func TestVisualSwapEnds(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("wvlo")
+	p.assertLogicalPos(t, 4, 0)
+	p.processKeys("hd")
+	p.assertBufferContent(t, "foor baz")
+}
+
+func TestVisualSwitchKinds(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nbaz")
+	p.processKeys("lvjVd")
+	p.assertBufferContent(t, "baz")
+
+	p = testingProgramFromBuf("foo")
+	p.processKeys("vv")
+	if p.state.currentMode != NormalMode {
+		t.Errorf("wanted typing v again to leave visual mode")
+	}
+}
+
+func TestVisualYankAndPut(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("wvey0P")
+	p.assertBufferContent(t, "barfoo bar")
+
+	p = testingProgramFromBuf("a\nb")
+	p.processKeys("Vyp")
+	p.assertBufferContent(t, "a", "a", "b")
+}
+
+func TestVisualChange(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("wvecqux\x1b")
+	p.assertBufferContent(t, "foo qux baz")
+}
+
+func TestVisualShift(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.settings.expandtab = true
+	p.settings.shiftwidth = 2
+	p.processKeys("Vj>")
+	p.assertBufferContent(t, "  a", "  b", "c")
+	p.processKeys("Vj2>")
+	p.assertBufferContent(t, "      a", "      b", "c")
+	p.processKeys("Vj<")
+	p.assertBufferContent(t, "    a", "    b", "c")
+}
+
+func TestVisualCase(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"foo Bar", "v$~", "FOO bAR"},
+		{"foo Bar", "wvU", "foo Bar"},
+		{"foo bar", "wveU", "foo BAR"},
+		{"FOO BAR", "veu", "foo BAR"},
+		{"ab\ncd", "Vju", "ab\ncd"},
+		{"ab\ncd", "VjU", "AB\nCD"},
+		{"ab\ncd", "l\x16jU", "aB\ncD"},
+	})
+}
+
+func TestCaseOperators(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"foo bar", "gUiw", "FOO bar"},
+		{"foo bar", "gUU", "FOO BAR"},
+		{"Foo Bar", "g~~", "fOO bAR"},
+		{"FOO BAR", "guw", "foo BAR"},
+	})
+}
+
+func TestVisualBlock(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"abcd\nefgh\nijkl", "l\x16jld", "ad\neh\nijkl"},
+		{"abcd\nefgh\nijkl", "l\x16jjlo", "abcd\nefgh\nijkl"},
+		{"abcd\nef\nijkl", "ll\x16jjd", "abd\nef\nijl"},
+		{"abcd\nefgh", "l\x16j$d", "a\ne"},
+		{"ab\nabcdef", "\x16j$d", "\n"},
+
+		// Tabs take up tabstop columns, so the block covers the whole tab
+		{"a\tb\nabcdef", "l\x16jd", "ab\naf"},
+		{"abcdef\na\tb", "ll\x16jd", "af\nab"},
+	})
+}
+
+func TestVisualBlockYankAndPut(t *testing.T) {
+	p := testingProgramFromBuf("abc\ndef\nghi")
+	p.processKeys("\x16jly$p")
+	p.assertBufferContent(t, "abcab", "defde", "ghi")
+
+	p = testingProgramFromBuf("ab\ncd\nxyz\nx")
+	p.processKeys("\x16jyjjP")
+	p.assertBufferContent(t, "ab", "cd", "axyz", "cx")
+}
+
+func TestVisualBlockChange(t *testing.T) {
+	p := testingProgramFromBuf("abcd\nefgh\nij")
+	p.processKeys("ll\x16jjcXY\x1b")
+	p.assertBufferContent(t, "aXYd", "eXYh", "iXY")
+
+	// Leaving lines that are too short to reach the block alone
+	p = testingProgramFromBuf("abcd\ne\nijkl")
+	p.processKeys("ll\x16jjcX\x1b")
+	p.assertBufferContent(t, "abXd", "e", "ijXl")
+}
+
+func TestRestoreLastSelection(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("wve\x1b0gvd")
+	p.assertBufferContent(t, "foo  baz")
+
+	p = testingProgramFromBuf("a\nb\nc")
+	p.processKeys("Vj\x1bGgvd")
+	p.assertBufferContent(t, "c")
+
+	// Doing nothing when there was no selection yet
+	p = testingProgramFromBuf("a")
+	p.processKeys("gv")
+	if p.state.currentMode != NormalMode {
+		t.Errorf("wanted gv without a previous selection to stay in normal mode")
+	}
+}
+
+func TestVisualHighlight(t *testing.T) {
+	p := testingProgramFromBuf("a\tbc\nabcdef")
+	p.processKeys("l\x16j")
+	columns := p.selectedColumnsFunc()
+
+	if from, to, ok := columns(0); !ok || from != 1 || to != 5 {
+		t.Errorf("wanted line 0 to highlight columns 1 to 5, got %d to %d", from, to)
+	}
+	if from, to, ok := columns(1); !ok || from != 1 || to != 5 {
+		t.Errorf("wanted line 1 to highlight columns 1 to 5, got %d to %d", from, to)
+	}
+}
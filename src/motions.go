@@ -1,121 +1,317 @@
 package main
 
-func (prog *Program[T]) moveCursorDown() {
+import (
+	"math"
+)
+
+// Motions find a target position relative to the cursor. On their own they
+// move the cursor there, and after an operator they describe the range
+// that the operator acts on.
+type Motion struct {
+	kind      RangeKind
+	inclusive bool
+
+	// Vertical motions move towards the pinned visual x,
+	// instead of resetting it to wherever they land
+	keepsPinnedX bool
+
+	// Motions like `$` pin the cursor to the end of the line,
+	// so that later vertical moves land on line ends too
+	pinsLineEnd bool
+
+	// Motions like `G` and `n` can go far, so the cursor is
+	// remembered in the jump list before they move it
+	isJump bool
+
+	// Motions like `'A` can go to a mark in another file
+	jumpsToMark bool
+
+	// Motions like `;` are inclusive or not depending on what they repeat
+	isInclusive func(m *MotionContext) bool
+
+	move func(m *MotionContext, count int) (Position, bool)
+
+	// Motions like `f` take the next typed char as an argument,
+	// and use this instead of move once it has been typed
+	moveWithChar func(m *MotionContext, count int, char rune) (Position, bool)
+}
+
+// Everything a motion needs to know to find its target
+type MotionContext struct {
+	buffer   *Buffer
+	panel    *Panel
+	settings *Settings
+	state    *ProgramState
+	cursor   Position
+
+	// Whether a count was typed, since some motions
+	// behave differently without one
+	hasCount bool
+
+	// Whether the motion is being used by an operator, which
+	// lets horizontal motions reach just past the last char
+	operatorPending bool
+
+	// The runes of the line that was read last, since
+	// motions tend to read the same line many times
+	cachedLineIdx int
+	cachedLine    []rune
+}
+
+var Motions = map[string]Motion{
+	"h": {kind: Charwise, move: motionLeft},
+	"l": {kind: Charwise, move: motionRight},
+	"j": {kind: Linewise, keepsPinnedX: true, move: motionDown},
+	"k": {kind: Linewise, keepsPinnedX: true, move: motionUp},
+
+	"w":  {kind: Charwise, move: motionWordForward},
+	"W":  {kind: Charwise, move: motionBigWordForward},
+	"b":  {kind: Charwise, move: motionWordBackward},
+	"B":  {kind: Charwise, move: motionBigWordBackward},
+	"e":  {kind: Charwise, inclusive: true, move: motionWordEnd},
+	"E":  {kind: Charwise, inclusive: true, move: motionBigWordEnd},
+	"ge": {kind: Charwise, inclusive: true, move: motionWordEndBackward},
+	"gE": {kind: Charwise, inclusive: true, move: motionBigWordEndBackward},
+
+	"0":  {kind: Charwise, move: motionLineStart},
+	"^":  {kind: Charwise, move: motionFirstNonBlank},
+	"$":  {kind: Charwise, inclusive: true, pinsLineEnd: true, move: motionLineEnd},
+	"gg": {kind: Linewise, isJump: true, move: motionFirstLine},
+	"G":  {kind: Linewise, isJump: true, move: motionLastLine},
+	"H":  {kind: Linewise, isJump: true, move: motionViewportTop},
+	"M":  {kind: Linewise, isJump: true, move: motionViewportMiddle},
+	"L":  {kind: Linewise, isJump: true, move: motionViewportBottom},
+
+	"f": {kind: Charwise, inclusive: true, moveWithChar: motionFindForward},
+	"F": {kind: Charwise, moveWithChar: motionFindBackward},
+	"t": {kind: Charwise, inclusive: true, moveWithChar: motionTillForward},
+	"T": {kind: Charwise, moveWithChar: motionTillBackward},
+	";": {kind: Charwise, isInclusive: repeatFindIsInclusive, move: motionRepeatFind},
+	",": {kind: Charwise, isInclusive: repeatFindReversedIsInclusive, move: motionRepeatFindReversed},
+
+	"n": {kind: Charwise, isJump: true, move: motionSearchNext},
+	"N": {kind: Charwise, isJump: true, move: motionSearchPrevious},
+	"*": {kind: Charwise, isJump: true, move: motionSearchWordForward},
+	"#": {kind: Charwise, isJump: true, move: motionSearchWordBackward},
+
+	"'": {kind: Linewise, isJump: true, jumpsToMark: true, moveWithChar: motionMarkLine},
+	"`": {kind: Charwise, isJump: true, jumpsToMark: true, moveWithChar: motionMark},
+}
+
+// Binding the char argument of a motion like `f`, turning it into a plain motion
+func (motion Motion) withChar(char rune) Motion {
+	moveWithChar := motion.moveWithChar
+	motion.moveWithChar = nil
+	motion.move = func(m *MotionContext, count int) (Position, bool) {
+		return moveWithChar(m, count, char)
+	}
+	return motion
+}
+
+func (prog *Program[T]) motionContext() *MotionContext {
 	panel := prog.getActivePanel()
-	buffer := prog.getActiveBuffer()
-	isAtContentBottom := panel.logicalCursorY+1 >= len(buffer.lines)
-	canScroll := buffer.topVisibleLineIdx+panel.height+1 < len(buffer.lines)
-	isAtViewportBottom := prog.state.visualCursorY == panel.topLeftY+panel.height
-
-	if !isAtContentBottom || canScroll {
-		// Moving the cursor down
-		line := buffer.lineContent(panel.logicalCursorY)
-		nextLine := buffer.lineContent(panel.logicalCursorY + 1)
-
-		currentVisualX := getVisualX(line, panel.logicalCursorX, &prog.settings)
-		newLogicalX := getLogicalXWithVisualX(nextLine, currentVisualX, &prog.settings)
-
-		// Respecting pinned visual x
-		if currentVisualX < panel.pinnedVisualCursorX {
-			newLogicalX = getLogicalXWithVisualX(nextLine, panel.pinnedVisualCursorX, &prog.settings)
-		}
+	return &MotionContext{
+		buffer:   prog.getActiveBuffer(),
+		panel:    panel,
+		settings: &prog.settings,
+		state:    &prog.state,
+		cursor:   Position{panel.logicalCursorX, panel.logicalCursorY},
 
-		// Scrolling if necessary
-		if isAtViewportBottom {
-			buffer.topVisibleLineIdx += 1
-		}
+		cachedLineIdx: -1,
+	}
+}
 
-		prog.setLogicalCursorPosition(newLogicalX, panel.logicalCursorY+1)
+func (m *MotionContext) line(y int) []rune {
+	if y != m.cachedLineIdx {
+		m.cachedLineIdx = y
+		m.cachedLine = []rune(m.buffer.lineContent(y))
 	}
+	return m.cachedLine
 }
 
-func (prog *Program[T]) moveCursorUp() {
+func (m *MotionContext) lastLineIdx() int {
+	return len(m.buffer.lines) - 1
+}
+
+// Returning the last column the cursor may sit on in normal mode
+func (m *MotionContext) lastCharIdx(y int) int {
+	return max(len(m.line(y))-1, 0)
+}
+
+// Moving the cursor to wherever a motion lands.
+// Returning false if the motion couldn't move.
+func (prog *Program[T]) moveWithMotion(motion Motion, m *MotionContext, count int) bool {
 	panel := prog.getActivePanel()
-	buffer := prog.getActiveBuffer()
-	canScroll := buffer.topVisibleLineIdx > 0
 
-	if panel.logicalCursorY > 0 || canScroll {
-		line := buffer.lineContent(panel.logicalCursorY)
-		prevLine := buffer.lineContent(panel.logicalCursorY - 1)
+	if motion.keepsPinnedX && panel.repinVisualX {
+		line := prog.getActiveBuffer().lineContent(panel.logicalCursorY)
+		panel.pinnedVisualCursorX = getVisualX(line, panel.logicalCursorX, &prog.settings)
+	}
 
-		currentVisualX := getVisualX(line, panel.logicalCursorX, &prog.settings)
-		newLogicalX := getLogicalXWithVisualX(prevLine, currentVisualX, &prog.settings)
+	target, ok := motion.move(m, count)
+	if !ok {
+		return false
+	}
 
-		// Scrolling if necessary
-		if prog.state.visualCursorY == prog.state.topChromeHeight {
-			buffer.topVisibleLineIdx -= 1
-		}
+	if motion.isJump {
+		prog.recordJump()
+	}
 
-		// Respecting pinned visual x
-		if currentVisualX < panel.pinnedVisualCursorX {
-			newLogicalX = getLogicalXWithVisualX(prevLine, panel.pinnedVisualCursorX, &prog.settings)
-		}
+	prog.setLogicalCursorPosition(target.x, target.y)
+	prog.scrollToCursor()
 
-		prog.setLogicalCursorPosition(newLogicalX, panel.logicalCursorY-1)
+	if motion.keepsPinnedX {
+		panel.repinVisualX = false
 	}
+
+	if motion.pinsLineEnd {
+		panel.pinnedVisualCursorX = math.MaxInt
+		panel.repinVisualX = false
+	}
+
+	return true
+}
+
+// Moving the cursor one step, the same way h, j, k and l do
+func (prog *Program[T]) moveCursorDown() {
+	prog.moveWithMotion(Motions["j"], prog.motionContext(), 1)
+}
+
+func (prog *Program[T]) moveCursorUp() {
+	prog.moveWithMotion(Motions["k"], prog.motionContext(), 1)
 }
 
 func (prog *Program[T]) moveCursorLeft() {
-	panel := prog.getActivePanel()
-	buffer := prog.getActiveBuffer()
+	prog.moveWithMotion(Motions["h"], prog.motionContext(), 1)
+}
 
-	// Wrapping to the end of the previous line
-	if panel.logicalCursorX == 0 && panel.logicalCursorY != 0 {
-		if !prog.settings.cursor_x_overflow {
-			return
-		}
-		prevLine := buffer.lineContent(panel.logicalCursorY - 1)
-		newLogicalX := max(len(prevLine)-1, 0)
+func (prog *Program[T]) moveCursorRight() {
+	prog.moveWithMotion(Motions["l"], prog.motionContext(), 1)
+}
 
-		// Scrolling if necessary
-		if prog.state.visualCursorY == prog.state.topChromeHeight {
-			buffer.topVisibleLineIdx -= 1
-		}
+// Finding the x on another line that is closest to the pinned visual x
+func (m *MotionContext) pinnedXOnLine(y int) int {
+	return getLogicalXWithVisualX(m.buffer.lineContent(y), m.panel.pinnedVisualCursorX, m.settings)
+}
 
-		panel.pinnedVisualCursorX = getVisualX(prevLine, newLogicalX, &prog.settings)
-		prog.setLogicalCursorPosition(newLogicalX, panel.logicalCursorY-1)
+func motionDown(m *MotionContext, count int) (Position, bool) {
+	if m.cursor.y >= m.lastLineIdx() {
+		return m.cursor, false
+	}
 
-	} else if panel.logicalCursorX != 0 {
-		// Moving the cursor left within the current line
-		newLogicalX := panel.logicalCursorX - 1
-		line := buffer.lineContent(panel.logicalCursorY)
-		panel.pinnedVisualCursorX = getVisualX(line, newLogicalX, &prog.settings)
-		prog.setLogicalCursorPosition(newLogicalX, panel.logicalCursorY)
+	y := min(m.cursor.y+count, m.lastLineIdx())
+	return Position{m.pinnedXOnLine(y), y}, true
+}
+
+func motionUp(m *MotionContext, count int) (Position, bool) {
+	if m.cursor.y <= 0 {
+		return m.cursor, false
 	}
+
+	y := max(m.cursor.y-count, 0)
+	return Position{m.pinnedXOnLine(y), y}, true
 }
 
-func (prog *Program[T]) moveCursorRight() {
-	panel := prog.getActivePanel()
-	buffer := prog.getActiveBuffer()
+func motionLeft(m *MotionContext, count int) (Position, bool) {
+	pos := m.cursor
 
-	line := buffer.lineContent(panel.logicalCursorY)
-	lineLength := len(line)
-	isAtEndOfLine := panel.logicalCursorX+1 >= lineLength
-	isLastLine := panel.logicalCursorY == len(buffer.lines)-1
-	isAtViewportBottom := prog.state.visualCursorY == panel.topLeftY+panel.height
+	for i := 0; i < count; i++ {
+		if pos.x > 0 {
+			pos.x--
+			continue
+		}
 
-	if isAtEndOfLine && isLastLine {
-		return
+		// Wrapping to the end of the previous line
+		if pos.y == 0 || !m.settings.cursor_x_overflow || m.operatorPending {
+			break
+		}
+		pos.y--
+		pos.x = m.lastCharIdx(pos.y)
 	}
 
-	if isAtEndOfLine && !isLastLine {
-		// wrapping to the beginning of the next line
-		if !prog.settings.cursor_x_overflow {
-			return
+	return pos, pos != m.cursor
+}
+
+func motionRight(m *MotionContext, count int) (Position, bool) {
+	pos := m.cursor
+
+	// Operators can reach just past the last char, so that `dl` can delete it
+	lastX := m.lastCharIdx(pos.y)
+	if m.operatorPending {
+		lastX = len(m.line(pos.y))
+	}
+
+	for i := 0; i < count; i++ {
+		if pos.x < lastX {
+			pos.x++
+			continue
 		}
 
-		// scrolling if necessary
-		if isAtViewportBottom {
-			buffer.topVisibleLineIdx += 1
+		// Wrapping to the beginning of the next line
+		if pos.y >= m.lastLineIdx() || !m.settings.cursor_x_overflow || m.operatorPending {
+			break
 		}
+		pos.y++
+		pos.x = 0
+		lastX = m.lastCharIdx(pos.y)
+	}
+
+	return pos, pos != m.cursor
+}
+
+func motionLineStart(m *MotionContext, count int) (Position, bool) {
+	return Position{0, m.cursor.y}, true
+}
+
+func motionFirstNonBlank(m *MotionContext, count int) (Position, bool) {
+	return Position{firstNonBlank(string(m.line(m.cursor.y))), m.cursor.y}, true
+}
+
+// Moving to the last char of the line, or of the line count-1 lines down
+func motionLineEnd(m *MotionContext, count int) (Position, bool) {
+	y := m.cursor.y + count - 1
+	if y > m.lastLineIdx() {
+		return m.cursor, false
+	}
+	return Position{m.lastCharIdx(y), y}, true
+}
 
-		panel.pinnedVisualCursorX = 0
-		prog.setLogicalCursorPosition(0, panel.logicalCursorY+1)
+// Moving to the first non-blank of a line, keeping it inside the buffer
+func (m *MotionContext) lineTarget(y int) Position {
+	y = max(min(y, m.lastLineIdx()), 0)
+	return Position{firstNonBlank(string(m.line(y))), y}
+}
+
+func motionFirstLine(m *MotionContext, count int) (Position, bool) {
+	return m.lineTarget(count - 1), true
+}
 
-	} else {
-		// moving the cursor right
-		newLogicalX := panel.logicalCursorX + 1
-		panel.pinnedVisualCursorX = getVisualX(line, newLogicalX, &prog.settings)
-		prog.setLogicalCursorPosition(newLogicalX, panel.logicalCursorY)
+// Moving to the last line, or to line {count} when a count is given
+func motionLastLine(m *MotionContext, count int) (Position, bool) {
+	if m.hasCount {
+		return m.lineTarget(count - 1), true
 	}
+	return m.lineTarget(m.lastLineIdx()), true
+}
+
+// Returning the first and last lines that are visible in the panel
+func (m *MotionContext) visibleLines() (int, int) {
+	top := m.buffer.topVisibleLineIdx
+	bottom := min(top+m.panel.height-1, m.lastLineIdx())
+	return top, max(bottom, top)
+}
+
+func motionViewportTop(m *MotionContext, count int) (Position, bool) {
+	top, bottom := m.visibleLines()
+	return m.lineTarget(min(top+count-1, bottom)), true
+}
+
+func motionViewportMiddle(m *MotionContext, count int) (Position, bool) {
+	top, bottom := m.visibleLines()
+	return m.lineTarget(top + (bottom-top)/2), true
+}
+
+func motionViewportBottom(m *MotionContext, count int) (Position, bool) {
+	top, bottom := m.visibleLines()
+	return m.lineTarget(max(bottom-count+1, top)), true
 }
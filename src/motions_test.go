@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -28,6 +29,17 @@ func TestCursorLeft(t *testing.T) {
 	p.assertLogicalPos(t, 0, 0)
 }
 
+func TestMoveCursorMatchesMotions(t *testing.T) {
+	p := testingProgramFromBuf("abc\ndef")
+	p.moveCursorRight()
+	p.moveCursorRight()
+	p.moveCursorDown()
+	p.assertLogicalPos(t, 2, 1)
+	p.moveCursorLeft()
+	p.moveCursorUp()
+	p.assertLogicalPos(t, 1, 0)
+}
+
 func TestRightwardWrapToNextLine(t *testing.T) {
 	p := testingProgramFromBuf("ab\ncd")
 	p.processInputs('l', 'l')
@@ -89,3 +101,264 @@ func TestPinnedVisualXRespectedByCursorUp(t *testing.T) {
 	p.processInputs('k', 'k')   // moving back to the first line
 	p.assertLogicalPos(t, 2, 0) // expecting pinned visual x to be restored
 }
+
+type motionTest struct {
+	buf  string
+	keys string
+	x, y int
+}
+
+func runMotionTests(t *testing.T, tests []motionTest) {
+	for _, test := range tests {
+		p := testingProgramFromBuf(test.buf)
+		p.processKeys(test.keys)
+
+		panel := p.getActivePanel()
+		if panel.logicalCursorX != test.x || panel.logicalCursorY != test.y {
+			t.Errorf(
+				"%q with keys %q: wanted x=%d,y=%d; got x=%d,y=%d",
+				test.buf, test.keys, test.x, test.y, panel.logicalCursorX, panel.logicalCursorY,
+			)
+		}
+	}
+}
+
+func TestWordForward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "w", 4, 0},
+		{"foo  bar", "w", 5, 0},
+		{"foo.bar", "w", 3, 0},
+		{"foo.bar", "ww", 4, 0},
+		{"foo...bar", "w", 3, 0},
+		{"foo_bar baz", "w", 8, 0},
+		{"foo bar baz", "2w", 8, 0},
+		{"foo\nbar", "w", 0, 1},
+		{"foo  \n  bar", "w", 2, 1},
+		{"foo\n\nbar", "w", 0, 1},
+		{"foo\n\nbar", "ww", 0, 2},
+		{"foo bar", "ww", 6, 0},
+		{"foo", "w", 2, 0},
+		{"héllo wörld", "w", 6, 0},
+		{"名前 = 値", "w", 3, 0},
+	})
+}
+
+func TestBigWordForward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo.bar baz", "W", 8, 0},
+		{"a-b c-d e-f", "2W", 8, 0},
+		{"foo.bar\nbaz", "W", 0, 1},
+	})
+}
+
+func TestWordBackward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "wb", 0, 0},
+		{"foo bar", "20lb", 4, 0},
+		{"foo.bar", "20lb", 4, 0},
+		{"foo.bar", "20lbb", 3, 0},
+		{"foo.bar", "20lbbb", 0, 0},
+		{"foo bar baz", "20l2b", 4, 0},
+		{"foo\nbar", "jb", 0, 0},
+		{"foo\n\nbar", "jjb", 0, 1},
+		{"  foo", "20lb", 2, 0},
+		{"foo", "b", 0, 0},
+		{"héllo wörld", "20lb", 6, 0},
+	})
+}
+
+func TestBigWordBackward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo.bar baz", "20lB", 8, 0},
+		{"foo.bar baz", "20lBB", 0, 0},
+	})
+}
+
+func TestWordEnd(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "e", 2, 0},
+		{"foo bar", "ee", 6, 0},
+		{"foo.bar", "e", 2, 0},
+		{"foo.bar", "ee", 3, 0},
+		{"foo bar baz", "3e", 10, 0},
+		{"foo\nbar", "ee", 2, 1},
+		{"foo\n\nbar", "ee", 2, 2},
+		{"f bar", "e", 4, 0},
+		{"héllo wörld", "ee", 10, 0},
+	})
+}
+
+func TestBigWordEnd(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo.bar baz", "E", 6, 0},
+		{"foo.bar baz", "EE", 10, 0},
+	})
+}
+
+func TestWordEndBackward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "20lge", 2, 0},
+		{"foo.bar", "20lge", 3, 0},
+		{"foo.bar", "20lgege", 2, 0},
+		{"foo bar baz", "20l2ge", 2, 0},
+		{"foo\nbar", "jge", 2, 0},
+		{"foo\n\nbar", "jjge", 0, 1},
+		{"foo", "ge", 0, 0},
+	})
+}
+
+func TestBigWordEndBackward(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo.bar baz", "20lgE", 6, 0},
+	})
+}
+
+func TestLineMotions(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "$", 6, 0},
+		{"foo bar", "$0", 0, 0},
+		{"  foo", "$^", 2, 0},
+		{"   ", "^", 2, 0},
+		{"", "$", 0, 0},
+		{"foo\nbarbaz", "2$", 5, 1},
+		{"foo\nbar", "3$", 0, 0},
+		{"héllo", "$", 4, 0},
+	})
+}
+
+func TestFileMotions(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"a\nb\n  c", "G", 2, 2},
+		{"  a\nb\nc", "Ggg", 2, 0},
+		{"a\nb\nc", "2G", 0, 1},
+		{"a\nb\nc", "9G", 0, 2},
+		{"a\nb\nc", "G2gg", 0, 1},
+	})
+}
+
+// Backward word motions from the end of the line, where $ leaves the cursor
+func TestBackwardWordMotionsFromLineEnd(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar", "$b", 4, 0},
+		{"foo.bar baz", "$B", 8, 0},
+		{"foo bar baz", "$2b", 4, 0},
+		{"foo bar", "$ge", 2, 0},
+		{"foo.bar baz", "$gE", 6, 0},
+		{"foo\nbar baz", "j$3b", 0, 0},
+	})
+}
+
+func TestDollarStaysPinnedToLineEnd(t *testing.T) {
+	p := testingProgramFromBuf("aaa\n" + "b\n" + "cccccc")
+	p.processKeys("$j")
+	p.assertLogicalPos(t, 0, 1)
+	p.processKeys("j")
+	p.assertLogicalPos(t, 5, 2)
+	p.processKeys("0jk")
+	p.assertLogicalPos(t, 0, 1) // a horizontal motion unpins x again
+}
+
+func TestJumpsScrollCursorIntoView(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 20; i++ {
+		lines = append(lines, "line")
+	}
+	p := testingProgramFromBuf(strings.Join(lines, "\n"))
+	p.getActivePanel().height = 5
+
+	p.processKeys("G")
+	p.assertLogicalPos(t, 0, 19)
+	if top := p.getActiveBuffer().topVisibleLineIdx; top != 15 {
+		t.Errorf("wanted top visible line 15 after G, got %d", top)
+	}
+
+	p.processKeys("gg")
+	if top := p.getActiveBuffer().topVisibleLineIdx; top != 0 {
+		t.Errorf("wanted top visible line 0 after gg, got %d", top)
+	}
+}
+
+func TestViewportMotions(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 20; i++ {
+		lines = append(lines, "line")
+	}
+	p := testingProgramFromBuf(strings.Join(lines, "\n"))
+	p.getActivePanel().height = 5
+	p.processKeys("10G")
+
+	// Lines 5 through 9 are visible now
+	p.processKeys("H")
+	p.assertLogicalPos(t, 0, 5)
+	p.processKeys("L")
+	p.assertLogicalPos(t, 0, 9)
+	p.processKeys("M")
+	p.assertLogicalPos(t, 0, 7)
+	p.processKeys("2H")
+	p.assertLogicalPos(t, 0, 6)
+	p.processKeys("2L")
+	p.assertLogicalPos(t, 0, 8)
+}
+
+func TestOperatorsWithLineMotions(t *testing.T) {
+	p := testingProgramFromBuf("foo bar\nbaz\nqux")
+	p.processKeys("wd$")
+	p.assertBufferContent(t, "foo ", "baz", "qux")
+	p.processKeys("dG")
+	p.assertBufferContent(t, "")
+}
+
+func TestFindMotions(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"foo bar baz", "fa", 5, 0},
+		{"foo bar baz", "2fa", 9, 0},
+		{"foo bar baz", "fz", 10, 0},
+		{"foo bar baz", "fq", 0, 0},
+		{"foo bar baz", "3fa", 0, 0},
+		{"foo bar baz", "$Fa", 9, 0},
+		{"foo bar baz", "$2Fa", 5, 0},
+		{"foo bar baz", "ta", 4, 0},
+		{"foo bar baz", "$Ta", 10, 0},
+		{"foo bar baz", "$Tb", 9, 0},
+		{"héllo wörld", "fö", 7, 0},
+		{"foo\nbar", "fb", 0, 0},
+	})
+}
+
+func TestRepeatFind(t *testing.T) {
+	runMotionTests(t, []motionTest{
+		{"a.b.c.d", "f.;", 3, 0},
+		{"a.b.c.d", "f.2;", 5, 0},
+		{"a.b.c.d", "f.;,", 1, 0},
+		{"a.b.c.d", "$F.;", 3, 0},
+		{"a.b.c.d", "$F.;,", 5, 0},
+		{"a.b.c.d", "t.;", 2, 0},
+		{"a.b.c.d", "t.;;", 4, 0},
+		{"a.b.c.d", "$T.;", 4, 0},
+		{"a.b.c.d", ";", 0, 0},
+		{"a.b\nc.d", "f.jh;", 1, 1},
+	})
+}
+
+func TestOperatorsWithFindMotions(t *testing.T) {
+	p := testingProgramFromBuf("foo(bar, baz)")
+	p.processKeys("dt(")
+	p.assertBufferContent(t, "(bar, baz)")
+
+	p = testingProgramFromBuf("foo(bar, baz)")
+	p.processKeys("df,")
+	p.assertBufferContent(t, " baz)")
+
+	p = testingProgramFromBuf("foo(bar, baz)")
+	p.processKeys("$dF(")
+	p.assertBufferContent(t, "foo)")
+
+	// `,` after `f` goes backwards, which leaves out the char it lands on
+	p = testingProgramFromBuf("a.b.c")
+	p.processKeys("f.$d,")
+	p.assertBufferContent(t, "a.bc")
+
+	p = testingProgramFromBuf("a.b.c")
+	p.processKeys("d\x1bfc")
+	p.assertLogicalPos(t, 4, 0)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Operator[T Terminal] struct {
+	// Operators like `>` always act on whole lines, whatever the motion
+	forceLinewise bool
+	run           func(prog *Program[T], r TextRange)
+}
+
+func operatorTable[T Terminal]() map[string]Operator[T] {
+	return map[string]Operator[T]{
+		"d": {run: operatorDelete[T]},
+		"c": {run: operatorChange[T]},
+		"y": {run: operatorYank[T]},
+		">": {forceLinewise: true, run: operatorIndent[T]},
+		"<": {forceLinewise: true, run: operatorOutdent[T]},
+
+		"g~": {run: caseOperator[T](swapCase)},
+		"gu": {run: caseOperator[T](strings.ToLower)},
+		"gU": {run: caseOperator[T](strings.ToUpper)},
+	}
+}
+
+// Turning a motion from the cursor into the range an operator acts on,
+// following vim's rules for exclusive motions that end at the start of a line
+func (m *MotionContext) motionRange(motion Motion, target Position) TextRange {
+	inclusive := motion.inclusive
+	if motion.isInclusive != nil {
+		inclusive = motion.isInclusive(m)
+	}
+	r := newTextRange(m.cursor, target, motion.kind, inclusive)
+
+	if r.kind != Charwise || r.inclusive || r.end.x != 0 || r.end.y == r.start.y {
+		return r
+	}
+
+	if r.start.x <= firstNonBlank(m.buffer.lineContent(r.start.y)) {
+		r.kind = Linewise
+		r.end.y--
+		return r
+	}
+
+	r.end.y--
+	r.end.x = max(runeCount(m.buffer.lineContent(r.end.y))-1, 0)
+	r.inclusive = true
+	return r
+}
+
+func (prog *Program[T]) applyOperator(op Operator[T], r TextRange) {
+	if op.forceLinewise {
+		r.kind = Linewise
+	}
+	op.run(prog, r)
+}
+
+// Putting the cursor on the first non-blank char of a line
+func (prog *Program[T]) moveToFirstNonBlank(y int) {
+	prog.setLogicalCursorPosition(firstNonBlank(prog.getActiveBuffer().lineContent(y)), y)
+	prog.scrollToCursor()
+}
+
+// Keeping the cursor on an existing char, after the text under it changed
+func (prog *Program[T]) clampCursor() {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	y := max(min(panel.logicalCursorY, len(buffer.lines)-1), 0)
+	x := max(min(panel.logicalCursorX, runeCount(buffer.lineContent(y))-1), 0)
+	prog.setLogicalCursorPosition(x, y)
+	prog.scrollToCursor()
+}
+
+func (prog *Program[T]) yankRange(r TextRange, isDelete bool) {
+	buffer := prog.getActiveBuffer()
+	text := []string{}
+
+	if r.kind == Blockwise {
+		for _, span := range prog.blockSpans(r) {
+			text = append(text, buffer.textInRange(span)[0])
+		}
+	} else {
+		text = buffer.textInRange(r)
+	}
+
+	prog.storeRegister(Register{text: text, kind: r.kind}, isDelete)
+}
+
+// Moving the cursor to the top left of a block, once it has been acted on
+func (prog *Program[T]) moveToBlockStart(r TextRange) {
+	line := prog.getActiveBuffer().lineContent(r.start.y)
+	prog.setLogicalCursorPosition(getLogicalXWithVisualX(line, r.start.x, &prog.settings), r.start.y)
+	prog.clampCursor()
+}
+
+func operatorDelete[T Terminal](prog *Program[T], r TextRange) {
+	if r.isEmpty() {
+		return
+	}
+
+	prog.yankRange(r, true)
+
+	if r.kind == Blockwise {
+		for _, span := range prog.blockSpans(r) {
+			prog.getActiveBuffer().deleteRange(span)
+		}
+		prog.moveToBlockStart(r)
+		return
+	}
+
+	prog.getActiveBuffer().deleteRange(r)
+
+	if r.kind == Linewise {
+		prog.moveToFirstNonBlank(min(r.start.y, len(prog.getActiveBuffer().lines)-1))
+		return
+	}
+
+	prog.setLogicalCursorPosition(r.start.x, r.start.y)
+	prog.clampCursor()
+}
+
+func operatorChange[T Terminal](prog *Program[T], r TextRange) {
+	buffer := prog.getActiveBuffer()
+	prog.yankRange(r, true)
+
+	// Replacing the lines with a single line that keeps their indent
+	if r.kind == Linewise {
+		indent := leadingWhitespace(buffer.lineContent(r.start.y))
+		for y := r.end.y; y > r.start.y; y-- {
+			buffer.removeLine(y)
+		}
+		buffer.updateLine(r.start.y, indent)
+		prog.setLogicalCursorPosition(runeCount(indent), r.start.y)
+		prog.changeMode(InsertMode)
+		return
+	}
+
+	// Inserting on the block's first line, and repeating it on the others later
+	if r.kind == Blockwise {
+		spans := prog.blockSpans(r)
+		targets := []Position{}
+		for _, span := range spans {
+			buffer.deleteRange(span)
+			if span.start.y != r.start.y && !span.isEmpty() {
+				targets = append(targets, span.start)
+			}
+		}
+
+		start := spans[0].start
+		prog.state.blockInsert = &BlockInsert{
+			start:     start,
+			before:    buffer.lineContent(start.y),
+			lineCount: len(buffer.lines),
+			targets:   targets,
+		}
+		prog.setLogicalCursorPosition(start.x, start.y)
+		prog.changeMode(InsertMode)
+		return
+	}
+
+	if !r.isEmpty() {
+		buffer.deleteRange(r)
+	}
+	prog.setLogicalCursorPosition(r.start.x, r.start.y)
+	prog.changeMode(InsertMode)
+}
+
+func operatorYank[T Terminal](prog *Program[T], r TextRange) {
+	prog.yankRange(r, false)
+
+	panel := prog.getActivePanel()
+	switch r.kind {
+	case Blockwise:
+		prog.moveToBlockStart(r)
+	case Linewise:
+		prog.setLogicalCursorPosition(panel.logicalCursorX, r.start.y)
+		prog.clampCursor()
+	default:
+		prog.setLogicalCursorPosition(r.start.x, r.start.y)
+		prog.clampCursor()
+	}
+}
+
+// Returning the whitespace that one level of indentation adds
+func (s *Settings) indentUnit() string {
+	if s.expandtab {
+		return strings.Repeat(" ", s.shiftwidth)
+	}
+	return "\t"
+}
+
+func operatorIndent[T Terminal](prog *Program[T], r TextRange) {
+	buffer := prog.getActiveBuffer()
+	unit := prog.settings.indentUnit()
+
+	for y := r.start.y; y <= r.end.y; y++ {
+		// Leaving empty lines alone, like vim does
+		if line := buffer.lineContent(y); line != "" {
+			buffer.updateLine(y, unit+line)
+		}
+	}
+
+	prog.moveToFirstNonBlank(r.start.y)
+}
+
+func operatorOutdent[T Terminal](prog *Program[T], r TextRange) {
+	buffer := prog.getActiveBuffer()
+
+	for y := r.start.y; y <= r.end.y; y++ {
+		line := buffer.lineContent(y)
+
+		// Removing a tab, or up to a shiftwidth of spaces
+		if strings.HasPrefix(line, "\t") {
+			buffer.updateLine(y, line[1:])
+			continue
+		}
+
+		spaces := len(line) - len(strings.TrimLeft(line, " "))
+		if removed := min(spaces, prog.settings.shiftwidth); removed > 0 {
+			buffer.updateLine(y, line[removed:])
+		}
+	}
+
+	prog.moveToFirstNonBlank(r.start.y)
+}
+
+func swapCase(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			runes[i] = unicode.ToLower(r)
+		} else {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// Making an operator that changes the case of the text in a range
+func caseOperator[T Terminal](convert func(string) string) func(prog *Program[T], r TextRange) {
+	return func(prog *Program[T], r TextRange) {
+		buffer := prog.getActiveBuffer()
+
+		for _, span := range prog.lineSpans(r) {
+			runes := []rune(buffer.lineContent(span.start.y))
+			changed := convert(string(runes[span.start.x:span.end.x]))
+			content := string(runes[:span.start.x]) + changed + string(runes[span.end.x:])
+			if content != buffer.lineContent(span.start.y) {
+				buffer.updateLine(span.start.y, content)
+			}
+		}
+
+		switch r.kind {
+		case Blockwise:
+			prog.moveToBlockStart(r)
+		case Linewise:
+			prog.setLogicalCursorPosition(prog.getActivePanel().logicalCursorX, r.start.y)
+			prog.clampCursor()
+		default:
+			prog.setLogicalCursorPosition(r.start.x, r.start.y)
+			prog.clampCursor()
+		}
+	}
+}
+
+// Pasting a register after or before the cursor
+func (prog *Program[T]) put(register Register, after bool, count int) {
+	if len(register.text) == 0 {
+		return
+	}
+
+	buffer := prog.getActiveBuffer()
+	panel := prog.getActivePanel()
+	y := panel.logicalCursorY
+
+	if register.kind == Blockwise {
+		prog.putBlock(register, after, count)
+		return
+	}
+
+	if register.kind == Linewise {
+		lines := []string{}
+		for i := 0; i < count; i++ {
+			lines = append(lines, register.text...)
+		}
+
+		if after {
+			y++
+		}
+		buffer.insertLines(y, lines)
+		prog.moveToFirstNonBlank(y)
+		return
+	}
+
+	// Repeating charwise text count times, joining the copies end to end
+	text := append([]string{}, register.text...)
+	for i := 1; i < count; i++ {
+		text[len(text)-1] += register.text[0]
+		text = append(text, register.text[1:]...)
+	}
+
+	x := panel.logicalCursorX
+	if after && runeCount(buffer.lineContent(y)) > 0 {
+		x++
+	}
+
+	end := buffer.insertText(Position{x, y}, text)
+
+	// Leaving the cursor on the last pasted char,
+	// or at the start of text that spans lines
+	if len(text) == 1 {
+		prog.setLogicalCursorPosition(end.x-1, end.y)
+	} else {
+		prog.setLogicalCursorPosition(x, y)
+	}
+	prog.clampCursor()
+}
+
+// Pasting a block at the same column on the cursor's line and the lines
+// below it, padding short lines with spaces and adding lines if needed
+func (prog *Program[T]) putBlock(register Register, after bool, count int) {
+	buffer := prog.getActiveBuffer()
+	panel := prog.getActivePanel()
+	cursor := Position{panel.logicalCursorX, panel.logicalCursorY}
+
+	line := buffer.lineContent(cursor.y)
+	column := getVisualX(line, cursor.x, &prog.settings)
+	if after && runeCount(line) > 0 {
+		column += runeWidth([]rune(line)[cursor.x], &prog.settings)
+	}
+
+	// Padding each piece of the block to the same width, so text after it stays lined up
+	width := 0
+	for _, text := range register.text {
+		width = max(width, runeCount(text))
+	}
+
+	for i, text := range register.text {
+		y := cursor.y + i
+		if y >= len(buffer.lines) {
+			buffer.insertLine(y, "")
+		}
+
+		content := buffer.lineContent(y)
+		x := logicalXAtColumn(content, column, &prog.settings)
+		if x < 0 {
+			padding := column - getVisualX(content, runeCount(content), &prog.settings)
+			content += strings.Repeat(" ", padding)
+			x = runeCount(content)
+		}
+
+		piece := strings.Repeat(text, count)
+		if x < runeCount(content) {
+			piece += strings.Repeat(" ", (width-runeCount(text))*count)
+		}
+		buffer.insertText(Position{x, y}, []string{piece})
+	}
+
+	x := logicalXAtColumn(buffer.lineContent(cursor.y), column, &prog.settings)
+	prog.setLogicalCursorPosition(max(x, 0), cursor.y)
+	prog.clampCursor()
+}
+
+// Finding the rune that starts at or after a visual column,
+// or -1 if the line doesn't reach that column
+func logicalXAtColumn(line string, visualX int, settings *Settings) int {
+	column := 0
+	for x, r := range []rune(line) {
+		if column >= visualX {
+			return x
+		}
+		column += runeWidth(r, settings)
+	}
+	if column >= visualX {
+		return runeCount(line)
+	}
+	return -1
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeleteLine(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys("jdd")
+	p.assertBufferContent(t, "a", "c")
+	p.assertLogicalPos(t, 0, 1)
+}
+
+func TestDeleteLinesWithCount(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("3dd")
+	p.assertBufferContent(t, "d")
+}
+
+func TestDeleteLinesCountPastEnd(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys("j5dd")
+	p.assertBufferContent(t, "a")
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestDeleteWithLinewiseMotion(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("jdj")
+	p.assertBufferContent(t, "a", "d")
+
+	p = testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("jjdk")
+	p.assertBufferContent(t, "a", "d")
+}
+
+func TestCountsMultiply(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd\ne\nf\ng")
+	p.processKeys("2d2j")
+	p.assertBufferContent(t, "f", "g")
+}
+
+func TestDeleteChars(t *testing.T) {
+	p := testingProgramFromBuf("abcdef")
+	p.processKeys("l2x")
+	p.assertBufferContent(t, "adef")
+	p.assertLogicalPos(t, 1, 0)
+
+	p.processKeys("X")
+	p.assertBufferContent(t, "def")
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestDeleteLastChar(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("llx")
+	p.assertBufferContent(t, "ab")
+	p.assertLogicalPos(t, 1, 0)
+}
+
+func TestDeleteCharsDoesNotJoinLines(t *testing.T) {
+	p := testingProgramFromBuf("ab\ncd")
+	p.processKeys("l5x")
+	p.assertBufferContent(t, "a", "cd")
+}
+
+func TestEscapeCancelsPendingOperator(t *testing.T) {
+	p := testingProgramFromBuf("a\nb")
+	p.processKeys("d\x1bj")
+	p.assertBufferContent(t, "a", "b")
+	p.assertLogicalPos(t, 0, 1)
+}
+
+func TestInvalidMotionCancelsOperator(t *testing.T) {
+	p := testingProgramFromBuf("a\nb")
+	p.processKeys("dzdd")
+	p.assertBufferContent(t, "b")
+}
+
+func TestYankAndPutLines(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys("yjjp")
+	p.assertBufferContent(t, "a", "b", "a", "b", "c")
+	p.assertLogicalPos(t, 0, 2)
+
+	p.processKeys("kkP")
+	p.assertBufferContent(t, "a", "b", "a", "b", "a", "b", "c")
+}
+
+func TestPutCharsWithCount(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ylx2p")
+	p.assertBufferContent(t, "baac")
+	p.assertLogicalPos(t, 2, 0)
+}
+
+func TestChangeLines(t *testing.T) {
+	p := testingProgramFromBuf("\tab\n\tcd\nef")
+	p.processKeys("cjx\x1b")
+	p.assertBufferContent(t, "\tx", "ef")
+}
+
+func TestChangeChars(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("2clx\x1b")
+	p.assertBufferContent(t, "xc")
+}
+
+func TestIndentAndOutdent(t *testing.T) {
+	p := testingProgramFromBuf("a\n\nb\nc")
+	p.processKeys("3>>")
+	p.assertBufferContent(t, "\ta", "", "\tb", "c")
+
+	p.processKeys("<j")
+	p.assertBufferContent(t, "a", "", "\tb", "c")
+}
+
+func TestOperatorIsOneUndoStep(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.processKeys("2ddu")
+	p.assertBufferContent(t, "a", "b", "c")
+}
+
+func TestDeleteWord(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("dw")
+	p.assertBufferContent(t, "bar baz")
+}
+
+func TestDeleteLastWordDoesNotJoinLines(t *testing.T) {
+	p := testingProgramFromBuf("foo bar\nbaz")
+	p.processKeys("wdw")
+	p.assertBufferContent(t, "foo ", "baz")
+}
+
+func TestDeleteWordsAcrossLines(t *testing.T) {
+	p := testingProgramFromBuf("foo bar\nbaz qux")
+	p.processKeys("w2dw")
+	p.assertBufferContent(t, "foo qux")
+}
+
+func TestDeleteWordAtEndOfLine(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar baz")
+	p.processKeys("dw")
+	p.assertBufferContent(t, "", "bar baz")
+}
+
+func TestDeleteToWordEnd(t *testing.T) {
+	p := testingProgramFromBuf("foo.bar baz")
+	p.processKeys("de")
+	p.assertBufferContent(t, ".bar baz")
+}
+
+func TestDeleteBackwardWord(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("wdb")
+	p.assertBufferContent(t, "bar")
+}
+
+func TestChangeWordKeepsTrailingBlanks(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("cwx\x1b")
+	p.assertBufferContent(t, "x bar")
+
+	p = testingProgramFromBuf("foo bar baz")
+	p.processKeys("c2wx\x1b")
+	p.assertBufferContent(t, "x baz")
+}
+
+func TestChangeWordOnLastCharOfWord(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("llcwx\x1b")
+	p.assertBufferContent(t, "fox bar")
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 type Program[T Terminal] struct {
 	settings Settings
 	state    ProgramState
@@ -10,18 +15,54 @@ type Program[T Terminal] struct {
 type Settings struct {
 	tabstop                 int
 	tabchar                 string
+	shiftwidth              int
+	expandtab               bool
 	cursor_x_overflow       bool
 	tabNamesUseFullFileName bool
 	normalModeKeybind       NormalModeKeyBindings
+
+	// Whether deleting text replaces what `p` puts, like yanking does
+	yankdeletes bool
+
+	// Where the `+` and `*` registers go, which is one of auto, osc52,
+	// wl-copy, xclip, xsel or memory, and whether they are used by default
+	clipboardprovider string
+	unnamedclipboard  bool
+
+	// Whether searches ignore case, and whether they stop
+	// ignoring it when the pattern has uppercase letters
+	ignorecase bool
+	smartcase  bool
+
+	// Whether undo history is kept across restarts, and where.
+	// An empty undodir means a directory in the user's cache.
+	undofile bool
+	undodir  string
+
+	// Whether each frame is wrapped in synchronized output markers,
+	// for terminals that show frames half drawn without them. It's off
+	// until the terminal answers that it supports them, since some
+	// terminals draw the markers as text instead of ignoring them.
+	syncoutput bool
 }
 
 func defaultSettings() Settings {
 	return Settings{
 		tabstop:                 4,
 		tabchar:                 "›",
+		shiftwidth:              4,
+		expandtab:               false,
 		cursor_x_overflow:       true,
 		tabNamesUseFullFileName: false,
 		normalModeKeybind:       DefaultNormalModeKeyBindings,
+		yankdeletes:             false,
+		clipboardprovider:       "auto",
+		unnamedclipboard:        false,
+		ignorecase:              false,
+		smartcase:               false,
+		undofile:                true,
+		undodir:                 "",
+		syncoutput:              false,
 	}
 }
 
@@ -32,14 +73,37 @@ type BufferLine struct {
 
 type BufferLineFlags struct {
 	isDir bool
+
+	// Set on the lines that `:global` and `:normal` haven't
+	// reached yet, which stays with each line as others move
+	isMarked bool
 }
 
+// Every change to Buffer.lines goes through removeLine,
+// updateLine or insertLine, so that it can be undone
 func (b *Buffer) removeLine(lineNum int) {
+	b.history.record(LineChange{
+		kind:    LineRemoved,
+		lineNum: lineNum,
+		before:  b.lines[lineNum].content,
+	})
+
 	b.lines = append(b.lines[:lineNum], b.lines[lineNum+1:]...)
+	b.modified = true
+	b.firstMovedLine = min(b.firstMovedLine, lineNum)
+	b.adjustMarks(lineNum, -1)
 }
 
 func (b *Buffer) updateLine(lineNum int, content string) {
+	b.history.record(LineChange{
+		kind:    LineUpdated,
+		lineNum: lineNum,
+		before:  b.lines[lineNum].content,
+		after:   content,
+	})
+
 	b.lines[lineNum].content = content
+	b.modified = true
 }
 
 func (b *Buffer) lineContent(lineNum int) string {
@@ -48,18 +112,50 @@ func (b *Buffer) lineContent(lineNum int) string {
 
 func (b *Buffer) insertLine(lineNum int, content string) {
 	// TODO handle cases where lineNum is out of bounds
+	b.history.record(LineChange{
+		kind:    LineInserted,
+		lineNum: lineNum,
+		after:   content,
+	})
+
 	b.lines = append(b.lines, BufferLine{})
 	copy(b.lines[lineNum+1:], b.lines[lineNum:])
 
 	b.lines[lineNum] = BufferLine{
 		content: content,
 	}
+	b.modified = true
+	b.firstMovedLine = min(b.firstMovedLine, lineNum)
+	b.adjustMarks(lineNum, 1)
 }
 
 type Buffer struct {
 	filepath          string
 	lines             []BufferLine
 	topVisibleLineIdx int
+
+	// Whether the lines have changed since they were last written to disk
+	modified bool
+
+	// When the file was last changed on disk, as of reading or writing it,
+	// for noticing when something else changes it. Zero if it wasn't read.
+	modTime time.Time
+
+	history UndoTree
+
+	// Marks set with `m`, and where recent changes were,
+	// which both follow their lines as other lines move
+	marks   map[rune]Position
+	changes ChangeList
+
+	// The lowest line that was inserted or removed since this was last
+	// reset, which tells :global whether lines it passed have moved
+	firstMovedLine int
+}
+
+type Position struct {
+	x int
+	y int
 }
 
 type Tab struct {
@@ -72,6 +168,14 @@ type ProgramMode int
 const (
 	NormalMode ProgramMode = iota
 	InsertMode
+	CommandMode
+	VisualMode
+
+	// Asking whether to replace each match of a `:s///c`
+	ConfirmMode
+
+	// Typing over the text, like `R`
+	ReplaceMode
 )
 
 type ProgramState struct {
@@ -92,6 +196,66 @@ type ProgramState struct {
 	lastVisualCursorY  int
 	lastVisualCursorX  int
 
+	// What the terminal shows, and the next frame to show
+	screen Screen
+
+	// The line being typed while in CommandMode, and previously run lines
+	commandLine    CommandLine
+	commandHistory []string
+
+	// The normal mode command being typed
+	normalCommand NormalCommandState
+
+	// The text typed into the first line of a block being changed,
+	// which is repeated on the block's other lines afterwards
+	blockInsert *BlockInsert
+
+	// The text of an insert session started with a count, to be repeated
+	insertRepeat *InsertRepeat
+
+	// The chars typed over in ReplaceMode, for Backspace to restore
+	replaced []ReplacedChar
+
+	// The last change, which `.` repeats
+	dot DotRepeat
+
+	// The last char search on a line, which `;` and `,` repeat
+	lastFind FindState
+
+	// The last search, and previously searched patterns
+	search        SearchState
+	searchHistory []string
+
+	// The last `:s` pattern and replacement, and the
+	// substitution waiting for an answer in ConfirmMode
+	substitute   SubstituteState
+	substitution *Substitution
+
+	// Set while `:global` runs its command on each line
+	runningGlobal bool
+
+	// Which file has each uppercase mark, by its path, since
+	// a buffer can be read again, and its index isn't the file
+	fileMarks map[rune]string
+
+	// The loop that's running, which background jobs send their output to
+	events *EventLoop
+
+	// Yanked and deleted text
+	registers Registers
+
+	// The macro being recorded, and the macros being played
+	macros MacroState
+
+	// The system clipboard, and the setting it was made for
+	clipboard        ClipboardProvider
+	clipboardSetting string
+
+	// A one-line message shown in the bottom chrome,
+	// like the result of a command, or an error
+	statusMessage string
+	statusIsError bool
+
 	// Represents the last visual cursor x that the user
 	// has selected. When they move up and down to a line
 	// that is shorter than the last one, the visual cursor
@@ -108,9 +272,21 @@ type Panel struct {
 	lastLogicalCursorX  int
 	lastLogicalCursorY  int
 	pinnedVisualCursorX int
+	repinVisualX        bool
 	width               int
 	height              int
 	bufferIdx           int
+
+	// The end of the selection that stays put in VisualMode,
+	// and the last selection, which `gv` restores
+	visualAnchor Position
+	visualKind   RangeKind
+	lastVisual   VisualSelection
+
+	// Where the cursor jumped from, which Ctrl-O and Ctrl-I go back
+	// and forth through. jumpIdx is the entry last jumped to, or len(jumps).
+	jumps   []Jump
+	jumpIdx int
 }
 
 func (prog *Program[T]) setCWD(path string) (string, error) {
@@ -125,22 +301,28 @@ func (prog *Program[T]) setCWD(path string) (string, error) {
 func (prog *Program[T]) changeMode(mode ProgramMode) {
 	prog.state.currentMode = mode
 
-	if mode == NormalMode {
+	if mode == NormalMode || mode == VisualMode || mode == ConfirmMode {
 		prog.term.useBlockCursor()
-	} else if mode == InsertMode {
+	} else if mode == InsertMode || mode == CommandMode {
 		prog.term.useBarCursor()
+	} else if mode == ReplaceMode {
+		prog.term.useUnderlineCursor()
 	}
 }
 
-// Adding a helper to deliver ANSI instruction, while
-// also updating native variables to track the cursor
-func (p *Program[T]) setVisualCursorPosition(x, y int) {
-	p.term.setCursorPosition(x, y)
-	p.state.lastVisualCursorX = p.state.visualCursorX
-	p.state.lastVisualCursorY = p.state.visualCursorY
-	p.state.visualCursorX = x
-	p.state.visualCursorY = y
-	p.state.needsRedraw = true
+func (prog *Program[T]) setStatus(format string, args ...interface{}) {
+	prog.state.statusMessage = fmt.Sprintf(format, args...)
+	prog.state.statusIsError = false
+	prog.state.needsRedraw = true
+}
+
+// Reporting an error in the bottom chrome, instead of crashing
+func (prog *Program[T]) setError(err error) {
+	prog.state.statusMessage = err.Error()
+	prog.state.statusIsError = true
+	prog.state.needsRedraw = true
+	prog.logger(fmt.Sprintf("Error: %v", err))
+	prog.commandFailed()
 }
 
 // TODO move make this a method of Panel
@@ -152,6 +334,28 @@ func (p *Program[T]) setLogicalCursorPosition(x, y int) {
 	panel.logicalCursorX = x
 	panel.logicalCursorY = y
 	p.state.needsRedraw = true
+
+	// Pinning the visual x wherever the cursor lands, unless
+	// the move was vertical, which is handled by the caller
+	panel.repinVisualX = true
+}
+
+// Adjusting the scroll position of the active buffer,
+// so that the logical cursor is inside the active panel
+func (prog *Program[T]) scrollToCursor() {
+	prog.scrollPanelToCursor(prog.getActivePanel())
+}
+
+func (prog *Program[T]) scrollPanelToCursor(panel *Panel) {
+	buffer := &prog.state.buffers[panel.bufferIdx]
+
+	if panel.logicalCursorY < buffer.topVisibleLineIdx {
+		buffer.topVisibleLineIdx = panel.logicalCursorY
+	} else if panel.logicalCursorY >= buffer.topVisibleLineIdx+panel.height {
+		buffer.topVisibleLineIdx = panel.logicalCursorY - panel.height + 1
+	}
+
+	prog.state.needsRedraw = true
 }
 
 func initializeState[T Terminal](program *Program[T]) {
@@ -160,7 +364,6 @@ func initializeState[T Terminal](program *Program[T]) {
 	s.activeTabIdx = 0
 
 	termHeight, termWidth, err := program.term.getSize()
-	s.termHeight = termHeight
 
 	if err != nil {
 		panic(err)
@@ -179,14 +382,10 @@ func initializeState[T Terminal](program *Program[T]) {
 	panels := []Panel{
 		{
 			bufferIdx:          0,
-			topLeftX:           s.leftChromeWidth,
-			topLeftY:           s.topChromeHeight,
 			logicalCursorX:     0,
 			logicalCursorY:     0,
 			lastLogicalCursorX: 0,
 			lastLogicalCursorY: 0,
-			width:              termWidth - s.leftChromeWidth,
-			height:             termHeight - s.topChromeHeight - s.bottomChromeHeight,
 		},
 	}
 
@@ -204,12 +403,41 @@ func initializeState[T Terminal](program *Program[T]) {
 	s.lastVisualCursorX = s.visualCursorX
 	s.lastVisualCursorY = s.visualCursorY
 
-	program.term.setCursorPosition(s.visualCursorX, s.visualCursorY)
-	program.term.clearScreen()
+	program.layout(termHeight, termWidth)
+}
+
+// Fitting the chrome and the panels of every tab to a terminal of the
+// given size, and scrolling each panel so its cursor stays in view
+func (prog *Program[T]) layout(termHeight, termWidth int) {
+	s := &prog.state
+	s.termHeight = termHeight
+
+	for i := range s.tabs {
+		tab := &s.tabs[i]
+		for j := range tab.panels {
+			panel := &tab.panels[j]
+			panel.topLeftX = s.leftChromeWidth
+			panel.topLeftY = s.topChromeHeight
+			panel.width = max(termWidth-s.leftChromeWidth, 1)
+			panel.height = max(termHeight-s.topChromeHeight-s.bottomChromeHeight, 1)
+			prog.scrollPanelToCursor(panel)
+		}
+	}
 
+	s.screen.resize(termWidth, termHeight)
 	s.needsRedraw = true
 }
 
+// Laying out again for the terminal's new size, after it was resized
+func (prog *Program[T]) handleResize() {
+	termHeight, termWidth, err := prog.term.getSize()
+	if err != nil {
+		prog.setError(err)
+		return
+	}
+	prog.layout(termHeight, termWidth)
+}
+
 func (prog *Program[T]) getActivePanel() *Panel {
 	tab := &prog.state.tabs[prog.state.activeTabIdx]
 	return &tab.panels[tab.activePanelIdx]
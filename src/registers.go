@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Text that was yanked or deleted, and whether it was whole lines,
+// a block, or chars within lines
+type Register struct {
+	text []string
+	kind RangeKind
+}
+
+func (r Register) isEmpty() bool {
+	return len(r.text) == 0
+}
+
+func (r Register) equals(other Register) bool {
+	if r.kind != other.kind || len(r.text) != len(other.text) {
+		return false
+	}
+	for i := range r.text {
+		if r.text[i] != other.text[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// How many yanks and deletes are kept, as the numbered registers 1 to 9
+const registerHistorySize = 9
+
+// Registers hold text for putting. A register is picked by typing `"`
+// and its name before a command: `a` to `z` are named registers, and
+// `A` to `Z` append to them. `0` is the last yank, `1` to `9` are the
+// most recent yanks and deletes, `+` and `*` are the system clipboard,
+// and `_` throws text away.
+type Registers struct {
+	// What `p` puts when no register is picked
+	unnamed Register
+
+	named    [26]Register
+	lastYank Register
+	history  []Register
+
+	// The register picked for the command being typed, or 0
+	selected rune
+
+	// The last put, which Ctrl-P and Ctrl-N swap for other history entries
+	lastPut *PutState
+
+	// The text last copied to the system clipboard, which remembers
+	// its kind, in case it's pasted back unchanged
+	clipboard Register
+}
+
+type PutState struct {
+	historyIdx int
+	after      bool
+	count      int
+	bufferIdx  int
+
+	// The undo state that the put will be committed as,
+	// which tells whether anything changed since
+	undoSeq int
+}
+
+func isRegisterName(r rune) bool {
+	return r == '"' || r == '_' || r == '+' || r == '*' || ('0' <= r && r <= '9') ||
+		('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// Adding text to the end of a register, joining charwise text onto
+// the register's last line, and adding anything else as new lines
+func (r Register) appended(other Register) Register {
+	if r.isEmpty() {
+		return other
+	}
+
+	text := append([]string{}, r.text...)
+	if r.kind == Charwise && other.kind == Charwise {
+		text[len(text)-1] += other.text[0]
+		return Register{text: append(text, other.text[1:]...), kind: Charwise}
+	}
+
+	kind := r.kind
+	if other.kind == Linewise {
+		kind = Linewise
+	}
+	return Register{text: append(text, other.text...), kind: kind}
+}
+
+func isNamedRegister(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// Setting a named register, or appending to it when
+// the name is uppercase, and returning what it holds after
+func (regs *Registers) setNamed(name rune, reg Register) Register {
+	idx := unicode.ToLower(name) - 'a'
+	if unicode.IsUpper(name) {
+		reg = regs.named[idx].appended(reg)
+	}
+	regs.named[idx] = reg
+	return reg
+}
+
+// Storing yanked or deleted text in the picked register. Deletes only
+// replace the unnamed register when the yankdeletes setting is on,
+// so that deleting doesn't lose what was yanked.
+func (prog *Program[T]) storeRegister(reg Register, isDelete bool) {
+	regs := &prog.state.registers
+	name := regs.selected
+
+	switch {
+	case name == '_':
+		return
+
+	case name == '+' || name == '*':
+		prog.copyToClipboard(reg)
+		regs.unnamed = reg
+
+	case isNamedRegister(name):
+		regs.unnamed = regs.setNamed(name, reg)
+
+	default:
+		if !isDelete {
+			regs.lastYank = reg
+		}
+		if !isDelete || prog.settings.yankdeletes {
+			regs.unnamed = reg
+			if prog.settings.unnamedclipboard {
+				prog.copyToClipboard(reg)
+			}
+		}
+
+		regs.history = append([]Register{reg}, regs.history...)
+		if len(regs.history) > registerHistorySize {
+			regs.history = regs.history[:registerHistorySize]
+		}
+	}
+}
+
+// Returning the text in the picked register, or the unnamed one
+func (prog *Program[T]) selectedRegister() Register {
+	regs := &prog.state.registers
+	name := regs.selected
+
+	switch {
+	case name == '_':
+		return Register{}
+	case name == '+' || name == '*' || (name == 0 && prog.settings.unnamedclipboard):
+		return prog.pasteFromClipboard()
+	case name == '0':
+		return regs.lastYank
+	case '1' <= name && name <= '9':
+		if idx := int(name - '1'); idx < len(regs.history) {
+			return regs.history[idx]
+		}
+		return Register{}
+	case 'a' <= name && name <= 'z':
+		return regs.named[name-'a']
+	case 'A' <= name && name <= 'Z':
+		return regs.named[unicode.ToLower(name)-'a']
+	}
+	return regs.unnamed
+}
+
+// Putting the picked register, and remembering the put so it can be cycled
+func (prog *Program[T]) putRegister(after bool, count int) {
+	regs := &prog.state.registers
+	reg := prog.selectedRegister()
+	if reg.isEmpty() {
+		if regs.selected != 0 {
+			prog.setError(fmt.Errorf("Nothing in register %c", regs.selected))
+		}
+		return
+	}
+
+	historyIdx := -1
+	for i, entry := range regs.history {
+		if entry.equals(reg) {
+			historyIdx = i
+			break
+		}
+	}
+
+	prog.put(reg, after, count)
+	prog.rememberPut(historyIdx, after, count)
+}
+
+func (prog *Program[T]) rememberPut(historyIdx int, after bool, count int) {
+	history := &prog.getActiveBuffer().history
+	history.init()
+
+	prog.state.registers.lastPut = &PutState{
+		historyIdx: historyIdx,
+		after:      after,
+		count:      count,
+		bufferIdx:  prog.getActivePanel().bufferIdx,
+		undoSeq:    len(history.states),
+	}
+}
+
+// Swapping the text that was just put for an older (step > 0)
+// or newer (step < 0) entry in the yank and delete history
+func (prog *Program[T]) cyclePut(step int) {
+	regs := &prog.state.registers
+	last := regs.lastPut
+	buffer := prog.getActiveBuffer()
+	history := &buffer.history
+
+	if last == nil || last.bufferIdx != prog.getActivePanel().bufferIdx ||
+		history.current == nil || history.current.seq != last.undoSeq {
+		prog.setStatus("Can only cycle right after putting")
+		return
+	}
+
+	idx := last.historyIdx + step
+	if idx < 0 || idx >= len(regs.history) {
+		if step > 0 {
+			prog.setStatus("No older entries to put")
+		} else {
+			prog.setStatus("No newer entries to put")
+		}
+		return
+	}
+
+	cursor, _ := buffer.undo()
+	prog.restoreCursorAfterUndo(cursor)
+	history.markCursor(cursor)
+
+	prog.put(regs.history[idx], last.after, last.count)
+	prog.rememberPut(idx, last.after, last.count)
+	prog.setStatus("Put history entry %d of %d", idx+1, len(regs.history))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeleteKeepsYankedText(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nbaz")
+	p.processKeys("yyjddP")
+	p.assertBufferContent(t, "foo", "foo", "baz")
+}
+
+func TestYankDeletesSetting(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nbaz")
+	p.settings.yankdeletes = true
+	p.processKeys("yyjddP")
+	p.assertBufferContent(t, "foo", "bar", "baz")
+}
+
+func TestNamedRegisters(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("\"ayiww\"byiw")
+	p.processKeys("$\"ap\"bp")
+	p.assertBufferContent(t, "foo barfoobar")
+}
+
+func TestAppendToNamedRegister(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("\"ayiww\"Ayiw$\"ap")
+	p.assertBufferContent(t, "foo barfoobar")
+
+	p = testingProgramFromBuf("a\nb\nc")
+	p.processKeys("\"ayyj\"Ayyj\"aP")
+	p.assertBufferContent(t, "a", "b", "a", "b", "c")
+}
+
+func TestBlackHoleRegister(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc")
+	p.settings.yankdeletes = true
+	p.processKeys("yyj\"_ddP")
+	p.assertBufferContent(t, "a", "a", "c")
+}
+
+func TestNumberedRegisters(t *testing.T) {
+	p := testingProgramFromBuf("a\nb\nc\nd")
+	p.processKeys("yyjddddjdd")
+	p.assertBufferContent(t, "a")
+
+	// `1` is the latest yank or delete, and `0` is the latest yank
+	p.processKeys("\"1p\"2p\"3p\"0p")
+	p.assertBufferContent(t, "a", "d", "c", "b", "a")
+}
+
+func TestPutEmptyRegister(t *testing.T) {
+	p := testingProgramFromBuf("a")
+	p.processKeys("\"zp")
+	p.assertBufferContent(t, "a")
+	if !p.state.statusIsError {
+		t.Errorf("wanted an error for putting an empty register")
+	}
+}
+
+func TestRegisterIsForgottenAfterCommand(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar")
+	p.processKeys("\"ayyjyyP")
+	p.assertBufferContent(t, "foo", "bar", "bar")
+	if reg := p.state.registers.named[0]; len(reg.text) != 1 || reg.text[0] != "foo" {
+		t.Errorf("wanted register a to hold foo, got %v", reg.text)
+	}
+}
+
+func TestVisualYankToRegister(t *testing.T) {
+	p := testingProgramFromBuf("foo bar")
+	p.processKeys("v\"ay")
+	p.processKeys("$\"ap")
+	p.assertBufferContent(t, "foo barf")
+}
+
+func TestCyclePut(t *testing.T) {
+	p := testingProgramFromBuf("one\ntwo\nthree")
+	p.processKeys("yyjyyjyy")
+
+	p.processKeys("p")
+	p.assertBufferContent(t, "one", "two", "three", "three")
+
+	p.processKeys("\x10")
+	p.assertBufferContent(t, "one", "two", "three", "two")
+
+	p.processKeys("\x10")
+	p.assertBufferContent(t, "one", "two", "three", "one")
+
+	p.processKeys("\x10")
+	p.assertBufferContent(t, "one", "two", "three", "one")
+
+	p.processKeys("\x0e")
+	p.assertBufferContent(t, "one", "two", "three", "two")
+
+	// Undoing the swapped put leaves the buffer as it was before putting
+	p.processKeys("u")
+	if n := len(p.getActiveBuffer().lines); n != 3 {
+		t.Errorf("wanted 3 lines after undoing the put, got %d", n)
+	}
+}
+
+func TestCyclePutOnlyAfterPut(t *testing.T) {
+	p := testingProgramFromBuf("one\ntwo")
+	p.processKeys("yyjyyp")
+	p.processKeys("x\x10")
+	p.assertBufferContent(t, "one", "two", "wo")
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Sending a ResizeEvent whenever the terminal is resized,
+// which the terminal announces with SIGWINCH
+type ResizeProducer struct {
+	signals chan os.Signal
+}
+
+func newResizeProducer() ResizeProducer {
+	p := ResizeProducer{signals: make(chan os.Signal, 1)}
+	signal.Notify(p.signals, syscall.SIGWINCH)
+	return p
+}
+
+func (p ResizeProducer) run(loop *EventLoop) {
+	for {
+		select {
+		case <-p.signals:
+			if !loop.send(ResizeEvent{}) {
+				return
+			}
+		case <-loop.done:
+			return
+		}
+	}
+}
+
+func (p ResizeProducer) stop() {
+	signal.Stop(p.signals)
+}
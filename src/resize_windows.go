@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+// Windows has no SIGWINCH, so resizes are only noticed on restart
+type ResizeProducer struct{}
+
+func newResizeProducer() ResizeProducer {
+	return ResizeProducer{}
+}
+
+func (ResizeProducer) run(loop *EventLoop) {}
+
+func (ResizeProducer) stop() {}
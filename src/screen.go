@@ -0,0 +1,200 @@
+package main
+
+// How a cell is drawn, besides its rune
+type CellStyle uint8
+
+const (
+	StyleNormal CellStyle = iota
+	StyleHighlight
+)
+
+// One column of one row of the screen. A rune that's wider than one
+// column is followed by cells of width 0, which it covers.
+type Cell struct {
+	r     rune
+	width int
+	style CellStyle
+}
+
+var blankCell = Cell{r: ' ', width: 1}
+
+// The screen as the terminal is showing it (front), and as the next
+// frame should look (back). Each frame is drawn into the back grid,
+// and only the cells that differ from the front grid are sent.
+type Screen struct {
+	width  int
+	height int
+	front  []Cell
+	back   []Cell
+
+	// Where the terminal's cursor was left by the last frame
+	cursorX int
+	cursorY int
+
+	// Set when what the terminal shows isn't known, like at startup,
+	// so the next frame clears it and draws every cell
+	invalid bool
+}
+
+func newScreen(width, height int) Screen {
+	s := Screen{}
+	s.resize(width, height)
+	return s
+}
+
+func (s *Screen) resize(width, height int) {
+	s.width = max(width, 0)
+	s.height = max(height, 0)
+	s.front = make([]Cell, s.width*s.height)
+	s.back = make([]Cell, s.width*s.height)
+	s.clear()
+	s.invalidate()
+}
+
+// Forgetting what the terminal shows, so the next frame repaints it all
+func (s *Screen) invalidate() {
+	s.invalid = true
+}
+
+// Starting a frame with blank cells in the back grid
+func (s *Screen) clear() {
+	for i := range s.back {
+		s.back[i] = blankCell
+	}
+}
+
+func (s *Screen) cellAt(x, y int) Cell {
+	if x < 0 || y < 0 || x >= s.width || y >= s.height {
+		return blankCell
+	}
+	return s.back[y*s.width+x]
+}
+
+// Drawing runes into the back grid from x, up to maxWidth columns
+// or the edge of the screen. Returning how many columns were used.
+func (s *Screen) drawText(x, y int, runes []rune, style CellStyle, maxWidth int) int {
+	if y < 0 || y >= s.height {
+		return 0
+	}
+
+	end := min(x+maxWidth, s.width)
+	start := x
+	for _, r := range runes {
+		// Keeping control chars from reaching the terminal as commands
+		if r < ' ' || r == 0x7f {
+			r = '?'
+		}
+
+		// A wide rune that would be cut in half isn't drawn
+		width := charWidth(r)
+		if x+width > end {
+			break
+		}
+
+		s.back[y*s.width+x] = Cell{r: r, width: width, style: style}
+		for i := 1; i < width; i++ {
+			s.back[y*s.width+x+i] = Cell{width: 0, style: style}
+		}
+		x += width
+	}
+	return x - start
+}
+
+// Changing the style of the cells from x, up to width columns,
+// without changing what's drawn in them
+func (s *Screen) setStyle(x, y, width int, style CellStyle) {
+	if y < 0 || y >= s.height {
+		return
+	}
+	for i := max(x, 0); i < min(x+width, s.width); i++ {
+		s.back[y*s.width+i].style = style
+	}
+}
+
+// Sending what changed since the last frame to the terminal, and leaving
+// its cursor at cursorX, cursorY. Nothing is sent if nothing changed.
+func flushScreen[T Terminal](s *Screen, term T, cursorX, cursorY int, synchronized bool) {
+	if !s.invalid && cursorX == s.cursorX && cursorY == s.cursorY && !s.hasChanges() {
+		term.flush()
+		return
+	}
+
+	if synchronized {
+		term.beginSynchronizedUpdate()
+	}
+
+	// Cells of a cleared screen are blank, so only the others are drawn
+	if s.invalid {
+		term.clearScreen()
+		for i := range s.front {
+			s.front[i] = blankCell
+		}
+		s.invalid = false
+	}
+
+	// Runs of changed cells on a row are printed together,
+	// and moving the cursor is only needed between runs
+	style := StyleNormal
+	run := []rune{}
+	termX, termY := -1, -1
+
+	printRun := func() {
+		if len(run) > 0 {
+			term.printf("%s", string(run))
+			run = run[:0]
+		}
+	}
+
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			i := y*s.width + x
+			cell := s.back[i]
+			if cell == s.front[i] || cell.width == 0 {
+				continue
+			}
+
+			if x != termX || y != termY {
+				printRun()
+				term.setCursorPosition(x, y)
+			}
+			if cell.style != style {
+				printRun()
+				if cell.style == StyleHighlight {
+					term.startHighlight()
+				} else {
+					term.endHighlight()
+				}
+				style = cell.style
+			}
+
+			// The terminal moves its cursor past every column the rune covers
+			run = append(run, cell.r)
+			for j := 0; j < cell.width && x+j < s.width; j++ {
+				s.front[i+j] = s.back[i+j]
+			}
+			termX, termY = x+cell.width, y
+		}
+	}
+
+	printRun()
+	if style != StyleNormal {
+		term.endHighlight()
+	}
+
+	term.setCursorPosition(cursorX, cursorY)
+	s.cursorX, s.cursorY = cursorX, cursorY
+
+	if synchronized {
+		term.endSynchronizedUpdate()
+	}
+	term.flush()
+}
+
+func (s *Screen) hasChanges() bool {
+	for i := range s.back {
+		if s.back[i] != s.front[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func flushToString(s *Screen, cursorX, cursorY int, synchronized bool) string {
+	out := &bytes.Buffer{}
+	flushScreen(s, ANSI{out: out}, cursorX, cursorY, synchronized)
+	return out.String()
+}
+
+func TestScreenFirstFrameClears(t *testing.T) {
+	s := newScreen(4, 2)
+	s.drawText(0, 0, []rune("ab"), StyleNormal, 4)
+
+	got := flushToString(&s, 1, 0, true)
+	want := "\x1b[?2026h\x1b[2J\x1b[1;1Hab\x1b[1;2H\x1b[?2026l"
+	if got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+func TestScreenOnlySendsChanges(t *testing.T) {
+	s := newScreen(4, 2)
+	s.drawText(0, 0, []rune("abc"), StyleNormal, 4)
+	s.drawText(0, 1, []rune("def"), StyleNormal, 4)
+	flushToString(&s, 0, 0, false)
+
+	// Nothing is sent when nothing changed
+	s.clear()
+	s.drawText(0, 0, []rune("abc"), StyleNormal, 4)
+	s.drawText(0, 1, []rune("def"), StyleNormal, 4)
+	if got := flushToString(&s, 0, 0, false); got != "" {
+		t.Errorf("wanted nothing sent, got %q", got)
+	}
+
+	// Only moving the cursor
+	if got := flushToString(&s, 2, 1, false); got != "\x1b[2;3H" {
+		t.Errorf("wanted only a cursor move, got %q", got)
+	}
+
+	// Changing a cell, and blanking the end of a line
+	s.clear()
+	s.drawText(0, 0, []rune("aXc"), StyleNormal, 4)
+	s.drawText(0, 1, []rune("d"), StyleNormal, 4)
+	got := flushToString(&s, 0, 0, false)
+	want := "\x1b[1;2HX\x1b[2;2H  \x1b[1;1H"
+	if got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+func TestScreenHighlight(t *testing.T) {
+	s := newScreen(4, 1)
+	flushToString(&s, 0, 0, false)
+
+	s.drawText(0, 0, []rune("a"), StyleNormal, 1)
+	s.drawText(1, 0, []rune("bc"), StyleHighlight, 2)
+	got := flushToString(&s, 0, 0, false)
+	want := "\x1b[1;1Ha\x1b[7mbc\x1b[27m\x1b[1;1H"
+	if got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+
+	// Unhighlighting redraws the cells, since each frame
+	// starts with the style that the last one ended with
+	s.clear()
+	s.drawText(0, 0, []rune("abc"), StyleNormal, 4)
+	got = flushToString(&s, 0, 0, false)
+	want = "\x1b[1;2Hbc\x1b[1;1H"
+	if got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+func TestScreenDrawTextClips(t *testing.T) {
+	s := newScreen(4, 1)
+	if n := s.drawText(2, 0, []rune("abc"), StyleNormal, 10); n != 2 {
+		t.Errorf("wanted 2 columns drawn, got %d", n)
+	}
+	if n := s.drawText(0, 0, []rune("abc"), StyleNormal, 1); n != 1 {
+		t.Errorf("wanted 1 column drawn, got %d", n)
+	}
+	if c := s.cellAt(0, 0); c.r != 'a' {
+		t.Errorf("wanted a, got %q", c.r)
+	}
+	if c := s.cellAt(3, 0); c.r != 'b' {
+		t.Errorf("wanted b, got %q", c.r)
+	}
+
+	// Control chars are drawn as something harmless
+	s.drawText(0, 0, []rune("\x1b"), StyleNormal, 1)
+	if c := s.cellAt(0, 0); c.r != '?' {
+		t.Errorf("wanted a placeholder, got %q", c.r)
+	}
+}
+
+func TestScreenWideRunes(t *testing.T) {
+	s := newScreen(5, 1)
+	if n := s.drawText(0, 0, []rune("a日b"), StyleNormal, 5); n != 4 {
+		t.Errorf("wanted 4 columns drawn, got %d", n)
+	}
+	if c := s.cellAt(1, 0); c.r != '日' || c.width != 2 {
+		t.Errorf("wanted a wide cell, got %q of width %d", c.r, c.width)
+	}
+	if c := s.cellAt(2, 0); c.width != 0 {
+		t.Errorf("wanted the cell it covers to have width 0, got %d", c.width)
+	}
+
+	// The covered cell isn't sent, and the cursor moves past both
+	got := flushToString(&s, 0, 0, false)
+	if want := "\x1b[2J\x1b[1;1Ha日b\x1b[1;1H"; got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+
+	// Changing what comes after a wide rune only sends that
+	s.clear()
+	s.drawText(0, 0, []rune("a日X"), StyleNormal, 5)
+	if got, want := flushToString(&s, 0, 0, false), "\x1b[1;4HX\x1b[1;1H"; got != want {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+
+	// A wide rune that would be cut in half isn't drawn
+	s.clear()
+	if n := s.drawText(0, 0, []rune("ab日"), StyleNormal, 3); n != 2 {
+		t.Errorf("wanted 2 columns drawn, got %d", n)
+	}
+}
+
+func TestScreenInvalidateRepaints(t *testing.T) {
+	s := newScreen(2, 1)
+	s.drawText(0, 0, []rune("ab"), StyleNormal, 2)
+	flushToString(&s, 0, 0, false)
+
+	s.invalidate()
+	got := flushToString(&s, 0, 0, false)
+	if !strings.HasPrefix(got, "\x1b[2J") || !strings.Contains(got, "ab") {
+		t.Errorf("wanted the screen cleared and redrawn, got %q", got)
+	}
+}
+
+// An ANSI terminal of a fixed size, with its output counted
+type countingTerminal struct {
+	ANSI
+}
+
+func (countingTerminal) getSize() (rows, cols int, err error) {
+	return 40, 120, nil
+}
+
+type byteCounter struct {
+	n int
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+func benchmarkProgram(counter *byteCounter) *Program[countingTerminal] {
+	lines := []BufferLine{}
+	for i := 0; i < 4; i++ {
+		for _, content := range strings.Split(basicBuf, "\n") {
+			lines = append(lines, BufferLine{content: content})
+		}
+	}
+
+	prog := &Program[countingTerminal]{
+		logger:   func(string) error { return nil },
+		term:     countingTerminal{ANSI{out: counter}},
+		settings: defaultSettings(),
+	}
+	prog.settings.undofile = false
+	prog.state.buffers = []Buffer{{filepath: "bench", lines: lines}}
+	initializeState(prog)
+	redraw(prog)
+	return prog
+}
+
+// Typing keys that move around, scroll and select, and
+// reporting how many bytes each one sends to the terminal
+func benchmarkRedraw(b *testing.B, fullRepaint bool) {
+	counter := &byteCounter{}
+	prog := benchmarkProgram(counter)
+	keys := []rune("jjjjwwwbbllhhvjjj\x1bGggkkjj}{")
+
+	counter.n = 0
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		processInput(prog, keys[i%len(keys)])
+		prog.scrollToCursor()
+		if fullRepaint {
+			prog.state.screen.invalidate()
+		}
+		redraw(prog)
+	}
+
+	b.ReportMetric(float64(counter.n)/float64(b.N), "bytes/key")
+}
+
+// Repainting every cell on every key, as redraw used to
+func BenchmarkRedrawFullRepaint(b *testing.B) {
+	benchmarkRedraw(b, true)
+}
+
+func BenchmarkRedrawIncremental(b *testing.B) {
+	benchmarkRedraw(b, false)
+}
+
+func TestRedrawScreens(t *testing.T) {
+	p := testingProgramWithSize("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}", 8, 30)
+	p.processKeys("jj")
+	p.assertScreen(t, "redraw_buffer")
+
+	p.processKeys(":set ts")
+	p.assertScreen(t, "redraw_command_line")
+
+	p.processKeys("\x1bi")
+	p.assertScreen(t, "redraw_insert")
+
+	p.processKeys("\x1bVj")
+	p.assertScreen(t, "redraw_visual")
+}
+
+func TestRedrawScrolls(t *testing.T) {
+	p := testingProgramWithSize("1\n2\n3\n4\n5\n6\n7\n8\n9", 5, 10)
+	p.processKeys("G")
+	p.assertScreen(t, "redraw_scrolled")
+
+	// Lines that scroll out of view don't leave anything behind
+	p.processKeys("gg")
+	p.assertScreen(t, "redraw_scrolled_back")
+}
+
+func TestRedrawStatus(t *testing.T) {
+	p := testingProgramWithSize("foo", 4, 30)
+	p.processKeys(":nosuchcommand\n")
+	p.assertScreen(t, "redraw_error")
+
+	p = testingProgramWithSize("foo", 4, 30)
+	p.processKeys("qa")
+	p.assertScreen(t, "redraw_recording")
+
+	// A shorter message replaces all of the longer one
+	p.processKeys("q:nosuchcommand\n:set ts?\n")
+	p.assertScreen(t, "redraw_shorter_status")
+}
+
+func TestMockTerminalDraws(t *testing.T) {
+	term := newMockTerminal(3, 5)
+	term.setCursorPosition(1, 1)
+	term.printf("ab%d", 1)
+	term.setCursorPosition(3, 2)
+	term.printf("xyz")
+
+	if got := term.text(); got != "\n ab1\n   xy" {
+		t.Errorf("wanted text drawn at the cursor, got %q", got)
+	}
+	if x, y, _ := term.getCursorPosition(); x != 5 || y != 2 {
+		t.Errorf("wanted the cursor after the text, got %d,%d", x, y)
+	}
+
+	term.startHighlight()
+	term.setCursorPosition(0, 0)
+	term.printf("h")
+	term.endHighlight()
+	term.printf("n")
+	if !term.highlighted[0][0] || term.highlighted[0][1] {
+		t.Errorf("wanted only the first cell highlighted")
+	}
+
+	term.clearScreen()
+	if got := term.text(); got != "" {
+		t.Errorf("wanted a blank screen, got %q", got)
+	}
+}
+
+func TestResize(t *testing.T) {
+	p := testingProgramWithSize("1\n2\n3\n4\n5\n6\n7\n8 is a longer line", 10, 12)
+	p.processKeys("G")
+	p.assertScreen(t, "resize_before")
+
+	// Shrinking scrolls, so that the cursor stays in view
+	p.term.resize(5, 12)
+	p.processEvents(ResizeEvent{})
+	p.assertScreen(t, "resize_shorter")
+
+	panel := p.getActivePanel()
+	if panel.height != 3 || panel.width != 7 {
+		t.Errorf("wanted the panel fitted to the terminal, got %dx%d", panel.width, panel.height)
+	}
+
+	// Growing shows more of each line, and the bottom chrome moves down
+	p.processKeys(":set ts?\n")
+	p.term.resize(6, 30)
+	p.processEvents(ResizeEvent{})
+	p.assertScreen(t, "resize_wider")
+}
+
+func TestResizeIsNotAKey(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("qa")
+	p.processEvents(ResizeEvent{})
+	p.processKeys("xq")
+
+	if reg := p.state.registers.named[0]; len(reg.text) != 1 || reg.text[0] != "x" {
+		t.Errorf("wanted the resize left out of the macro, got %q", reg.text)
+	}
+}
+
+func TestSynchronizedOutputSetting(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := testingProgramWithTerm("abc", countingTerminal{ANSI{out: out}})
+
+	// Not every terminal supports the markers, so they're only sent once it says so
+	p.processKeys("l")
+	if strings.Contains(out.String(), "\x1b[?2026h") {
+		t.Errorf("wanted no synchronized output markers by default")
+	}
+
+	p.processEvents(ModeReportEvent{mode: synchronizedOutputMode, state: 0})
+	if p.settings.syncoutput {
+		t.Errorf("wanted the markers left off for a terminal without them")
+	}
+
+	p.processEvents(ModeReportEvent{mode: synchronizedOutputMode, state: 2})
+	out.Reset()
+	p.processKeys("h")
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[?2026h") || !strings.HasSuffix(got, "\x1b[?2026l") {
+		t.Errorf("wanted the frame wrapped in markers, got %q", got)
+	}
+
+	// They can still be turned off by hand
+	p.processKeys(":set nosync\n")
+	out.Reset()
+	p.processKeys("l")
+	if strings.Contains(out.String(), "\x1b[?2026h") {
+		t.Errorf("wanted no markers after turning them off")
+	}
+}
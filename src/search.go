@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The last search, which `n` and `N` repeat
+type SearchState struct {
+	pattern string
+	forward bool
+
+	// Where the cursor and view were when the search prompt opened,
+	// which the cursor returns to whenever the typed pattern changes
+	origin    Position
+	originTop int
+	count     int
+}
+
+// Patterns use Go's regexp syntax, along with some of vim's:
+// `\<` and `\>` match the start and end of a word, `\c` and `\C`
+// make the search ignore case or not, and a leading `\v` makes the
+// pattern "very magic", where `<` and `>` are word boundaries and
+// `=` is the same as `?`. Returning the Go pattern, the case flag
+// that was given ('c', 'C' or 0), and whether it has uppercase letters.
+func translatePattern(pattern string) (string, rune, bool) {
+	runes := []rune(pattern)
+	veryMagic := false
+	if strings.HasPrefix(pattern, `\v`) {
+		veryMagic = true
+		runes = runes[2:]
+	}
+
+	var sb strings.Builder
+	caseFlag := rune(0)
+	hasUpper := false
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if r == '\\' && i+1 < len(runes) {
+			i++
+			next := runes[i]
+			switch {
+			case next == 'c' || next == 'C':
+				caseFlag = next
+			case (next == '<' || next == '>') && !veryMagic:
+				sb.WriteString(`\b`)
+			case (next == '<' || next == '>' || next == '=') && veryMagic:
+				sb.WriteRune(next)
+			default:
+				sb.WriteRune('\\')
+				sb.WriteRune(next)
+			}
+			continue
+		}
+
+		switch {
+		case veryMagic && (r == '<' || r == '>'):
+			sb.WriteString(`\b`)
+		case veryMagic && r == '=':
+			sb.WriteRune('?')
+		default:
+			sb.WriteRune(r)
+		}
+		hasUpper = hasUpper || unicode.IsUpper(r)
+	}
+
+	return sb.String(), caseFlag, hasUpper
+}
+
+// Compiling a search pattern. The ignorecase setting makes searches ignore
+// case, unless smartcase is on and the pattern has uppercase letters.
+func compilePattern(pattern string, settings *Settings, useSmartcase bool) (*regexp.Regexp, error) {
+	translated, caseFlag, hasUpper := translatePattern(pattern)
+
+	ignoreCase := settings.ignorecase && !(useSmartcase && settings.smartcase && hasUpper)
+	if caseFlag != 0 {
+		ignoreCase = caseFlag == 'c'
+	}
+	if ignoreCase {
+		translated = "(?i)" + translated
+	}
+
+	re, err := regexp.Compile(translated)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern: %s", pattern)
+	}
+	return re, nil
+}
+
+// Finding the matches of a pattern in a line, as rune ranges
+func lineMatches(re *regexp.Regexp, line string) []TextRange {
+	matches := []TextRange{}
+	for _, idx := range re.FindAllStringIndex(line, -1) {
+		start := utf8.RuneCountInString(line[:idx[0]])
+		end := start + utf8.RuneCountInString(line[idx[0]:idx[1]])
+		matches = append(matches, TextRange{start: Position{x: start}, end: Position{x: end}})
+	}
+	return matches
+}
+
+// Finding the next match after a position, or before it when searching
+// backwards, going around the end of the buffer if needed. Returning
+// whether the search went around the end.
+func searchBuffer(re *regexp.Regexp, buffer *Buffer, from Position, forward bool) (Position, bool, bool) {
+	lineCount := len(buffer.lines)
+
+	for k := 0; k <= lineCount; k++ {
+		y := from.y + k
+		if !forward {
+			y = from.y - k
+		}
+		wrapped := y < 0 || y >= lineCount
+		y = (y%lineCount + lineCount) % lineCount
+
+		matches := lineMatches(re, buffer.lineContent(y))
+		if !forward {
+			for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+				matches[i], matches[j] = matches[j], matches[i]
+			}
+		}
+
+		for _, match := range matches {
+			x := match.start.x
+			switch {
+			// Skipping the match at the cursor on its own line,
+			// and only looking at the rest of it after going around
+			case k == 0 && forward && x <= from.x:
+			case k == 0 && !forward && x >= from.x:
+			case k == lineCount && forward && x > from.x:
+			case k == lineCount && !forward && x < from.x:
+			default:
+				return Position{x, y}, wrapped, true
+			}
+		}
+	}
+
+	return from, false, false
+}
+
+// Searching count times from the cursor, and saying so when the search
+// went around the end of the buffer, or found nothing
+func (m *MotionContext) searchFromCursor(pattern string, forward bool, count int, useSmartcase bool) (Position, bool) {
+	re, err := compilePattern(pattern, m.settings, useSmartcase)
+	if err != nil {
+		m.setStatus(true, "%v", err)
+		return m.cursor, false
+	}
+
+	pos := m.cursor
+	anyWrapped := false
+	for i := 0; i < count; i++ {
+		next, wrapped, ok := searchBuffer(re, m.buffer, pos, forward)
+		if !ok {
+			m.setStatus(true, "Pattern not found: %s", pattern)
+			return m.cursor, false
+		}
+		pos = next
+		anyWrapped = anyWrapped || wrapped
+	}
+
+	switch {
+	case anyWrapped && forward:
+		m.setStatus(false, "search hit BOTTOM, continuing at TOP")
+	case anyWrapped:
+		m.setStatus(false, "search hit TOP, continuing at BOTTOM")
+	default:
+		m.setStatus(false, "%c%s", searchPrompt(forward), pattern)
+	}
+	return pos, true
+}
+
+func (m *MotionContext) setStatus(isError bool, format string, args ...interface{}) {
+	m.state.statusMessage = fmt.Sprintf(format, args...)
+	m.state.statusIsError = isError
+	m.state.needsRedraw = true
+}
+
+func searchPrompt(forward bool) rune {
+	if forward {
+		return '/'
+	}
+	return '?'
+}
+
+func repeatSearch(m *MotionContext, count int, reversed bool) (Position, bool) {
+	search := m.state.search
+	if search.pattern == "" {
+		m.setStatus(true, "No previous regular expression")
+		return m.cursor, false
+	}
+	return m.searchFromCursor(search.pattern, search.forward != reversed, count, true)
+}
+
+func motionSearchNext(m *MotionContext, count int) (Position, bool) {
+	return repeatSearch(m, count, false)
+}
+
+func motionSearchPrevious(m *MotionContext, count int) (Position, bool) {
+	return repeatSearch(m, count, true)
+}
+
+// Searching for the word under the cursor, or the next word after it on
+// its line. Only whole words match, and smartcase doesn't apply.
+func searchWord(m *MotionContext, count int, forward bool) (Position, bool) {
+	line := m.line(m.cursor.y)
+
+	start := m.cursor.x
+	for start < len(line) && charClass(line[start], false) != classKeyword {
+		start++
+	}
+	if start == len(line) {
+		m.setStatus(true, "No string under cursor")
+		return m.cursor, false
+	}
+	for start > 0 && charClass(line[start-1], false) == classKeyword {
+		start--
+	}
+	end := start
+	for end < len(line) && charClass(line[end], false) == classKeyword {
+		end++
+	}
+
+	pattern := `\<` + regexp.QuoteMeta(string(line[start:end])) + `\>`
+	m.state.search.pattern = pattern
+	m.state.search.forward = forward
+	m.state.searchHistory = appendHistory(m.state.searchHistory, pattern)
+
+	// Starting from the word's start, so a backward search skips it
+	m.cursor.x = start
+	return m.searchFromCursor(pattern, forward, count, false)
+}
+
+func motionSearchWordForward(m *MotionContext, count int) (Position, bool) {
+	return searchWord(m, count, true)
+}
+
+func motionSearchWordBackward(m *MotionContext, count int) (Position, bool) {
+	return searchWord(m, count, false)
+}
+
+// Opening the search prompt, remembering where the cursor was
+// so that the search can start there as the pattern is typed
+func (prog *Program[T]) openSearch(forward bool, count int) {
+	panel := prog.getActivePanel()
+	prog.state.search.origin = Position{panel.logicalCursorX, panel.logicalCursorY}
+	prog.state.search.originTop = prog.getActiveBuffer().topVisibleLineIdx
+	prog.state.search.count = count
+	prog.openCommandLine(searchPrompt(forward))
+}
+
+func isSearchPrompt(prompt rune) bool {
+	return prompt == '/' || prompt == '?'
+}
+
+func (prog *Program[T]) restoreSearchOrigin() {
+	search := &prog.state.search
+	prog.setLogicalCursorPosition(search.origin.x, search.origin.y)
+	prog.getActiveBuffer().topVisibleLineIdx = search.originTop
+	prog.scrollToCursor()
+}
+
+// Moving the cursor to the first match of the pattern being typed
+func (prog *Program[T]) previewSearch() {
+	cl := &prog.state.commandLine
+	prog.restoreSearchOrigin()
+	if len(cl.text) == 0 {
+		return
+	}
+
+	m := prog.motionContext()
+	target, ok := m.searchFromCursor(string(cl.text), cl.prompt == '/', prog.state.search.count, true)
+	prog.state.statusMessage = ""
+	if ok {
+		prog.setLogicalCursorPosition(target.x, target.y)
+		prog.scrollToCursor()
+	}
+}
+
+// Running the search typed into the prompt, where an empty pattern
+// searches for the last pattern again
+func (prog *Program[T]) runSearch(pattern string, forward bool) {
+	search := &prog.state.search
+	prog.restoreSearchOrigin()
+
+	if pattern != "" {
+		search.pattern = pattern
+	}
+	search.forward = forward
+
+	if !prog.moveWithMotion(Motions["n"], prog.motionContext(), search.count) {
+		prog.commandFailed()
+	}
+}
+
+// Returning a function that finds the visual columns of the matches on
+// a line, for highlighting them while a search pattern is being typed,
+// or the match that a substitution is asking about
+func (prog *Program[T]) searchMatchColumnsFunc() func(y int) []ColumnSpan {
+	if prog.state.currentMode == ConfirmMode {
+		return prog.confirmMatchColumns
+	}
+
+	cl := &prog.state.commandLine
+	if prog.state.currentMode != CommandMode || !isSearchPrompt(cl.prompt) || len(cl.text) == 0 {
+		return func(y int) []ColumnSpan { return nil }
+	}
+
+	re, err := compilePattern(string(cl.text), &prog.settings, true)
+	if err != nil {
+		return func(y int) []ColumnSpan { return nil }
+	}
+
+	buffer := prog.getActiveBuffer()
+	return func(y int) []ColumnSpan {
+		line := buffer.lineContent(y)
+		spans := []ColumnSpan{}
+		for _, match := range lineMatches(re, line) {
+			if match.end.x > match.start.x {
+				spans = append(spans, ColumnSpan{
+					from: getVisualX(line, match.start.x, &prog.settings),
+					to:   getVisualX(line, match.end.x, &prog.settings),
+				})
+			}
+		}
+		return spans
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchForward(t *testing.T) {
+	p := testingProgramFromBuf("foo bar\nbaz bar\nqux")
+	p.processKeys("/bar\n")
+	p.assertLogicalPos(t, 4, 0)
+
+	p.processKeys("n")
+	p.assertLogicalPos(t, 4, 1)
+
+	p.processKeys("N")
+	p.assertLogicalPos(t, 4, 0)
+}
+
+func TestSearchBackward(t *testing.T) {
+	p := testingProgramFromBuf("foo bar\nbaz bar\nqux")
+	p.processKeys("G?ba\n")
+	p.assertLogicalPos(t, 4, 1)
+
+	p.processKeys("n")
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processKeys("N")
+	p.assertLogicalPos(t, 4, 1)
+}
+
+func TestSearchWithCount(t *testing.T) {
+	p := testingProgramFromBuf("a x x x x")
+	p.processKeys("3/x\n")
+	p.assertLogicalPos(t, 6, 0)
+
+	p.processKeys("2N")
+	p.assertLogicalPos(t, 2, 0)
+}
+
+func TestSearchWrapsAround(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nfoo")
+	p.processKeys("G/foo\n")
+	p.assertLogicalPos(t, 0, 0)
+	if p.state.statusMessage != "search hit BOTTOM, continuing at TOP" {
+		t.Errorf("wanted a message about wrapping, got %q", p.state.statusMessage)
+	}
+
+	p.processKeys("?foo\n")
+	p.assertLogicalPos(t, 0, 2)
+	if p.state.statusMessage != "search hit TOP, continuing at BOTTOM" {
+		t.Errorf("wanted a message about wrapping, got %q", p.state.statusMessage)
+	}
+
+	// The only match is the one under the cursor
+	p = testingProgramFromBuf("foo bar")
+	p.processKeys("/foo\n")
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestSearchNotFound(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar")
+	p.processKeys("j/nothing\n")
+	p.assertLogicalPos(t, 0, 1)
+	if p.state.statusMessage != "Pattern not found: nothing" || !p.state.statusIsError {
+		t.Errorf("wanted a not found error, got %q", p.state.statusMessage)
+	}
+
+	p.processKeys("/a(\n")
+	if !p.state.statusIsError {
+		t.Errorf("wanted an error for an invalid pattern")
+	}
+}
+
+func TestSearchRegex(t *testing.T) {
+	p := testingProgramFromBuf("a1 b22 c333")
+	p.processKeys(`/\d{3}` + "\n")
+	p.assertLogicalPos(t, 8, 0)
+
+	p = testingProgramFromBuf("cat concat cat")
+	p.processKeys(`w/\<cat\>` + "\n")
+	p.assertLogicalPos(t, 11, 0)
+
+	p = testingProgramFromBuf("cat concat cat")
+	p.processKeys(`w/\v<cat>` + "\n")
+	p.assertLogicalPos(t, 11, 0)
+
+	p = testingProgramFromBuf("color colour")
+	p.processKeys(`w0/\vcolou=r` + "\n")
+	p.assertLogicalPos(t, 6, 0)
+}
+
+func TestSearchUnicode(t *testing.T) {
+	p := testingProgramFromBuf("héllo wörld")
+	p.processKeys("/wö\n")
+	p.assertLogicalPos(t, 6, 0)
+}
+
+func TestSearchCase(t *testing.T) {
+	p := testingProgramFromBuf("foo Foo foo")
+	p.processKeys("/Foo\n")
+	p.assertLogicalPos(t, 4, 0)
+
+	p = testingProgramFromBuf("x Foo foo")
+	p.settings.ignorecase = true
+	p.processKeys("/foo\n")
+	p.assertLogicalPos(t, 2, 0)
+
+	// With smartcase, uppercase letters make the search match case
+	p = testingProgramFromBuf("x foo Foo")
+	p.settings.ignorecase = true
+	p.settings.smartcase = true
+	p.processKeys("/Foo\n")
+	p.assertLogicalPos(t, 6, 0)
+
+	// `\c` and `\C` override the settings
+	p = testingProgramFromBuf("x Foo foo")
+	p.processKeys(`/foo\c` + "\n")
+	p.assertLogicalPos(t, 2, 0)
+}
+
+func TestSearchIncremental(t *testing.T) {
+	p := testingProgramFromBuf("foo\nbar\nbaz")
+	p.processKeys("/ba")
+	p.assertLogicalPos(t, 0, 1)
+
+	p.processKeys("z")
+	p.assertLogicalPos(t, 0, 2)
+
+	spans := p.searchMatchColumnsFunc()(2)
+	if len(spans) != 1 || spans[0] != (ColumnSpan{0, 3}) {
+		t.Errorf("wanted baz to be highlighted, got %v", spans)
+	}
+
+	// Leaving the prompt puts the cursor back
+	p.processKeys("\x1b")
+	p.assertLogicalPos(t, 0, 0)
+	if p.state.search.pattern != "" {
+		t.Errorf("wanted a cancelled search not to be remembered")
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("/bar\n/baz\n:set ts=2\n0/")
+	p.processInputs(RuneUpArrow, RuneUpArrow, '\n')
+	p.assertLogicalPos(t, 4, 0)
+
+	if len(p.state.commandHistory) != 1 || len(p.state.searchHistory) != 2 {
+		t.Errorf("wanted searches and commands in separate histories")
+	}
+
+	// An empty pattern searches for the last one again
+	p.processKeys("0/\n")
+	p.assertLogicalPos(t, 4, 0)
+}
+
+func TestSearchWordUnderCursor(t *testing.T) {
+	p := testingProgramFromBuf("foo.bar foobar bar")
+	p.processKeys("fa*")
+	p.assertLogicalPos(t, 15, 0)
+
+	p.processKeys("#")
+	p.assertLogicalPos(t, 4, 0)
+
+	p.processKeys("n")
+	p.assertLogicalPos(t, 15, 0)
+}
+
+func TestSearchWithOperator(t *testing.T) {
+	p := testingProgramFromBuf("foo bar baz")
+	p.processKeys("/baz\nggdn")
+	p.assertBufferContent(t, "baz")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Describing a setting that can be changed with `:set`. Exactly
+// one of the accessors is expected to be non-nil.
+type SettingDef struct {
+	name     string
+	short    string
+	boolean  func(s *Settings) *bool
+	integer  func(s *Settings) *int
+	text     func(s *Settings) *string
+	validate func(value string) error
+}
+
+var SettingsTable = []SettingDef{
+	{
+		name:    "tabstop",
+		short:   "ts",
+		integer: func(s *Settings) *int { return &s.tabstop },
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				return fmt.Errorf("Argument must be positive: tabstop=%s", value)
+			}
+			return nil
+		},
+	},
+	{
+		name: "tabchar",
+		text: func(s *Settings) *string { return &s.tabchar },
+		validate: func(value string) error {
+			if len([]rune(value)) != 1 {
+				return fmt.Errorf("Argument must be a single character: tabchar=%s", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:    "shiftwidth",
+		short:   "sw",
+		integer: func(s *Settings) *int { return &s.shiftwidth },
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				return fmt.Errorf("Argument must be positive: shiftwidth=%s", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:    "expandtab",
+		short:   "et",
+		boolean: func(s *Settings) *bool { return &s.expandtab },
+	},
+	{
+		name:    "cursorxoverflow",
+		short:   "cxo",
+		boolean: func(s *Settings) *bool { return &s.cursor_x_overflow },
+	},
+	{
+		name:    "tabnamesfull",
+		short:   "tnf",
+		boolean: func(s *Settings) *bool { return &s.tabNamesUseFullFileName },
+	},
+	{
+		name:    "yankdeletes",
+		short:   "yd",
+		boolean: func(s *Settings) *bool { return &s.yankdeletes },
+	},
+	{
+		name:  "clipboardprovider",
+		short: "cbp",
+		text:  func(s *Settings) *string { return &s.clipboardprovider },
+		validate: func(value string) error {
+			if _, ok := clipboardTools[value]; ok || value == "auto" || value == "osc52" || value == "memory" {
+				return nil
+			}
+			return fmt.Errorf("Invalid argument: clipboardprovider=%s", value)
+		},
+	},
+	{
+		name:    "unnamedclipboard",
+		short:   "ucb",
+		boolean: func(s *Settings) *bool { return &s.unnamedclipboard },
+	},
+	{
+		name:    "ignorecase",
+		short:   "ic",
+		boolean: func(s *Settings) *bool { return &s.ignorecase },
+	},
+	{
+		name:    "smartcase",
+		short:   "scs",
+		boolean: func(s *Settings) *bool { return &s.smartcase },
+	},
+	{
+		name:    "undofile",
+		short:   "udf",
+		boolean: func(s *Settings) *bool { return &s.undofile },
+	},
+	{
+		name:  "undodir",
+		short: "udir",
+		text:  func(s *Settings) *string { return &s.undodir },
+	},
+	{
+		name:    "syncoutput",
+		short:   "sync",
+		boolean: func(s *Settings) *bool { return &s.syncoutput },
+	},
+}
+
+func lookupSetting(name string) (*SettingDef, bool) {
+	for i := range SettingsTable {
+		def := &SettingsTable[i]
+		if def.name == name || (def.short != "" && def.short == name) {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+func (def *SettingDef) format(s *Settings) string {
+	switch {
+	case def.boolean != nil:
+		if *def.boolean(s) {
+			return def.name
+		}
+		return "no" + def.name
+	case def.integer != nil:
+		return fmt.Sprintf("%s=%d", def.name, *def.integer(s))
+	default:
+		return fmt.Sprintf("%s=%s", def.name, *def.text(s))
+	}
+}
+
+// Applying one `:set` argument, like `ts=8`, `nocxo`, `cxo!` or `ts?`.
+// Returning a message to show, when the argument is a query.
+func applySetting(s *Settings, arg string) (string, error) {
+	name, value, hasValue := strings.Cut(arg, "=")
+
+	if strings.HasSuffix(name, "?") {
+		def, ok := lookupSetting(strings.TrimSuffix(name, "?"))
+		if !ok {
+			return "", fmt.Errorf("Unknown option: %s", arg)
+		}
+		return def.format(s), nil
+	}
+
+	if hasValue {
+		def, ok := lookupSetting(name)
+		if !ok {
+			return "", fmt.Errorf("Unknown option: %s", name)
+		}
+
+		if def.validate != nil {
+			if err := def.validate(value); err != nil {
+				return "", err
+			}
+		}
+
+		switch {
+		case def.integer != nil:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return "", fmt.Errorf("Number required after =: %s", arg)
+			}
+			*def.integer(s) = n
+		case def.text != nil:
+			*def.text(s) = value
+		default:
+			return "", fmt.Errorf("Invalid argument: %s", arg)
+		}
+		return "", nil
+	}
+
+	// Handling boolean forms: `name`, `noname`, and `name!`
+	toggle := strings.HasSuffix(name, "!")
+	name = strings.TrimSuffix(name, "!")
+	enable := true
+
+	def, ok := lookupSetting(name)
+	if !ok && strings.HasPrefix(name, "no") {
+		def, ok = lookupSetting(strings.TrimPrefix(name, "no"))
+		enable = false
+	}
+
+	if !ok {
+		return "", fmt.Errorf("Unknown option: %s", arg)
+	}
+
+	// Showing the value of non-boolean settings given without a value
+	if def.boolean == nil {
+		if !enable || toggle {
+			return "", fmt.Errorf("Invalid argument: %s", arg)
+		}
+		return def.format(s), nil
+	}
+
+	if toggle {
+		enable = !*def.boolean(s)
+	}
+	*def.boolean(s) = enable
+
+	return "", nil
+}
+
+func exSet[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	messages := []string{}
+
+	for _, arg := range splitExArgs(cmd.arg) {
+		message, err := applySetting(&prog.settings, arg)
+		if err != nil {
+			return err
+		}
+		if message != "" {
+			messages = append(messages, message)
+		}
+	}
+
+	if len(messages) > 0 {
+		prog.setStatus("%s", strings.Join(messages, "  "))
+	}
+
+	prog.state.needsRedraw = true
+	return nil
+}
+
+func completeSettingName(prefix string) []string {
+	candidates := []string{}
+
+	for _, def := range SettingsTable {
+		if strings.HasPrefix(def.name, prefix) {
+			candidates = append(candidates, def.name)
+		}
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
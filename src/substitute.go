@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A `:substitute` that is going through the lines of its range. With the
+// `c` flag it stops at each match in ConfirmMode, to ask what to do.
+type Substitution struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+	confirm     bool
+
+	// The line being searched, and the last line of the range,
+	// which moves when replacements add line breaks
+	y       int
+	endLine int
+
+	// The matches on the line that are left, found all at once before
+	// any were replaced, and how far replacing the earlier ones has
+	// moved them, since a match can't start inside a replacement
+	pending [][]int
+	shift   int
+
+	// The match being asked about, as byte offsets of its submatches
+	match []int
+
+	substitutions int
+	changedLines  int
+	lastChanged   int
+	anyMatched    bool
+}
+
+// The last pattern and replacement, which a bare `:s` uses again
+type SubstituteState struct {
+	pattern     string
+	replacement string
+
+	// What the substitutions run by `:global` add up to,
+	// which is reported once at the end, instead of for each line
+	globalTotal SubstituteTotal
+}
+
+type SubstituteTotal struct {
+	ran           bool
+	substitutions int
+	changedLines  int
+}
+
+// Reading the delimiter that starts an argument like `/pattern/`,
+// which is whatever char comes first, and returning the rest
+func readDelimiter(arg string) (rune, string, error) {
+	delim, size := utf8.DecodeRuneInString(arg)
+	if delim == '\\' || delim == '"' || delim == '|' || unicode.IsLetter(delim) || unicode.IsDigit(delim) {
+		return 0, "", fmt.Errorf("Invalid delimiter: %c", delim)
+	}
+	return delim, arg[size:], nil
+}
+
+// Reading up to the next delimiter, and returning the text after it.
+// Escaped delimiters become plain delimiters.
+func readDelimited(text string, delim rune) (string, string) {
+	var sb strings.Builder
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == delim:
+			sb.WriteRune(delim)
+			i++
+		case runes[i] == '\\' && i+1 < len(runes):
+			sb.WriteRune(runes[i])
+			sb.WriteRune(runes[i+1])
+			i++
+		case runes[i] == delim:
+			return sb.String(), string(runes[i+1:])
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+
+	return sb.String(), ""
+}
+
+// Splitting `/pattern/replacement/flags` on its delimiter
+func parseSubstituteArg(arg string) (string, string, string, error) {
+	delim, body, err := readDelimiter(arg)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	pattern, rest := readDelimited(body, delim)
+	replacement, flags := readDelimited(rest, delim)
+
+	flags = strings.TrimSpace(flags)
+	for _, flag := range flags {
+		if !strings.ContainsRune("gciI", flag) {
+			return "", "", "", fmt.Errorf("Trailing characters: %s", flags)
+		}
+	}
+
+	return pattern, replacement, flags, nil
+}
+
+// Building the text that replaces a match. `&` and `\0` are the whole
+// match, `\1` to `\9` are its groups, `\r` and `\n` are line breaks,
+// `\u` and `\l` change the case of the next char, and `\U` and `\L`
+// change the case of everything up to `\E` or `\e`.
+func expandReplacement(replacement string, line string, match []int) string {
+	group := func(n int) string {
+		if 2*n+1 >= len(match) || match[2*n] < 0 {
+			return ""
+		}
+		return line[match[2*n]:match[2*n+1]]
+	}
+
+	var sb strings.Builder
+	var nextCase, rangeCase rune
+
+	write := func(text string) {
+		for _, r := range text {
+			switch {
+			case nextCase == 'u':
+				r = unicode.ToUpper(r)
+			case nextCase == 'l':
+				r = unicode.ToLower(r)
+			case rangeCase == 'U':
+				r = unicode.ToUpper(r)
+			case rangeCase == 'L':
+				r = unicode.ToLower(r)
+			}
+			nextCase = 0
+			sb.WriteRune(r)
+		}
+	}
+
+	runes := []rune(replacement)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '&' {
+			write(group(0))
+			continue
+		}
+		if r != '\\' || i+1 == len(runes) {
+			write(string(r))
+			continue
+		}
+
+		i++
+		switch next := runes[i]; {
+		case '0' <= next && next <= '9':
+			write(group(int(next - '0')))
+		case next == 'u' || next == 'l':
+			nextCase = next
+		case next == 'U' || next == 'L':
+			rangeCase = next
+		case next == 'E' || next == 'e':
+			rangeCase = 0
+		case next == 'r' || next == 'n':
+			sb.WriteRune('\n')
+		case next == 't':
+			sb.WriteRune('\t')
+		default:
+			write(string(next))
+		}
+	}
+
+	return sb.String()
+}
+
+func exSubstitute[T Terminal](prog *Program[T], cmd *ExCommandLine) error {
+	last := &prog.state.substitute
+	pattern, replacement, flags := last.pattern, last.replacement, ""
+
+	if cmd.arg != "" {
+		var err error
+		if pattern, replacement, flags, err = parseSubstituteArg(cmd.arg); err != nil {
+			return err
+		}
+	}
+
+	// An empty pattern is the last search
+	if pattern == "" {
+		pattern = prog.state.search.pattern
+	}
+	if pattern == "" {
+		return fmt.Errorf("No previous regular expression")
+	}
+
+	last.pattern = pattern
+	last.replacement = replacement
+	prog.state.search.pattern = pattern
+	prog.state.search.forward = true
+
+	// The `i` and `I` flags are the same as `\c` and `\C` in the pattern
+	casePattern := pattern
+	if strings.ContainsRune(flags, 'i') {
+		casePattern += `\c`
+	}
+	if strings.ContainsRune(flags, 'I') {
+		casePattern += `\C`
+	}
+
+	re, err := compilePattern(casePattern, &prog.settings, true)
+	if err != nil {
+		return err
+	}
+
+	confirm := strings.ContainsRune(flags, 'c')
+	if confirm && prog.state.runningGlobal {
+		return fmt.Errorf("Cannot confirm substitutions inside :global")
+	}
+
+	prog.state.substitution = &Substitution{
+		re:          re,
+		replacement: replacement,
+		global:      strings.ContainsRune(flags, 'g'),
+		confirm:     confirm,
+		y:           cmd.startLine,
+		endLine:     cmd.endLine,
+		lastChanged: -1,
+	}
+	prog.continueSubstitution()
+	return nil
+}
+
+// Finding the next match in the range, starting from the last one
+func (sub *Substitution) findMatch(buffer *Buffer) bool {
+	for ; sub.y <= sub.endLine; sub.y++ {
+		if len(sub.pending) == 0 {
+			sub.pending = sub.re.FindAllStringSubmatchIndex(buffer.lineContent(sub.y), -1)
+			sub.shift = 0
+			if !sub.global {
+				sub.pending = sub.pending[:min(len(sub.pending), 1)]
+			}
+		}
+
+		if len(sub.pending) > 0 {
+			sub.match = make([]int, len(sub.pending[0]))
+			for i, idx := range sub.pending[0] {
+				sub.match[i] = idx
+				if idx >= 0 {
+					sub.match[i] += sub.shift
+				}
+			}
+			sub.anyMatched = true
+			return true
+		}
+	}
+	return false
+}
+
+// Moving past the current match, to the next match on its line
+// if the substitution is global, or else to the next line
+func (sub *Substitution) skipMatch() {
+	sub.pending = sub.pending[1:]
+	if len(sub.pending) == 0 {
+		sub.y++
+	}
+}
+
+// Replacing the current match, splitting the line if the
+// replacement has line breaks
+func (prog *Program[T]) replaceMatch() {
+	sub := prog.state.substitution
+	buffer := prog.getActiveBuffer()
+	line := buffer.lineContent(sub.y)
+	match := sub.match
+
+	if sub.lastChanged != sub.y {
+		sub.changedLines++
+	}
+	sub.substitutions++
+
+	rest := line[match[1]:]
+	replaced := line[:match[0]] + expandReplacement(sub.replacement, line, match) + rest
+	parts := strings.Split(replaced, "\n")
+
+	buffer.updateLine(sub.y, parts[0])
+	for i, part := range parts[1:] {
+		buffer.insertLine(sub.y+1+i, part)
+	}
+	sub.y += len(parts) - 1
+	sub.endLine += len(parts) - 1
+	sub.lastChanged = sub.y
+
+	// The rest of the line, with the matches left in it,
+	// now starts where the replacement ends
+	end := len(parts[len(parts)-1]) - len(rest)
+	sub.shift = end - sub.pending[0][1]
+	sub.skipMatch()
+}
+
+// Replacing matches until one needs to be confirmed, or there are no more
+func (prog *Program[T]) continueSubstitution() {
+	sub := prog.state.substitution
+	buffer := prog.getActiveBuffer()
+
+	for sub.findMatch(buffer) {
+		if sub.confirm {
+			line := buffer.lineContent(sub.y)
+			prog.setLogicalCursorPosition(utf8.RuneCountInString(line[:sub.match[0]]), sub.y)
+			prog.scrollToCursor()
+			prog.changeMode(ConfirmMode)
+			prog.setStatus("replace with %s (y/n/a/q/l)?", sub.replacement)
+			return
+		}
+		prog.replaceMatch()
+	}
+
+	prog.finishSubstitution()
+}
+
+func (prog *Program[T]) finishSubstitution() {
+	sub := prog.state.substitution
+	prog.state.substitution = nil
+	prog.changeMode(NormalMode)
+
+	if sub.substitutions > 0 {
+		prog.moveToFirstNonBlank(sub.lastChanged)
+	}
+
+	// Inside `:global`, lines without a match are quietly left alone,
+	// and the counts are added up for runGlobal to report
+	if prog.state.runningGlobal {
+		total := &prog.state.substitute.globalTotal
+		total.ran = true
+		total.substitutions += sub.substitutions
+		total.changedLines += sub.changedLines
+		return
+	}
+	if !sub.anyMatched {
+		prog.setError(fmt.Errorf("Pattern not found: %s", prog.state.substitute.pattern))
+		return
+	}
+
+	prog.reportSubstitutions(sub.substitutions, sub.changedLines)
+}
+
+func (prog *Program[T]) reportSubstitutions(substitutions, changedLines int) {
+	prog.setStatus("%d %s on %d %s",
+		substitutions, plural(substitutions, "substitution"),
+		changedLines, plural(changedLines, "line"))
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// Answering whether to replace the highlighted match
+func confirmMode[T Terminal](input rune, prog *Program[T]) {
+	sub := prog.state.substitution
+
+	switch input {
+	case 'y':
+		prog.replaceMatch()
+	case 'n':
+		sub.skipMatch()
+	case 'a':
+		sub.confirm = false
+		prog.replaceMatch()
+	case 'l':
+		prog.replaceMatch()
+		prog.finishSubstitution()
+		return
+	case 'q', RuneEscape:
+		prog.finishSubstitution()
+		return
+	default:
+		return
+	}
+
+	prog.continueSubstitution()
+}
+
+// Returning the visual columns of the match being confirmed, if it's on line y
+func (prog *Program[T]) confirmMatchColumns(y int) []ColumnSpan {
+	sub := prog.state.substitution
+	if prog.state.currentMode != ConfirmMode || sub == nil || sub.y != y {
+		return nil
+	}
+
+	line := prog.getActiveBuffer().lineContent(y)
+	from := utf8.RuneCountInString(line[:sub.match[0]])
+	to := from + max(utf8.RuneCountInString(line[sub.match[0]:sub.match[1]]), 1)
+	return []ColumnSpan{{
+		from: getVisualX(line, from, &prog.settings),
+		to:   getVisualX(line, to, &prog.settings),
+	}}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"foo foo\nfoo", ":s/foo/bar\n", "bar foo\nfoo"},
+		{"foo foo\nfoo", ":s/foo/bar/g\n", "bar bar\nfoo"},
+		{"foo foo\nfoo", ":%s/foo/bar/g\n", "bar bar\nbar"},
+		{"a\nfoo\nfoo\nfoo", ":2,3s/foo/x/\n", "a\nx\nx\nfoo"},
+		{"a/b", `:s/\//-/` + "\n", "a-b"},
+		{"a/b", ":s#/#-#\n", "a-b"},
+		{"abc", ":s/b//\n", "ac"},
+		{"abc", ":s/x*/-/g\n", "-a-b-c-"},
+		{"abc", ":s/^/# /\n", "# abc"},
+
+		// Matches are found before any are replaced, so
+		// they don't line up differently as the line shrinks
+		{"aaaa", ":s/aa/a/g\n", "aa"},
+		{"abab", ":s/ab/b/g\n", "bb"},
+		{"a a", ":s/^a/b/g\n", "b a"},
+		{"xay\nxa", ":%s/a/\\r/g\n", "x\ny\nx\n"},
+	})
+}
+
+func TestSubstituteReplacement(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"john smith", `:s/(\w+) (\w+)/\2, \1/` + "\n", "smith, john"},
+		{"foo", ":s/o/<&>/g\n", "f<o><o>"},
+		{"foo", `:s/o/\&/` + "\n", "f&o"},
+		{"foo bar", `:s/\w+/\u&/g` + "\n", "Foo Bar"},
+		{"foo bar", `:s/foo/\U&\E!/` + "\n", "FOO! bar"},
+		{"FOO bar", `:s/\w+/\L\u&/` + "\n", "Foo bar"},
+		{"a,b,c", `:s/,/\r/g` + "\n", "a\nb\nc"},
+	})
+}
+
+func TestSubstituteCase(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"Foo foo", ":s/foo/x/\n", "Foo x"},
+		{"Foo foo", ":s/foo/x/i\n", "x foo"},
+		{"Foo foo", `:s/foo\c/x/` + "\n", "x foo"},
+	})
+}
+
+func TestSubstituteReport(t *testing.T) {
+	p := testingProgramFromBuf("a a\nb\na")
+	p.processKeys(":%s/a/x/g\n")
+	if p.state.statusMessage != "3 substitutions on 2 lines" {
+		t.Errorf("wanted a count of substitutions, got %q", p.state.statusMessage)
+	}
+	p.assertLogicalPos(t, 0, 2)
+
+	p = testingProgramFromBuf("a")
+	p.processKeys(":s/a/x/\n")
+	if p.state.statusMessage != "1 substitution on 1 line" {
+		t.Errorf("wanted a count of substitutions, got %q", p.state.statusMessage)
+	}
+
+	p = testingProgramFromBuf("a")
+	p.processKeys(":s/z/x/\n")
+	if p.state.statusMessage != "Pattern not found: z" || !p.state.statusIsError {
+		t.Errorf("wanted a not found error, got %q", p.state.statusMessage)
+	}
+}
+
+func TestSubstituteIsOneUndoStep(t *testing.T) {
+	p := testingProgramFromBuf("a\na\na")
+	p.processKeys(":%s/a/b\nu")
+	p.assertBufferContent(t, "a", "a", "a")
+}
+
+func TestSubstituteRepeatsLast(t *testing.T) {
+	p := testingProgramFromBuf("a a\na a")
+	p.processKeys(":s/a/b/\nj:s\n")
+	p.assertBufferContent(t, "b a", "b a")
+
+	// An empty pattern uses the last search
+	p = testingProgramFromBuf("foo bar")
+	p.processKeys("/bar\n:s//baz/\n")
+	p.assertBufferContent(t, "foo baz")
+}
+
+func TestSubstituteErrors(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys(":s/a/b/z\n")
+	p.assertBufferContent(t, "abc")
+	if !p.state.statusIsError {
+		t.Errorf("wanted an error for an unknown flag")
+	}
+}
+
+func TestSubstituteConfirm(t *testing.T) {
+	p := testingProgramFromBuf("a a a\na")
+	p.processKeys(":%s/a/b/gc\n")
+	if p.state.currentMode != ConfirmMode {
+		t.Fatalf("wanted to be asked about the first match")
+	}
+	p.assertLogicalPos(t, 0, 0)
+
+	spans := p.searchMatchColumnsFunc()(0)
+	if len(spans) != 1 || spans[0] != (ColumnSpan{0, 1}) {
+		t.Errorf("wanted the first match highlighted, got %v", spans)
+	}
+
+	p.processKeys("yn")
+	p.assertLogicalPos(t, 4, 0)
+	p.processKeys("y")
+	p.assertLogicalPos(t, 0, 1)
+	p.processKeys("q")
+	p.assertBufferContent(t, "b a b", "a")
+	if p.state.currentMode != NormalMode || p.state.statusMessage != "2 substitutions on 1 line" {
+		t.Errorf("wanted to finish with a count, got %q", p.state.statusMessage)
+	}
+
+	// The whole confirmed substitution is undone at once
+	p.processKeys("u")
+	p.assertBufferContent(t, "a a a", "a")
+}
+
+func TestSubstituteConfirmAllAndLast(t *testing.T) {
+	p := testingProgramFromBuf("a a\na a")
+	p.processKeys(":%s/a/b/gc\nna")
+	p.assertBufferContent(t, "a b", "b b")
+
+	p = testingProgramFromBuf("a a\na a")
+	p.processKeys(":%s/a/b/gc\nnl")
+	p.assertBufferContent(t, "a b", "a a")
+	if p.state.currentMode != NormalMode {
+		t.Errorf("wanted l to finish the substitution")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	xterm "golang.org/x/term"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -14,30 +16,181 @@ type Terminal interface {
 	getSize() (rows, cols int, err error)
 	useBarCursor()
 	useBlockCursor()
+	useUnderlineCursor()
+	startHighlight()
+	endHighlight()
+	writeSequence(sequence string)
 	printf(s string, args ...interface{}) // TODO maybe return errors
+
+	// Asking the terminal to show a frame all at once, rather than
+	// as it's drawn, and sending whatever output is still held back
+	beginSynchronizedUpdate()
+	endSynchronizedUpdate()
+	flush()
+}
+
+// The shapes that the terminal's cursor can be drawn in
+type CursorShape int
+
+const (
+	BlockCursor CursorShape = iota
+	BarCursor
+	UnderlineCursor
+)
+
+var cursorShapeNames = map[CursorShape]string{
+	BlockCursor:     "block",
+	BarCursor:       "bar",
+	UnderlineCursor: "underline",
 }
 
+// A virtual terminal for tests, which draws into a grid of cells
+// in memory. The grid is behind a pointer, so that copies of the
+// terminal, like the ones the value receivers get, all draw on it.
 type MockTerminal struct {
+	*MockScreen
+
+	// The escape sequences sent with writeSequence, for asserting against
+	sequences *[]string
+}
+
+type MockScreen struct {
+	rows  int
+	cols  int
+	cells [][]rune
+
+	// Which cells were drawn while highlighting was on
+	highlighted [][]bool
+	highlight   bool
+
 	cursorX     int
 	cursorY     int
-	isBarCursor bool
+	cursorShape CursorShape
+}
+
+func newMockTerminal(rows, cols int) MockTerminal {
+	screen := &MockScreen{}
+	screen.resize(rows, cols)
+	return MockTerminal{MockScreen: screen, sequences: &[]string{}}
+}
+
+// Changing the size of the grid, which is left blank like a
+// terminal that doesn't keep its content when resized
+func (s *MockScreen) resize(rows, cols int) {
+	s.rows, s.cols = rows, cols
+	s.cells = make([][]rune, rows)
+	s.highlighted = make([][]bool, rows)
+	for y := range s.cells {
+		s.cells[y] = make([]rune, cols)
+		s.highlighted[y] = make([]bool, cols)
+	}
+	s.clear()
+	s.cursorX = min(s.cursorX, max(cols-1, 0))
+	s.cursorY = min(s.cursorY, max(rows-1, 0))
+}
+
+func (s *MockScreen) clear() {
+	for y := range s.cells {
+		for x := range s.cells[y] {
+			s.cells[y][x] = ' '
+			s.highlighted[y][x] = false
+		}
+	}
 }
 
-func (t MockTerminal) printf(s string, args ...interface{}) {}
+// Showing the grid as text, one line per row, without
+// the blanks at the end of each row and of the screen
+func (s *MockScreen) text() string {
+	lines := make([]string, s.rows)
+	for y, row := range s.cells {
+		lines[y] = strings.TrimRight(strings.ReplaceAll(string(row), "\x00", ""), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// Listing the highlighted cells of each row that has any,
+// as runs of columns, like `3: 5-9, 12-12`
+func (s *MockScreen) highlights() []string {
+	rows := []string{}
+	for y, row := range s.highlighted {
+		runs := []string{}
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x+1 < len(row) && row[x+1] {
+				x++
+			}
+			runs = append(runs, fmt.Sprintf("%d-%d", start, x))
+		}
+		if len(runs) > 0 {
+			rows = append(rows, fmt.Sprintf("%d: %s", y, strings.Join(runs, ", ")))
+		}
+	}
+	return rows
+}
+
+// Drawing text at the cursor, which moves along with it.
+// Text past the right edge is lost rather than wrapped.
+func (t MockTerminal) printf(s string, args ...interface{}) {
+	for _, r := range fmt.Sprintf(s, args...) {
+		switch {
+		case r == '\r':
+			t.cursorX = 0
+		case r == '\n':
+			t.cursorY = min(t.cursorY+1, t.rows-1)
+		case t.cursorX+charWidth(r) <= t.cols && t.cursorY < t.rows:
+			// The cells a wide rune covers are left holding 0
+			for i := 0; i < charWidth(r); i++ {
+				t.cells[t.cursorY][t.cursorX+i] = 0
+				t.highlighted[t.cursorY][t.cursorX+i] = t.highlight
+			}
+			t.cells[t.cursorY][t.cursorX] = r
+			t.cursorX += charWidth(r)
+		}
+	}
+}
 
-func (t MockTerminal) clearScreen() {}
+func (t MockTerminal) clearScreen() {
+	t.clear()
+}
 
 func (t MockTerminal) useBarCursor() {
-	t.isBarCursor = true
+	t.cursorShape = BarCursor
 }
 
 func (t MockTerminal) useBlockCursor() {
-	t.isBarCursor = false
+	t.cursorShape = BlockCursor
+}
+
+func (t MockTerminal) useUnderlineCursor() {
+	t.cursorShape = UnderlineCursor
+}
+
+func (t MockTerminal) startHighlight() {
+	t.highlight = true
+}
+
+func (t MockTerminal) endHighlight() {
+	t.highlight = false
+}
+
+func (t MockTerminal) beginSynchronizedUpdate() {}
+
+func (t MockTerminal) endSynchronizedUpdate() {}
+
+func (t MockTerminal) flush() {}
+
+func (t MockTerminal) writeSequence(sequence string) {
+	if t.sequences != nil {
+		*t.sequences = append(*t.sequences, sequence)
+	}
 }
 
 func (t MockTerminal) setCursorPosition(x, y int) {
-	t.cursorX = x
-	t.cursorY = y
+	t.cursorX = max(min(x, t.cols-1), 0)
+	t.cursorY = max(min(y, t.rows-1), 0)
 }
 
 func (t MockTerminal) getCursorPosition() (x, y int, err error) {
@@ -45,38 +198,101 @@ func (t MockTerminal) getCursorPosition() (x, y int, err error) {
 }
 
 func (t MockTerminal) getSize() (rows, cols int, err error) {
-	return 100, 100, nil
+	return t.rows, t.cols, nil
+}
+
+type ANSI struct {
+	// Where output goes, which is stdout unless set
+	out io.Writer
 }
 
-type ANSI struct{}
+func (t ANSI) writer() io.Writer {
+	if t.out == nil {
+		return os.Stdout
+	}
+	return t.out
+}
 
-func (ANSI) printf(s string, args ...interface{}) {
-	fmt.Printf(s, args...)
+func (t ANSI) printf(s string, args ...interface{}) {
+	fmt.Fprintf(t.writer(), s, args...)
 }
 
-func (ANSI) clearScreen() {
-	fmt.Printf("\x1b[2J")
+func (t ANSI) clearScreen() {
+	fmt.Fprintf(t.writer(), "\x1b[2J")
 }
 
-func (ANSI) useBarCursor() {
+func (t ANSI) useBarCursor() {
 	// On MacOS, this escape code is the blinking bar.
 	// On other systems, it is "\x1b[6 q".
-	fmt.Printf("\x1b[5 q")
+	fmt.Fprintf(t.writer(), "\x1b[5 q")
+}
+
+func (t ANSI) useBlockCursor() {
+	fmt.Fprintf(t.writer(), "\x1b[2 q")
 }
 
-func (ANSI) useBlockCursor() {
-	fmt.Printf("\x1b[2 q")
+func (t ANSI) useUnderlineCursor() {
+	fmt.Fprintf(t.writer(), "\x1b[4 q")
 }
 
-func (ANSI) setCursorPosition(x, y int) {
+// Highlighting with reverse video, which works with any color scheme
+func (t ANSI) startHighlight() {
+	fmt.Fprintf(t.writer(), "\x1b[7m")
+}
+
+func (t ANSI) endHighlight() {
+	fmt.Fprintf(t.writer(), "\x1b[27m")
+}
+
+func (t ANSI) writeSequence(sequence string) {
+	fmt.Fprint(t.writer(), sequence)
+}
+
+// Synchronized output (DEC mode 2026) keeps the terminal from showing
+// a frame half drawn. Terminals without it should ignore these sequences,
+// but not all of them do, so they're only sent once it's known to be there.
+const synchronizedOutputMode = 2026
+
+func (t ANSI) beginSynchronizedUpdate() {
+	fmt.Fprint(t.writer(), "\x1b[?2026h")
+}
+
+func (t ANSI) endSynchronizedUpdate() {
+	fmt.Fprint(t.writer(), "\x1b[?2026l")
+}
+
+// Asking the terminal to send pastes and mouse events as sequences,
+// which StdinIterator decodes, rather than as typed keys
+func (t ANSI) enableMouseAndPaste() {
+	fmt.Fprint(t.writer(), "\x1b[?2004h\x1b[?1000h\x1b[?1006h")
+}
+
+func (t ANSI) disableMouseAndPaste() {
+	fmt.Fprint(t.writer(), "\x1b[?1006l\x1b[?1000l\x1b[?2004l")
+}
+
+// Asking whether the terminal supports a DEC private mode (DECRQM).
+// The answer arrives as input, which is read as a ModeReportEvent.
+func (t ANSI) requestModeReport(mode int) {
+	fmt.Fprintf(t.writer(), "\x1b[?%d$p", mode)
+}
+
+func (t ANSI) flush() {
+	if f, ok := t.out.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+}
+
+func (t ANSI) setCursorPosition(x, y int) {
 	// Incrementing the given values, because ANSI row/col positions
 	// seem to be 1-indexed instead of 0-indexed
-	fmt.Printf("\033[%d;%dH", y+1, x+1)
+	fmt.Fprintf(t.writer(), "\033[%d;%dH", y+1, x+1)
 }
 
-func (ANSI) getCursorPosition() (x, y int, err error) {
+func (t ANSI) getCursorPosition() (x, y int, err error) {
 	// Querying the terminal for cursor position
-	fmt.Print("\033[6n")
+	fmt.Fprint(t.writer(), "\033[6n")
+	t.flush()
 
 	// Reading the response
 	var response []byte
@@ -114,23 +330,12 @@ func (ANSI) getCursorPosition() (x, y int, err error) {
 	return rows, cols, nil
 }
 
-func (ANSI) getSize() (rows, cols int, err error) {
-	last_x, last_y, err := ANSI{}.getCursorPosition()
-
+// Asking the kernel for the terminal's size, rather than the terminal
+// itself, whose reply would arrive on stdin among the typed keys
+func (t ANSI) getSize() (rows, cols int, err error) {
+	cols, rows, err = xterm.GetSize(int(os.Stdout.Fd()))
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("failed to get the terminal size: %v", err)
 	}
-
-	// Moving cursor to bottom-right
-	ANSI{}.setCursorPosition(9999, 9999)
-
-	w, h, err := ANSI{}.getCursorPosition()
-
-	if err != nil {
-		return 0, 0, err
-	}
-
-	ANSI{}.setCursorPosition(last_x, last_y)
-
-	return w, h, nil
+	return rows, cols, nil
 }
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
 )
 
+// Running the tests with -update writes what's on
+// screen to the golden files, instead of comparing
+var updateGolden = flag.Bool("update", false, "update golden files")
+
 func (prog *Program[MockTerminal]) assertBufferContent(t *testing.T, expected ...string) {
 	actual := prog.getActiveBuffer().lines
 
@@ -18,6 +26,28 @@ func (prog *Program[MockTerminal]) assertBufferContent(t *testing.T, expected ..
 	}
 }
 
+// Typing keys into a buffer, and checking what the buffer holds after
+type bufferTest struct {
+	buf  string
+	keys string
+	want string
+}
+
+func runBufferTests(t *testing.T, tests []bufferTest) {
+	for _, test := range tests {
+		p := testingProgramFromBuf(test.buf)
+		p.processKeys(test.keys)
+
+		lines := []string{}
+		for _, line := range p.getActiveBuffer().lines {
+			lines = append(lines, line.content)
+		}
+		if got := strings.Join(lines, "\n"); got != test.want {
+			t.Errorf("%q with keys %q: wanted %q; got %q", test.buf, test.keys, test.want, got)
+		}
+	}
+}
+
 func failWithStackTrace(t *testing.T, format string, args ...interface{}) {
 	stackBuf := make([]byte, 1024)
 	stackSize := runtime.Stack(stackBuf, false)
@@ -30,8 +60,35 @@ func failWithStackTrace(t *testing.T, format string, args ...interface{}) {
 }
 
 func (p *Program[MockTerminal]) processInputs(i ...rune) {
-	it := NewStaticInputIterator(i)
-	runMainLoop(p, it)
+	runMainLoop(p, newEventLoop(InputProducer{it: NewStaticInputIterator(i)}))
+}
+
+// Handling events in order, the way processInputs handles keys,
+// for events that don't come from the keyboard
+func (p *Program[MockTerminal]) processEvents(events ...Event) {
+	runMainLoop(p, newEventLoop(staticEventProducer{events: events}))
+}
+
+// Sending a fixed list of events, and then ending the input
+type staticEventProducer struct {
+	events []Event
+}
+
+func (p staticEventProducer) run(loop *EventLoop) {
+	for _, e := range p.events {
+		if !loop.send(e) {
+			return
+		}
+	}
+	loop.send(InputClosedEvent{})
+}
+
+func (staticEventProducer) stop() {}
+
+// Processing each rune of a string as a separate input,
+// which reads better than long lists of rune literals
+func (p *Program[MockTerminal]) processKeys(keys string) {
+	p.processInputs([]rune(keys)...)
 }
 
 func (p *Program[MockTerminal]) assertLogicalPos(
@@ -47,7 +104,55 @@ func (p *Program[MockTerminal]) assertLogicalPos(
 	}
 }
 
+// Comparing what's on the terminal against testdata/screens/<golden>.txt,
+// which holds the screen's text, followed by where the cursor is,
+// and which columns of each row are highlighted
+func (p *Program[T]) assertScreen(t *testing.T, golden string) {
+	t.Helper()
+
+	term, ok := any(p.term).(MockTerminal)
+	if !ok {
+		t.Fatalf("assertScreen needs a program with a MockTerminal")
+	}
+	actual := fmt.Sprintf("%s\n-- cursor %d,%d %s\n",
+		term.text(), term.cursorX, term.cursorY, cursorShapeNames[term.cursorShape])
+	for _, row := range term.highlights() {
+		actual += "-- highlight " + row + "\n"
+	}
+
+	path := filepath.Join("testdata", "screens", golden+".txt")
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, which -update writes: %v", err)
+	}
+
+	if string(expected) != actual {
+		failWithStackTrace(t, "Screen %s:\nWanted:\n%s\nGot:\n%s", golden, string(expected), actual)
+	}
+}
+
 func testingProgramFromBuf(buf string) Program[MockTerminal] {
+	return testingProgramWithSize(buf, 100, 100)
+}
+
+// Making a program whose terminal is a given size, which
+// keeps the screens that tests assert against small
+func testingProgramWithSize(buf string, rows, cols int) Program[MockTerminal] {
+	return testingProgramWithTerm(buf, newMockTerminal(rows, cols))
+}
+
+func testingProgramWithTerm[T Terminal](buf string, term T) Program[T] {
 	lines := []BufferLine{}
 
 	for _, content := range strings.Split(buf, "\n") {
@@ -64,13 +169,16 @@ func testingProgramFromBuf(buf string) Program[MockTerminal] {
 		},
 	}
 
-	program := Program[MockTerminal]{
+	program := Program[T]{
 		logger:   getLogger("./logfile_test.log.txt"),
 		state:    ProgramState{},
-		term:     MockTerminal{},
+		term:     term,
 		settings: defaultSettings(),
 	}
 
+	// Keeping tests from writing undo history outside of their temp dirs
+	program.settings.undofile = false
+
 	program.state.buffers = buffers
 	initializeState(&program)
 	return program
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Text objects select text by its structure around the cursor, like a
+// word or a quoted string. They can only be used after an operator,
+// where they stand in for a motion, like `diw`.
+type TextObject struct {
+	selectRange func(m *MotionContext, count int) (TextRange, bool)
+}
+
+var TextObjects = map[string]TextObject{
+	"iw": {selectRange: selectWord(false, false)},
+	"aw": {selectRange: selectWord(false, true)},
+	"iW": {selectRange: selectWord(true, false)},
+	"aW": {selectRange: selectWord(true, true)},
+	"is": {selectRange: selectSentence(false)},
+	"as": {selectRange: selectSentence(true)},
+	"ip": {selectRange: selectParagraph(false)},
+	"ap": {selectRange: selectParagraph(true)},
+
+	`i"`: {selectRange: selectQuote('"', false)},
+	`a"`: {selectRange: selectQuote('"', true)},
+	"i'": {selectRange: selectQuote('\'', false)},
+	"a'": {selectRange: selectQuote('\'', true)},
+	"i`": {selectRange: selectQuote('`', false)},
+	"a`": {selectRange: selectQuote('`', true)},
+
+	"i(": {selectRange: selectBracket('(', ')', false)},
+	"a(": {selectRange: selectBracket('(', ')', true)},
+	"i)": {selectRange: selectBracket('(', ')', false)},
+	"a)": {selectRange: selectBracket('(', ')', true)},
+	"ib": {selectRange: selectBracket('(', ')', false)},
+	"ab": {selectRange: selectBracket('(', ')', true)},
+	"i[": {selectRange: selectBracket('[', ']', false)},
+	"a[": {selectRange: selectBracket('[', ']', true)},
+	"i]": {selectRange: selectBracket('[', ']', false)},
+	"a]": {selectRange: selectBracket('[', ']', true)},
+	"i{": {selectRange: selectBracket('{', '}', false)},
+	"a{": {selectRange: selectBracket('{', '}', true)},
+	"i}": {selectRange: selectBracket('{', '}', false)},
+	"a}": {selectRange: selectBracket('{', '}', true)},
+	"iB": {selectRange: selectBracket('{', '}', false)},
+	"aB": {selectRange: selectBracket('{', '}', true)},
+	"i<": {selectRange: selectBracket('<', '>', false)},
+	"a<": {selectRange: selectBracket('<', '>', true)},
+	"i>": {selectRange: selectBracket('<', '>', false)},
+	"a>": {selectRange: selectBracket('<', '>', true)},
+
+	"it": {selectRange: selectTag(false)},
+	"at": {selectRange: selectTag(true)},
+}
+
+func isTextObjectPrefix(keys string) bool {
+	for candidate := range TextObjects {
+		if len(candidate) > len(keys) && strings.HasPrefix(candidate, keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// An inclusive charwise range between two positions
+func inclusiveRange(start, end Position) TextRange {
+	return newTextRange(start, end, Charwise, true)
+}
+
+// An empty range, which lets `c` insert where there is nothing to change
+func emptyRange(pos Position) TextRange {
+	return newTextRange(pos, pos, Charwise, false)
+}
+
+// A run of chars of the same class within a line, from start to end inclusive
+type classRun struct {
+	start, end int
+	class      int
+}
+
+func classRuns(line []rune, bigWord bool) []classRun {
+	runs := []classRun{}
+	for x, r := range line {
+		class := charClass(r, bigWord)
+		if len(runs) > 0 && runs[len(runs)-1].class == class {
+			runs[len(runs)-1].end = x
+			continue
+		}
+		runs = append(runs, classRun{x, x, class})
+	}
+	return runs
+}
+
+// Selecting count words, or runs of blanks, within the cursor's line.
+// Around a word, blanks after it are included, or the blanks before
+// it if there are none after. Around blanks, the next word is included.
+func selectWord(bigWord bool, around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		y := m.cursor.y
+		line := m.line(y)
+		if len(line) == 0 {
+			return TextRange{}, false
+		}
+
+		runs := classRuns(line, bigWord)
+		first := 0
+		for i, run := range runs {
+			if run.start <= m.cursor.x && m.cursor.x <= run.end {
+				first = i
+			}
+		}
+		if m.cursor.x >= len(line) {
+			first = len(runs) - 1
+		}
+
+		last := first
+		isBlank := func(i int) bool {
+			return i < len(runs) && runs[i].class == classBlank
+		}
+
+		switch {
+		case !around:
+			last = min(first+count-1, len(runs)-1)
+
+		case isBlank(first):
+			for i := 0; i < count; i++ {
+				if i > 0 && isBlank(last+1) {
+					last++
+				}
+				if last+1 < len(runs) {
+					last++
+				}
+			}
+
+		default:
+			last = first - 1
+			for i := 0; i < count && last+1 < len(runs); i++ {
+				last++
+				if isBlank(last + 1) {
+					last++
+				}
+			}
+			if !isBlank(last) && first > 0 && isBlank(first-1) {
+				first--
+			}
+		}
+
+		return inclusiveRange(Position{runs[first].start, y}, Position{runs[last].end, y}), true
+	}
+}
+
+// The text of some lines, joined with newlines, where each
+// rune remembers the position in the buffer it came from
+type flatText struct {
+	runes     []rune
+	positions []Position
+}
+
+func (m *MotionContext) flatten(fromY, toY int) flatText {
+	f := flatText{}
+	for y := fromY; y <= toY; y++ {
+		line := m.line(y)
+		for x, r := range line {
+			f.runes = append(f.runes, r)
+			f.positions = append(f.positions, Position{x, y})
+		}
+		if y < toY {
+			f.runes = append(f.runes, '\n')
+			f.positions = append(f.positions, Position{len(line), y})
+		}
+	}
+	return f
+}
+
+// Flattening a window of lines around the cursor, and growing it until
+// find succeeds or the window covers the whole buffer. Pairs of brackets
+// and tags are usually close together, and flattening a big buffer
+// on every call would be slow.
+func (m *MotionContext) findAroundCursor(find func(f flatText) (TextRange, bool)) (TextRange, bool) {
+	for radius := 50; ; radius *= 4 {
+		fromY := max(m.cursor.y-radius, 0)
+		toY := min(m.cursor.y+radius, m.lastLineIdx())
+
+		if r, ok := find(m.flatten(fromY, toY)); ok {
+			return r, true
+		}
+		if fromY == 0 && toY == m.lastLineIdx() {
+			return TextRange{}, false
+		}
+	}
+}
+
+// Finding the rune at a position, or the one closest after it
+func (f flatText) indexOf(pos Position) int {
+	for i, p := range f.positions {
+		if !p.isBefore(pos) {
+			return i
+		}
+	}
+	return len(f.positions) - 1
+}
+
+// Ending a range on a newline means it takes the line break with it,
+// so it ends at the start of the next line instead
+func (f flatText) rangeOf(start, end int) TextRange {
+	if f.runes[end] == '\n' {
+		return newTextRange(f.positions[start], f.positions[end+1], Charwise, false)
+	}
+	return inclusiveRange(f.positions[start], f.positions[end])
+}
+
+// Whether a line is blank, for the purpose of separating paragraphs
+func (m *MotionContext) isBlankLine(y int) bool {
+	return strings.TrimSpace(string(m.line(y))) == ""
+}
+
+// Finding the first and last lines of the run of lines around y
+// that are all blank, or all not blank
+func (m *MotionContext) lineRun(y int) (int, int) {
+	blank := m.isBlankLine(y)
+	start, end := y, y
+	for start > 0 && m.isBlankLine(start-1) == blank {
+		start--
+	}
+	for end < m.lastLineIdx() && m.isBlankLine(end+1) == blank {
+		end++
+	}
+	return start, end
+}
+
+// Selecting count paragraphs, or runs of blank lines. Around a paragraph,
+// the blank lines after it are included, or the ones before it if there
+// are none after. Around blank lines, the next paragraph is included.
+func selectParagraph(around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		start, end := m.lineRun(m.cursor.y)
+		startsBlank := m.isBlankLine(start)
+
+		runs := count
+		if around {
+			runs = count * 2
+		}
+		for i := 1; i < runs && end < m.lastLineIdx(); i++ {
+			_, end = m.lineRun(end + 1)
+		}
+
+		// Taking the blank lines before the paragraph instead
+		if around && !startsBlank && !m.isBlankLine(end) && start > 0 {
+			start, _ = m.lineRun(start - 1)
+		}
+
+		return newTextRange(Position{0, start}, Position{0, end}, Linewise, false), true
+	}
+}
+
+// Whether a sentence ends at the rune at i, which is when it is a
+// `.`, `!` or `?`, maybe followed by closing chars, then by a blank
+func sentenceEndsAt(runes []rune, i int) (int, bool) {
+	if !strings.ContainsRune(".!?", runes[i]) {
+		return i, false
+	}
+	for i+1 < len(runes) && strings.ContainsRune(`)]"'`, runes[i+1]) {
+		i++
+	}
+	if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+		return i, false
+	}
+	return i, true
+}
+
+// Splitting text into sentences, as inclusive index spans
+func sentenceSpans(runes []rune) [][2]int {
+	spans := [][2]int{}
+	start := -1
+
+	for i := 0; i < len(runes); i++ {
+		if start == -1 {
+			if !unicode.IsSpace(runes[i]) {
+				start = i
+			} else {
+				continue
+			}
+		}
+		if end, ok := sentenceEndsAt(runes, i); ok {
+			spans = append(spans, [2]int{start, end})
+			start = -1
+			i = end
+		}
+	}
+
+	// Ending the last sentence at its last non-blank
+	if start != -1 {
+		end := len(runes) - 1
+		for unicode.IsSpace(runes[end]) {
+			end--
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+
+	return spans
+}
+
+// Selecting count sentences within the cursor's paragraph, or the blanks
+// between sentences. Around a sentence, the blanks after it on the same
+// line are included, or the ones before it if there are none after.
+func selectSentence(around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		if m.isBlankLine(m.cursor.y) {
+			return TextRange{}, false
+		}
+
+		fromY, toY := m.lineRun(m.cursor.y)
+		f := m.flatten(fromY, toY)
+		spans := sentenceSpans(f.runes)
+		cursor := f.indexOf(m.cursor)
+
+		isInlineBlank := func(i int) bool {
+			return i >= 0 && i < len(f.runes) && f.runes[i] != '\n' && unicode.IsSpace(f.runes[i])
+		}
+
+		for i, span := range spans {
+			if cursor > span[1] {
+				continue
+			}
+
+			// Selecting the blanks before this sentence, and the sentence too when around
+			if cursor < span[0] {
+				start := cursor
+				for isInlineBlank(start - 1) {
+					start--
+				}
+				if !around {
+					return f.rangeOf(start, span[0]-1), true
+				}
+				return f.rangeOf(start, spans[min(i+count-1, len(spans)-1)][1]), true
+			}
+
+			start := span[0]
+			end := spans[min(i+count-1, len(spans)-1)][1]
+			if !around {
+				return f.rangeOf(start, end), true
+			}
+
+			if isInlineBlank(end + 1) {
+				for isInlineBlank(end + 1) {
+					end++
+				}
+			} else {
+				for isInlineBlank(start - 1) {
+					start--
+				}
+			}
+			return f.rangeOf(start, end), true
+		}
+
+		return TextRange{}, false
+	}
+}
+
+// Whether the rune at i is escaped by an odd number of backslashes before it
+func isEscaped(line []rune, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && line[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// Selecting a quoted string within the cursor's line. Like in vim, when
+// the cursor is on a quote, pairs are counted from the start of the line
+// to tell whether it opens or closes the string.
+func selectQuote(quote rune, around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		y := m.cursor.y
+		line := m.line(y)
+		x := m.cursor.x
+
+		quotes := []int{}
+		for i, r := range line {
+			if r == quote && !isEscaped(line, i) {
+				quotes = append(quotes, i)
+			}
+		}
+
+		open, close := -1, -1
+		for i, q := range quotes {
+			if q == x {
+				if i%2 == 0 && i+1 < len(quotes) {
+					open, close = q, quotes[i+1]
+				} else if i%2 == 1 {
+					open, close = quotes[i-1], q
+				}
+				break
+			}
+			if q < x {
+				open = q
+			} else if open != -1 {
+				close = q
+				break
+			} else if i+1 < len(quotes) {
+				// Using the first string after the cursor
+				open, close = q, quotes[i+1]
+				break
+			}
+		}
+
+		if open == -1 || close == -1 {
+			return TextRange{}, false
+		}
+
+		if !around {
+			if close == open+1 {
+				return emptyRange(Position{close, y}), true
+			}
+			return inclusiveRange(Position{open + 1, y}, Position{close - 1, y}), true
+		}
+
+		isBlank := func(i int) bool {
+			return i >= 0 && i < len(line) && unicode.IsSpace(line[i])
+		}
+		if isBlank(close + 1) {
+			for isBlank(close + 1) {
+				close++
+			}
+		} else {
+			for isBlank(open - 1) {
+				open--
+			}
+		}
+		return inclusiveRange(Position{open, y}, Position{close, y}), true
+	}
+}
+
+// Finding the count'th unmatched opening bracket at or before i,
+// and its matching closing bracket
+func findBracketPair(runes []rune, i int, open, close rune, count int) (int, int, bool) {
+	openIdx := -1
+	depth := 0
+	found := 0
+
+	for j := i; j >= 0 && openIdx == -1; j-- {
+		switch runes[j] {
+		case close:
+			// A closing bracket under the cursor belongs to the pair being searched for
+			if j != i {
+				depth++
+			}
+		case open:
+			if depth > 0 {
+				depth--
+				continue
+			}
+			found++
+			if found == count {
+				openIdx = j
+			}
+		}
+	}
+
+	if openIdx == -1 {
+		return 0, 0, false
+	}
+
+	depth = 0
+	for j := openIdx + 1; j < len(runes); j++ {
+		switch runes[j] {
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return openIdx, j, true
+			}
+			depth--
+		}
+	}
+
+	return 0, 0, false
+}
+
+// Selecting the text in a pair of brackets, which may span lines and be
+// nested in others. Inside brackets that are on lines of their own, like a
+// block of code, whole lines are selected, keeping the brackets' lines.
+func selectBracket(open, close rune, around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		return m.findAroundCursor(func(f flatText) (TextRange, bool) {
+			return selectBracketIn(f, m.cursor, open, close, around, count)
+		})
+	}
+}
+
+func selectBracketIn(f flatText, cursor Position, open, close rune, around bool, count int) (TextRange, bool) {
+	if len(f.runes) == 0 {
+		return TextRange{}, false
+	}
+
+	openIdx, closeIdx, ok := findBracketPair(f.runes, f.indexOf(cursor), open, close, count)
+	if !ok {
+		return TextRange{}, false
+	}
+
+	if around {
+		return f.rangeOf(openIdx, closeIdx), true
+	}
+
+	start, end := openIdx+1, closeIdx-1
+	startsLine := f.runes[start] == '\n'
+	if startsLine {
+		start++
+	}
+
+	endsLine := false
+	for j := end; j > openIdx; j-- {
+		if f.runes[j] == '\n' {
+			endsLine = j >= start
+			if endsLine {
+				end = j - 1
+			}
+			break
+		}
+		if !unicode.IsSpace(f.runes[j]) {
+			break
+		}
+	}
+
+	if start > end {
+		return emptyRange(f.positions[openIdx+1]), true
+	}
+
+	if startsLine && endsLine {
+		return newTextRange(f.positions[start], f.positions[end], Linewise, false), true
+	}
+	return f.rangeOf(start, end), true
+}
+
+// The spans of an opening tag and its closing tag
+type tagPair struct {
+	openStart, openEnd   int
+	closeStart, closeEnd int
+}
+
+func isTagNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(":_.-", r)
+}
+
+// Finding the pairs of matching tags, skipping self-closing tags,
+// comments, and closing tags that don't match anything
+func tagPairs(runes []rune) []tagPair {
+	type openTag struct {
+		name       string
+		start, end int
+	}
+
+	pairs := []tagPair{}
+	stack := []openTag{}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '<' {
+			continue
+		}
+
+		j := i + 1
+		isClosing := j < len(runes) && runes[j] == '/'
+		if isClosing {
+			j++
+		}
+
+		nameStart := j
+		for j < len(runes) && isTagNameRune(runes[j]) {
+			j++
+		}
+		name := string(runes[nameStart:j])
+		if name == "" {
+			continue
+		}
+
+		for j < len(runes) && runes[j] != '>' && runes[j] != '<' {
+			j++
+		}
+		if j >= len(runes) || runes[j] != '>' {
+			continue
+		}
+
+		switch {
+		case runes[j-1] == '/':
+			// Self-closing tags have no contents
+		case !isClosing:
+			stack = append(stack, openTag{name, i, j})
+		default:
+			for k := len(stack) - 1; k >= 0; k-- {
+				if stack[k].name == name {
+					pairs = append(pairs, tagPair{stack[k].start, stack[k].end, i, j})
+					stack = stack[:k]
+					break
+				}
+			}
+		}
+		i = j
+	}
+
+	return pairs
+}
+
+// Selecting the count'th innermost tag block around the cursor
+func selectTag(around bool) func(m *MotionContext, count int) (TextRange, bool) {
+	return func(m *MotionContext, count int) (TextRange, bool) {
+		return m.findAroundCursor(func(f flatText) (TextRange, bool) {
+			return selectTagIn(f, m.cursor, around, count)
+		})
+	}
+}
+
+func selectTagIn(f flatText, cursorPos Position, around bool, count int) (TextRange, bool) {
+	cursor := f.indexOf(cursorPos)
+
+	// Pairs are found innermost first, since an inner closing tag comes first
+	found := 0
+	for _, pair := range tagPairs(f.runes) {
+		if cursor < pair.openStart || cursor > pair.closeEnd {
+			continue
+		}
+		found++
+		if found < count {
+			continue
+		}
+
+		if around {
+			return f.rangeOf(pair.openStart, pair.closeEnd), true
+		}
+		if pair.closeStart == pair.openEnd+1 {
+			return emptyRange(f.positions[pair.closeStart]), true
+		}
+		return f.rangeOf(pair.openEnd+1, pair.closeStart-1), true
+	}
+
+	return TextRange{}, false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWordObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"foo bar baz", "wdiw", "foo  baz"},
+		{"foo bar baz", "wdaw", "foo baz"},
+		{"foo bar", "wdaw", "foo"},
+		{"foo bar baz", "d2aw", "baz"},
+		{"foo bar baz", "d3iw", " baz"},
+		{"foo  bar", "ldiw", "  bar"},
+		{"foo  bar", "3ldiw", "foobar"},
+		{"foo  bar", "3ldaw", "foo"},
+		{"foo.bar baz", "diW", " baz"},
+		{"foo.bar baz", "diw", ".bar baz"},
+		{"", "diw", ""},
+	})
+}
+
+func TestSentenceObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"One. Two! Three?", "wwdis", "One.  Three?"},
+		{"One. Two! Three?", "wwdas", "One. Three?"},
+		{"One. Two! Three?", "$das", "One. Two!"},
+		{"He said (hi.) Bye.", "das", "Bye."},
+		{"First one\ncontinues. Next.", "jdis", " Next."},
+		{"A. B.\n\nC.", "jjdis", "A. B.\n\n"},
+	})
+}
+
+func TestParagraphObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"a\nb\n\nc", "dip", "\nc"},
+		{"a\nb\n\nc", "dap", "c"},
+		{"a\n\nb\nc", "jjdap", "a"},
+		{"a\n\n\nb", "jdip", "a\nb"},
+		{"a\n\nb\n\nc", "d2ap", "c"},
+	})
+}
+
+func TestQuoteObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{`x = "foo bar"`, `fbdi"`, `x = ""`},
+		{`x = "foo bar" y`, `fbda"`, `x = y`},
+		{`x = "foo"`, `$da"`, `x =`},
+		{`x = "foo"`, `di"`, `x = ""`},
+		{`"a \" b" c`, `fbdi"`, `"" c`},
+		{`"a" b "c"`, `fbdi"`, `"a""c"`},
+		{`"a" "b"`, `f"di"`, `"" "b"`},
+		{`"a" "b"`, `2f"di"`, `"a" ""`},
+		{`"a" "b"`, `3f"di"`, `"a" ""`},
+		{`no quotes`, `di"`, `no quotes`},
+		{`'it''s'`, `di'`, `'''s'`},
+	})
+}
+
+func TestBracketObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"f(a, b)", "fadi(", "f()"},
+		{"f(a, b)", "fada(", "f"},
+		{"f(a, b)", "f(di)", "f()"},
+		{"f(a, b)", "$dib", "f()"},
+		{"f(g(x), y)", "fxdi(", "f(g(), y)"},
+		{"f(g(x), y)", "fxd2i(", "f()"},
+		{"f(g(x), y)", "fydi(", "f()"},
+		{"f()", "f(di(", "f()"},
+		{"(a", "$di(", "(a"},
+		{"a)", "di(", "a)"},
+		{"a[1]", "f1di[", "a[]"},
+		{"<a>", "ldi<", "<>"},
+		{"if {\n\tfoo\n\tbar\n}", "jdi{", "if {\n}"},
+		{"if {\n\tfoo\n}", "jda{", "if "},
+		{"f(a,\n  b)", "jdi(", "f()"},
+	})
+}
+
+func TestTagObjects(t *testing.T) {
+	runBufferTests(t, []bufferTest{
+		{"<b>bold</b>", "fodit", "<b></b>"},
+		{"<b>bold</b>", "fodat", ""},
+		{"<a><b>x</b></a>", "fxdit", "<a><b></b></a>"},
+		{"<a><b>x</b></a>", "fxd2it", "<a></a>"},
+		{`<a href="x">link</a>`, "fldit", `<a href="x"></a>`},
+		{"<a>x<br/>y</a>", "fydit", "<a></a>"},
+		{"<div>\n  text\n</div>", "jdit", "<div></div>"},
+		{"<a>x</b>", "fxdit", "<a>x</b>"},
+		{"<a><!-- c -->x</a>", "fxdit", "<a></a>"},
+	})
+}
+
+// Pairs that are further apart than the first window of lines
+// that's searched are still found, as the window grows
+func TestTextObjectsSpanningManyLines(t *testing.T) {
+	body := strings.Repeat("x\n", 200)
+
+	p := testingProgramFromBuf("{\n" + body + "}")
+	p.processKeys("100Gdi{")
+	p.assertBufferContent(t, "{", "}")
+
+	p = testingProgramFromBuf("<div>\n" + body + "</div>\n{")
+	p.processKeys("100Gdat")
+	p.assertBufferContent(t, "", "{")
+}
+
+func TestChangeTextObject(t *testing.T) {
+	p := testingProgramFromBuf(`x = "foo"`)
+	p.processKeys("ci\"bar\x1b")
+	p.assertBufferContent(t, `x = "bar"`)
+
+	p = testingProgramFromBuf("f()")
+	p.processKeys("f(ci(x\x1b")
+	p.assertBufferContent(t, "f(x)")
+}
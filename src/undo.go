@@ -0,0 +1,290 @@
+package main
+
+type LineChangeKind int
+
+const (
+	LineInserted LineChangeKind = iota
+	LineRemoved
+	LineUpdated
+)
+
+// A single reversible mutation of Buffer.lines
+type LineChange struct {
+	kind    LineChangeKind
+	lineNum int
+	before  string
+	after   string
+}
+
+// A node in the undo tree. Each state is reached from its parent by
+// applying its changes, and left towards the parent by reverting them.
+type UndoState struct {
+	seq          int
+	parent       *UndoState
+	children     []*UndoState
+	changes      []LineChange
+	cursorBefore Position
+
+	// The child that redo follows, which is the most recently visited one
+	redoChild *UndoState
+}
+
+// Keeping every undo step as a tree, so that making a change after
+// undoing starts a new branch instead of throwing the redo path away
+type UndoTree struct {
+	root    *UndoState
+	current *UndoState
+
+	// Every state, indexed by seq, for moving through time with g- and g+
+	states []*UndoState
+
+	// Changes made since the last commit, and the cursor before them
+	pending       []LineChange
+	pendingCursor Position
+
+	// The state that was last written to disk
+	savedSeq int
+
+	// Set while undoing or redoing, so replayed changes aren't recorded
+	applying bool
+}
+
+func (u *UndoTree) init() {
+	if u.root == nil {
+		u.root = &UndoState{}
+		u.current = u.root
+		u.states = []*UndoState{u.root}
+	}
+}
+
+func (u *UndoTree) record(change LineChange) {
+	if u.applying {
+		return
+	}
+	u.pending = append(u.pending, change)
+}
+
+// Remembering where the cursor is before the next group of changes
+func (u *UndoTree) markCursor(cursor Position) {
+	if len(u.pending) == 0 {
+		u.pendingCursor = cursor
+	}
+}
+
+// Turning the pending changes into one undo step
+func (u *UndoTree) commit() {
+	u.init()
+
+	if len(u.pending) == 0 {
+		return
+	}
+
+	state := &UndoState{
+		seq:          len(u.states),
+		parent:       u.current,
+		changes:      u.pending,
+		cursorBefore: u.pendingCursor,
+	}
+
+	u.current.children = append(u.current.children, state)
+	u.current.redoChild = state
+	u.current = state
+	u.states = append(u.states, state)
+	u.pending = nil
+}
+
+func (b *Buffer) revertState(state *UndoState) {
+	b.history.applying = true
+	defer func() { b.history.applying = false }()
+
+	for i := len(state.changes) - 1; i >= 0; i-- {
+		change := state.changes[i]
+		switch change.kind {
+		case LineInserted:
+			b.removeLine(change.lineNum)
+		case LineRemoved:
+			b.insertLine(change.lineNum, change.before)
+		case LineUpdated:
+			b.updateLine(change.lineNum, change.before)
+		}
+	}
+}
+
+func (b *Buffer) applyState(state *UndoState) {
+	b.history.applying = true
+	defer func() { b.history.applying = false }()
+
+	for _, change := range state.changes {
+		switch change.kind {
+		case LineInserted:
+			b.insertLine(change.lineNum, change.after)
+		case LineRemoved:
+			b.removeLine(change.lineNum)
+		case LineUpdated:
+			b.updateLine(change.lineNum, change.after)
+		}
+	}
+}
+
+// Moving one step towards the root. Returning the cursor to restore.
+func (b *Buffer) undo() (Position, bool) {
+	u := &b.history
+	u.init()
+
+	if u.current == u.root {
+		return Position{}, false
+	}
+
+	state := u.current
+	b.revertState(state)
+	u.current = state.parent
+	u.current.redoChild = state
+	b.modified = u.current.seq != u.savedSeq
+
+	return state.cursorBefore, true
+}
+
+// Moving one step away from the root, along the most recent branch
+func (b *Buffer) redo() (Position, bool) {
+	u := &b.history
+	u.init()
+
+	state := u.current.redoChild
+	if state == nil {
+		return Position{}, false
+	}
+
+	b.applyState(state)
+	u.current = state
+	b.modified = u.current.seq != u.savedSeq
+
+	return state.cursorBefore, true
+}
+
+// Moving through the tree to the state with the given seq, undoing up to
+// the closest common ancestor, and redoing down to the target from there
+func (b *Buffer) gotoUndoSeq(seq int) (Position, bool) {
+	u := &b.history
+	u.init()
+
+	if seq < 0 || seq >= len(u.states) || seq == u.current.seq {
+		return Position{}, false
+	}
+
+	target := u.states[seq]
+
+	// Collecting the path from the root down to the target
+	targetPath := []*UndoState{}
+	isOnTargetPath := map[*UndoState]bool{}
+	for state := target; state != nil; state = state.parent {
+		targetPath = append([]*UndoState{state}, targetPath...)
+		isOnTargetPath[state] = true
+	}
+
+	cursor := u.current.cursorBefore
+
+	for !isOnTargetPath[u.current] {
+		cursor, _ = b.undo()
+	}
+
+	for i, state := range targetPath {
+		if state == u.current {
+			for _, next := range targetPath[i+1:] {
+				u.current.redoChild = next
+				cursor, _ = b.redo()
+			}
+			break
+		}
+	}
+
+	return cursor, true
+}
+
+func (b *Buffer) markSaved() {
+	b.history.init()
+	b.history.savedSeq = b.history.current.seq
+}
+
+func (prog *Program[T]) restoreCursorAfterUndo(cursor Position) {
+	buffer := prog.getActiveBuffer()
+	y := max(min(cursor.y, len(buffer.lines)-1), 0)
+	x := max(min(cursor.x, len([]rune(buffer.lineContent(y)))-1), 0)
+	prog.setLogicalCursorPosition(x, y)
+	prog.scrollToCursor()
+}
+
+func (prog *Program[T]) undo(count int) {
+	buffer := prog.getActiveBuffer()
+
+	for i := 0; i < count; i++ {
+		cursor, ok := buffer.undo()
+		if !ok {
+			prog.setStatus("Already at oldest change")
+			return
+		}
+		prog.restoreCursorAfterUndo(cursor)
+	}
+}
+
+func (prog *Program[T]) redo(count int) {
+	buffer := prog.getActiveBuffer()
+
+	for i := 0; i < count; i++ {
+		cursor, ok := buffer.redo()
+		if !ok {
+			prog.setStatus("Already at newest change")
+			return
+		}
+		prog.restoreCursorAfterUndo(cursor)
+	}
+}
+
+// Moving backwards or forwards in time, regardless of branches
+func (prog *Program[T]) undoChronologically(steps int) {
+	buffer := prog.getActiveBuffer()
+	buffer.history.init()
+
+	target := buffer.history.current.seq + steps
+	if target < 0 {
+		prog.setStatus("Already at oldest change")
+		return
+	}
+	if target >= len(buffer.history.states) {
+		prog.setStatus("Already at newest change")
+		return
+	}
+
+	if cursor, ok := buffer.gotoUndoSeq(target); ok {
+		prog.restoreCursorAfterUndo(cursor)
+	}
+}
+
+// Recording the cursor before dispatching an input,
+// in case the input starts a new group of changes
+func (prog *Program[T]) markUndoCursor() {
+	panel := prog.getActivePanel()
+	buffer := prog.getActiveBuffer()
+	buffer.history.markCursor(Position{panel.logicalCursorX, panel.logicalCursorY})
+}
+
+// Closing the current undo step of every buffer. This is only done
+// in normal mode, so a whole insert mode session becomes one step.
+func (prog *Program[T]) commitUndoSteps() {
+	if prog.state.currentMode != NormalMode {
+		return
+	}
+
+	panel := prog.getActivePanel()
+
+	for i := range prog.state.buffers {
+		buffer := &prog.state.buffers[i]
+		cursor := buffer.history.pendingCursor
+		if i == panel.bufferIdx {
+			cursor = Position{panel.logicalCursorX, panel.logicalCursorY}
+		}
+		if len(buffer.history.pending) > 0 {
+			buffer.recordChange(cursor)
+		}
+		buffer.history.commit()
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Bumping this whenever the format changes, so old files are ignored
+const undoFileVersion = 1
+
+type undoFileData struct {
+	Version     int             `json:"version"`
+	Path        string          `json:"path"`
+	ContentHash string          `json:"contentHash"`
+	Current     int             `json:"current"`
+	States      []undoFileState `json:"states"`
+}
+
+type undoFileState struct {
+	Parent       int              `json:"parent"`
+	RedoChild    int              `json:"redoChild"`
+	Changes      []undoFileChange `json:"changes"`
+	CursorBefore [2]int           `json:"cursorBefore"`
+}
+
+type undoFileChange struct {
+	Kind    LineChangeKind `json:"kind"`
+	LineNum int            `json:"line"`
+	Before  string         `json:"before,omitempty"`
+	After   string         `json:"after,omitempty"`
+}
+
+func defaultUndoDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "holovim", "undo")
+}
+
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Naming undo files after a hash of the absolute path of the file they
+// belong to, so files with the same name in different places don't clash
+func undoFilePath(undoDir string, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	sum := sha256.Sum256([]byte(absPath))
+	return filepath.Join(undoDir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func (b *Buffer) writeUndoFile(undoDir string) error {
+	u := &b.history
+	u.init()
+
+	absPath, err := filepath.Abs(b.filepath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", b.filepath, err)
+	}
+
+	data := undoFileData{
+		Version:     undoFileVersion,
+		Path:        absPath,
+		ContentHash: hashContent(b.contentBytes()),
+		Current:     u.current.seq,
+	}
+
+	for _, state := range u.states {
+		saved := undoFileState{
+			Parent:       -1,
+			RedoChild:    -1,
+			CursorBefore: [2]int{state.cursorBefore.x, state.cursorBefore.y},
+		}
+		if state.parent != nil {
+			saved.Parent = state.parent.seq
+		}
+		if state.redoChild != nil {
+			saved.RedoChild = state.redoChild.seq
+		}
+		for _, change := range state.changes {
+			saved.Changes = append(saved.Changes, undoFileChange{
+				Kind:    change.kind,
+				LineNum: change.lineNum,
+				Before:  change.before,
+				After:   change.after,
+			})
+		}
+		data.States = append(data.States, saved)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode undo history: %w", err)
+	}
+
+	path, err := undoFilePath(undoDir, b.filepath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(undoDir, 0700); err != nil {
+		return fmt.Errorf("failed to create undo directory %s: %w", undoDir, err)
+	}
+
+	return writeFileAtomic(path, encoded)
+}
+
+// Replacing the buffer's history with the one saved next to its file.
+// Returning false when there is no usable history, either because
+// there isn't one, or because the file changed outside the editor.
+func (b *Buffer) readUndoFile(undoDir string) (bool, error) {
+	path, err := undoFilePath(undoDir, b.filepath)
+	if err != nil {
+		return false, err
+	}
+
+	encoded, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read undo file %s: %w", path, err)
+	}
+
+	var data undoFileData
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return false, fmt.Errorf("corrupted undo file %s: %w", path, err)
+	}
+
+	if data.Version != undoFileVersion {
+		return false, nil
+	}
+
+	absPath, err := filepath.Abs(b.filepath)
+	if err != nil || data.Path != absPath {
+		return false, nil
+	}
+
+	if data.ContentHash != hashContent(b.contentBytes()) {
+		return false, nil
+	}
+
+	history, err := data.toUndoTree(len(b.lines))
+	if err != nil {
+		return false, fmt.Errorf("corrupted undo file %s: %w", path, err)
+	}
+
+	b.history = history
+	return true, nil
+}
+
+func (data *undoFileData) toUndoTree(lineCount int) (UndoTree, error) {
+	if len(data.States) == 0 || data.States[0].Parent != -1 {
+		return UndoTree{}, fmt.Errorf("missing root state")
+	}
+
+	if data.Current < 0 || data.Current >= len(data.States) {
+		return UndoTree{}, fmt.Errorf("current state %d out of range", data.Current)
+	}
+
+	states := make([]*UndoState, len(data.States))
+	for seq, saved := range data.States {
+		state := &UndoState{
+			seq:          seq,
+			cursorBefore: Position{saved.CursorBefore[0], saved.CursorBefore[1]},
+		}
+		for _, change := range saved.Changes {
+			if change.Kind < LineInserted || change.Kind > LineUpdated {
+				return UndoTree{}, fmt.Errorf("unknown change kind %d", change.Kind)
+			}
+			state.changes = append(state.changes, LineChange{
+				kind:    change.Kind,
+				lineNum: change.LineNum,
+				before:  change.Before,
+				after:   change.After,
+			})
+		}
+		states[seq] = state
+	}
+
+	// Linking states together. Parents always come before their children.
+	for seq, saved := range data.States[1:] {
+		seq += 1
+		if saved.Parent < 0 || saved.Parent >= seq {
+			return UndoTree{}, fmt.Errorf("state %d has invalid parent %d", seq, saved.Parent)
+		}
+		parent := states[saved.Parent]
+		states[seq].parent = parent
+		parent.children = append(parent.children, states[seq])
+	}
+
+	for seq, saved := range data.States {
+		if saved.RedoChild == -1 {
+			continue
+		}
+		if saved.RedoChild <= seq || saved.RedoChild >= len(states) || states[saved.RedoChild].parent != states[seq] {
+			return UndoTree{}, fmt.Errorf("state %d has invalid redo child %d", seq, saved.RedoChild)
+		}
+		states[seq].redoChild = states[saved.RedoChild]
+	}
+
+	current := states[data.Current]
+	if err := validateLineNumbers(current, nil, lineCount); err != nil {
+		return UndoTree{}, err
+	}
+
+	return UndoTree{
+		root:     states[0],
+		current:  current,
+		states:   states,
+		savedSeq: current.seq,
+	}, nil
+}
+
+// Walking the whole tree outwards from the given state, tracking how many
+// lines the buffer would have, to make sure no change points past the end
+func validateLineNumbers(state *UndoState, from *UndoState, lineCount int) error {
+	neighbours := append([]*UndoState{}, state.children...)
+	if state.parent != nil {
+		neighbours = append(neighbours, state.parent)
+	}
+
+	for _, next := range neighbours {
+		if next == from {
+			continue
+		}
+
+		count := lineCount
+		var err error
+
+		if next == state.parent {
+			count, err = countLinesReverting(state.changes, count)
+		} else {
+			count, err = countLinesApplying(next.changes, count)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := validateLineNumbers(next, state, count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func countLinesApplying(changes []LineChange, count int) (int, error) {
+	for _, change := range changes {
+		limit := count
+		if change.kind == LineInserted {
+			limit = count + 1
+		}
+		if change.lineNum < 0 || change.lineNum >= limit {
+			return 0, fmt.Errorf("change to line %d is out of range", change.lineNum)
+		}
+
+		switch change.kind {
+		case LineInserted:
+			count++
+		case LineRemoved:
+			count--
+		}
+	}
+	return count, nil
+}
+
+func countLinesReverting(changes []LineChange, count int) (int, error) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		limit := count
+		if change.kind == LineRemoved {
+			limit = count + 1
+		}
+		if change.lineNum < 0 || change.lineNum >= limit {
+			return 0, fmt.Errorf("change to line %d is out of range", change.lineNum)
+		}
+
+		switch change.kind {
+		case LineInserted:
+			count--
+		case LineRemoved:
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (prog *Program[T]) undoDir() string {
+	if prog.settings.undodir != "" {
+		return prog.settings.undodir
+	}
+	return defaultUndoDir()
+}
+
+// Restoring the undo history of a freshly loaded buffer, if there is one
+func (prog *Program[T]) loadUndoFile(buffer *Buffer) {
+	if !prog.settings.undofile {
+		return
+	}
+
+	if _, err := buffer.readUndoFile(prog.undoDir()); err != nil {
+		prog.setError(fmt.Errorf("Ignoring undo history: %w", err))
+	}
+}
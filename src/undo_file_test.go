@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testingProgramWithUndoFile(t *testing.T, content string) (Program[MockTerminal], string, string) {
+	p, path := testingProgramFromFile(t, content)
+	undoDir := filepath.Join(t.TempDir(), "undo")
+	p.settings.undofile = true
+	p.settings.undodir = undoDir
+	return p, path, undoDir
+}
+
+// Opening a file again, the way a restarted editor would
+func reopenWithUndoFile(t *testing.T, path, undoDir string) Program[MockTerminal] {
+	p := testingProgramFromBuf("")
+	p.settings.undofile = true
+	p.settings.undodir = undoDir
+
+	if err := p.editFile(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestUndoHistoryRoundTrip(t *testing.T) {
+	p, path, undoDir := testingProgramWithUndoFile(t, "abc\n")
+	p.processKeys("ix\x1bay\x1b:w\n")
+	assertFileContent(t, path, "xyabc\n")
+
+	reopened := reopenWithUndoFile(t, path, undoDir)
+	reopened.assertBufferContent(t, "xyabc")
+
+	reopened.processKeys("u")
+	reopened.assertBufferContent(t, "xabc")
+	reopened.processKeys("u")
+	reopened.assertBufferContent(t, "abc")
+	reopened.processInputs(RuneCtrlR, RuneCtrlR)
+	reopened.assertBufferContent(t, "xyabc")
+}
+
+func TestUndoHistoryKeepsBranches(t *testing.T) {
+	p, path, undoDir := testingProgramWithUndoFile(t, "abc\n")
+	p.processKeys("ix\x1bu")
+	p.processKeys("iy\x1b:w\n")
+
+	reopened := reopenWithUndoFile(t, path, undoDir)
+	reopened.processKeys("g-")
+	reopened.assertBufferContent(t, "xabc")
+}
+
+func TestStaleUndoHistoryIsDiscarded(t *testing.T) {
+	p, path, undoDir := testingProgramWithUndoFile(t, "abc\n")
+	p.processKeys("ix\x1b:w\n")
+
+	// Changing the file outside the editor
+	os.WriteFile(path, []byte("changed\n"), 0644)
+
+	reopened := reopenWithUndoFile(t, path, undoDir)
+	reopened.processKeys("u")
+	reopened.assertBufferContent(t, "changed")
+
+	if reopened.state.statusIsError {
+		t.Errorf("expected stale history to be discarded quietly, got `%s`", reopened.state.statusMessage)
+	}
+}
+
+func TestCorruptedUndoHistoryIsReported(t *testing.T) {
+	p, path, undoDir := testingProgramWithUndoFile(t, "abc\n")
+	p.processKeys("ix\x1b:w\n")
+
+	undoPath, err := undoFilePath(undoDir, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(undoPath, []byte("{\"version\": 1, \"states\": [tru"), 0644)
+
+	reopened := reopenWithUndoFile(t, path, undoDir)
+
+	if !reopened.state.statusIsError {
+		t.Errorf("expected corrupted history to be reported")
+	}
+
+	reopened.processKeys("u")
+	reopened.assertBufferContent(t, "xabc")
+}
+
+func TestUndoHistoryWithBadLineNumbersIsRejected(t *testing.T) {
+	data := undoFileData{
+		Version: undoFileVersion,
+		Current: 1,
+		States: []undoFileState{
+			{Parent: -1, RedoChild: 1},
+			{Parent: 0, RedoChild: -1, Changes: []undoFileChange{
+				{Kind: LineInserted, LineNum: 5, After: "x"},
+			}},
+		},
+	}
+
+	if _, err := data.toUndoTree(1); err == nil {
+		t.Errorf("expected a change past the end of the buffer to be rejected")
+	}
+}
+
+func TestUndoHistoryWithBadParentsIsRejected(t *testing.T) {
+	data := undoFileData{
+		Version: undoFileVersion,
+		Current: 1,
+		States: []undoFileState{
+			{Parent: -1, RedoChild: -1},
+			{Parent: 1, RedoChild: -1},
+		},
+	}
+
+	if _, err := data.toUndoTree(1); err == nil {
+		t.Errorf("expected a state that is its own parent to be rejected")
+	}
+}
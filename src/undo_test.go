@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUndoInsertSession(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ixy\x1b")
+	p.assertBufferContent(t, "xyabc")
+	p.processKeys("u")
+	p.assertBufferContent(t, "abc")
+	p.assertLogicalPos(t, 0, 0)
+}
+
+func TestEachInsertSessionIsOneStep(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ix\x1bay\x1b")
+	p.assertBufferContent(t, "xyabc")
+	p.processKeys("u")
+	p.assertBufferContent(t, "xabc")
+	p.processKeys("u")
+	p.assertBufferContent(t, "abc")
+}
+
+func TestUndoLineSplitAndJoin(t *testing.T) {
+	p := testingProgramFromBuf("abc\ndef")
+	p.processKeys("li\n\x1bji")
+	p.processInputs(RuneBackspace, RuneEscape)
+	p.assertBufferContent(t, "a", "bcdef")
+	p.processKeys("u")
+	p.assertBufferContent(t, "a", "bc", "def")
+	p.processKeys("u")
+	p.assertBufferContent(t, "abc", "def")
+}
+
+func TestRedo(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ix\x1buu")
+	p.assertBufferContent(t, "abc")
+	p.processInputs(RuneCtrlR)
+	p.assertBufferContent(t, "xabc")
+	p.processInputs(RuneCtrlR)
+	p.assertBufferContent(t, "xabc")
+}
+
+func TestUndoRestoresUnmodifiedState(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ix\x1b")
+
+	if !p.getActiveBuffer().modified {
+		t.Errorf("expected buffer to be modified")
+	}
+
+	p.processKeys("u")
+
+	if p.getActiveBuffer().modified {
+		t.Errorf("expected undo to restore the unmodified state")
+	}
+}
+
+func TestUndoBranchesAreKept(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+
+	// Making a change, undoing it, and starting a new branch
+	p.processKeys("ix\x1bu")
+	p.processKeys("iy\x1b")
+	p.assertBufferContent(t, "yabc")
+
+	// Moving back in time reaches the abandoned branch
+	p.processKeys("g-")
+	p.assertBufferContent(t, "xabc")
+	p.processKeys("g-")
+	p.assertBufferContent(t, "abc")
+
+	// Moving forward in time again
+	p.processKeys("g+")
+	p.assertBufferContent(t, "xabc")
+	p.processKeys("g+")
+	p.assertBufferContent(t, "yabc")
+}
+
+func TestRedoFollowsMostRecentBranch(t *testing.T) {
+	p := testingProgramFromBuf("abc")
+	p.processKeys("ix\x1bu")
+	p.processKeys("iy\x1bu")
+	p.processInputs(RuneCtrlR)
+	p.assertBufferContent(t, "yabc")
+}
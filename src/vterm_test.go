@@ -0,0 +1,564 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// A small VT100/xterm interpreter, which rebuilds the screen from the
+// bytes a terminal would be sent. Writing the output of ANSI into it
+// shows what a real terminal would display, escape sequences and all.
+type VTerm struct {
+	rows  int
+	cols  int
+	cells [][]rune
+
+	// Which cells were drawn in reverse video, and whether it's on
+	reversed [][]bool
+	reverse  bool
+
+	cursorX     int
+	cursorY     int
+	cursorShape CursorShape
+
+	// Set after drawing in the last column, so that the next
+	// rune wraps to the following line, like xterm does
+	pendingWrap bool
+
+	// DEC private modes that were set with `CSI ? n h`
+	privateModes map[int]bool
+
+	// Sequences that aren't understood, for tests to complain about
+	unknown []string
+
+	// Bytes of a rune or sequence that was split across writes
+	pending []byte
+}
+
+func newVTerm(rows, cols int) *VTerm {
+	vt := &VTerm{rows: rows, cols: cols, privateModes: map[int]bool{}}
+	vt.cells = make([][]rune, rows)
+	vt.reversed = make([][]bool, rows)
+	for y := range vt.cells {
+		vt.cells[y] = make([]rune, cols)
+		vt.reversed[y] = make([]bool, cols)
+		vt.eraseLine(y, 0, cols)
+	}
+	return vt
+}
+
+func (vt *VTerm) Write(p []byte) (int, error) {
+	data := append(vt.pending, p...)
+	vt.pending = nil
+
+	for len(data) > 0 {
+		n, complete := vt.consume(data)
+		if !complete {
+			vt.pending = append([]byte{}, data...)
+			break
+		}
+		data = data[n:]
+	}
+	return len(p), nil
+}
+
+// Handling the rune or escape sequence at the start of data. Returning
+// how many bytes it took, or false if data ends in the middle of it.
+func (vt *VTerm) consume(data []byte) (int, bool) {
+	if data[0] != '\x1b' {
+		if !utf8.FullRune(data) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRune(data)
+		vt.control(r)
+		return size, true
+	}
+
+	if len(data) < 2 {
+		return 0, false
+	}
+
+	switch data[1] {
+	case '[':
+		// Parameters and intermediates, up to a final byte in @ to ~
+		for i := 2; i < len(data); i++ {
+			if data[i] >= '@' && data[i] <= '~' {
+				vt.csi(string(data[2:i]), data[i])
+				return i + 1, true
+			}
+		}
+		return 0, false
+	case ']':
+		// Operating system commands, like setting the clipboard, end
+		// with BEL or ST. They don't change the screen.
+		for i := 2; i < len(data); i++ {
+			if data[i] == '\a' {
+				return i + 1, true
+			}
+			if data[i] == '\x1b' && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2, true
+			}
+		}
+		return 0, false
+	}
+
+	vt.unknown = append(vt.unknown, string(data[:2]))
+	return 2, true
+}
+
+func (vt *VTerm) control(r rune) {
+	switch r {
+	case '\r':
+		vt.cursorX = 0
+		vt.pendingWrap = false
+	case '\n':
+		vt.lineFeed()
+	case '\b':
+		vt.cursorX = max(vt.cursorX-1, 0)
+		vt.pendingWrap = false
+	case '\a':
+	default:
+		vt.print(r)
+	}
+}
+
+func (vt *VTerm) print(r rune) {
+	if vt.pendingWrap {
+		vt.cursorX = 0
+		vt.lineFeed()
+	}
+
+	// A wide rune that doesn't fit at the end of the line wraps
+	width := charWidth(r)
+	if vt.cursorX+width > vt.cols {
+		vt.cursorX = 0
+		vt.lineFeed()
+	}
+
+	// Drawing over half of a wide rune erases the other half,
+	// and the cells a wide rune covers are left holding 0
+	row := vt.cells[vt.cursorY]
+	x := vt.cursorX
+	if row[x] == 0 && x > 0 {
+		row[x-1] = ' '
+	}
+	if end := x + width; end < vt.cols && row[end] == 0 {
+		row[end] = ' '
+	}
+	for i := 0; i < width; i++ {
+		row[x+i] = 0
+		vt.reversed[vt.cursorY][x+i] = vt.reverse
+	}
+	row[x] = r
+
+	if x+width >= vt.cols {
+		vt.cursorX = vt.cols - 1
+		vt.pendingWrap = true
+	} else {
+		vt.cursorX += width
+	}
+}
+
+// Moving down a line, and scrolling everything up at the bottom
+func (vt *VTerm) lineFeed() {
+	vt.pendingWrap = false
+	if vt.cursorY < vt.rows-1 {
+		vt.cursorY++
+		return
+	}
+
+	first, firstReversed := vt.cells[0], vt.reversed[0]
+	copy(vt.cells, vt.cells[1:])
+	copy(vt.reversed, vt.reversed[1:])
+	vt.cells[vt.rows-1], vt.reversed[vt.rows-1] = first, firstReversed
+	vt.eraseLine(vt.rows-1, 0, vt.cols)
+}
+
+func (vt *VTerm) eraseLine(y, from, to int) {
+	for x := max(from, 0); x < min(to, vt.cols); x++ {
+		vt.cells[y][x] = ' '
+		vt.reversed[y][x] = false
+	}
+}
+
+// Parsing numeric parameters, where a missing one is def
+func csiParams(params string, def int) []int {
+	numbers := []int{}
+	for _, part := range strings.Split(params, ";") {
+		n, err := strconv.Atoi(part)
+		if err != nil || part == "" {
+			n = def
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// Handling a control sequence, like `ESC [ 1 ; 2 H`
+func (vt *VTerm) csi(params string, final byte) {
+	seq := "\x1b[" + params + string(final)
+
+	switch {
+	case strings.HasPrefix(params, "?") && (final == 'h' || final == 'l'):
+		for _, mode := range csiParams(params[1:], 0) {
+			vt.privateModes[mode] = final == 'h'
+		}
+		return
+	case strings.HasSuffix(params, " ") && final == 'q':
+		vt.setCursorShape(csiParams(strings.TrimSuffix(params, " "), 0)[0])
+		return
+	case strings.ContainsAny(params, "?> "):
+		vt.unknown = append(vt.unknown, seq)
+		return
+	}
+
+	args := csiParams(params, 0)
+	n := max(args[0], 1)
+	vt.pendingWrap = false
+
+	switch final {
+	case 'H', 'f':
+		args = csiParams(params, 1)
+		row, col := args[0], 1
+		if len(args) > 1 {
+			col = args[1]
+		}
+		vt.cursorY = max(min(row, vt.rows), 1) - 1
+		vt.cursorX = max(min(col, vt.cols), 1) - 1
+	case 'A':
+		vt.cursorY = max(vt.cursorY-n, 0)
+	case 'B':
+		vt.cursorY = min(vt.cursorY+n, vt.rows-1)
+	case 'C':
+		vt.cursorX = min(vt.cursorX+n, vt.cols-1)
+	case 'D':
+		vt.cursorX = max(vt.cursorX-n, 0)
+	case 'J':
+		vt.eraseDisplay(args[0])
+	case 'K':
+		switch args[0] {
+		case 0:
+			vt.eraseLine(vt.cursorY, vt.cursorX, vt.cols)
+		case 1:
+			vt.eraseLine(vt.cursorY, 0, vt.cursorX+1)
+		case 2:
+			vt.eraseLine(vt.cursorY, 0, vt.cols)
+		}
+	case 'm':
+		vt.selectGraphicRendition(args)
+	case 'n':
+		// Status reports are answered on stdin, which isn't modeled
+	default:
+		vt.unknown = append(vt.unknown, seq)
+	}
+}
+
+func (vt *VTerm) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		vt.eraseLine(vt.cursorY, vt.cursorX, vt.cols)
+		for y := vt.cursorY + 1; y < vt.rows; y++ {
+			vt.eraseLine(y, 0, vt.cols)
+		}
+	case 1:
+		for y := 0; y < vt.cursorY; y++ {
+			vt.eraseLine(y, 0, vt.cols)
+		}
+		vt.eraseLine(vt.cursorY, 0, vt.cursorX+1)
+	case 2, 3:
+		for y := 0; y < vt.rows; y++ {
+			vt.eraseLine(y, 0, vt.cols)
+		}
+	}
+}
+
+// Tracking reverse video, which is the only attribute drawn with.
+// Colors and other attributes are accepted and ignored.
+func (vt *VTerm) selectGraphicRendition(args []int) {
+	for _, arg := range args {
+		switch arg {
+		case 0:
+			vt.reverse = false
+		case 7:
+			vt.reverse = true
+		case 27:
+			vt.reverse = false
+		}
+	}
+}
+
+// DECSCUSR, where odd numbers blink and even ones don't
+func (vt *VTerm) setCursorShape(n int) {
+	switch n {
+	case 0, 1, 2:
+		vt.cursorShape = BlockCursor
+	case 3, 4:
+		vt.cursorShape = UnderlineCursor
+	case 5, 6:
+		vt.cursorShape = BarCursor
+	default:
+		vt.unknown = append(vt.unknown, "\x1b["+strconv.Itoa(n)+" q")
+	}
+}
+
+// Showing the screen as text, the same way MockTerminal does
+func (vt *VTerm) text() string {
+	lines := make([]string, vt.rows)
+	for y, row := range vt.cells {
+		lines[y] = strings.TrimRight(strings.ReplaceAll(string(row), "\x00", ""), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func TestVTermCursorPositionIsOneIndexed(t *testing.T) {
+	vt := newVTerm(3, 5)
+	vt.Write([]byte("\x1b[2;3Hab\x1b[Hc\x1b[3Hd"))
+
+	if got := vt.text(); got != "c\n  ab\nd" {
+		t.Errorf("wanted text at 1-indexed positions, got %q", got)
+	}
+	if vt.cursorX != 1 || vt.cursorY != 2 {
+		t.Errorf("wanted the cursor after d, got %d,%d", vt.cursorX, vt.cursorY)
+	}
+
+	// Positions past the edges are clamped to them
+	vt.Write([]byte("\x1b[99;99H"))
+	if vt.cursorX != 4 || vt.cursorY != 2 {
+		t.Errorf("wanted the cursor in the corner, got %d,%d", vt.cursorX, vt.cursorY)
+	}
+}
+
+func TestVTermErase(t *testing.T) {
+	fill := func() *VTerm {
+		vt := newVTerm(3, 3)
+		vt.Write([]byte("abc\r\ndef\r\nghi\x1b[2;2H"))
+		return vt
+	}
+
+	tests := []struct {
+		seq  string
+		want string
+	}{
+		{"\x1b[2J", ""},
+		{"\x1b[J", "abc\nd"},
+		{"\x1b[1J", "\n  f\nghi"},
+		{"\x1b[K", "abc\nd\nghi"},
+		{"\x1b[1K", "abc\n  f\nghi"},
+		{"\x1b[2K", "abc\n\nghi"},
+	}
+
+	for _, test := range tests {
+		vt := fill()
+		vt.Write([]byte(test.seq))
+		if got := vt.text(); got != test.want {
+			t.Errorf("%q: wanted %q, got %q", test.seq, test.want, got)
+		}
+	}
+}
+
+func TestVTermGraphicRendition(t *testing.T) {
+	vt := newVTerm(1, 6)
+	vt.Write([]byte("a\x1b[7mb\x1b[27mc\x1b[7md\x1b[0me\x1b[7;31mf"))
+
+	want := []bool{false, true, false, true, false, true}
+	for x, reversed := range want {
+		if vt.reversed[0][x] != reversed {
+			t.Errorf("column %d: wanted reversed=%v", x, reversed)
+		}
+	}
+}
+
+func TestVTermCursorShape(t *testing.T) {
+	vt := newVTerm(1, 1)
+	for seq, shape := range map[string]CursorShape{
+		"\x1b[5 q": BarCursor,
+		"\x1b[6 q": BarCursor,
+		"\x1b[4 q": UnderlineCursor,
+		"\x1b[2 q": BlockCursor,
+		"\x1b[0 q": BlockCursor,
+	} {
+		vt.cursorShape = -1
+		vt.Write([]byte(seq))
+		if vt.cursorShape != shape {
+			t.Errorf("%q: wanted shape %d, got %d", seq, shape, vt.cursorShape)
+		}
+	}
+}
+
+func TestVTermSplitWritesAndWrapping(t *testing.T) {
+	vt := newVTerm(2, 3)
+
+	// Sequences and runes split across writes
+	vt.Write([]byte("\x1b["))
+	vt.Write([]byte("2;1H\xe2"))
+	vt.Write([]byte("\x80\xbaxy"))
+	if got := vt.text(); got != "\n›xy" {
+		t.Errorf("wanted the split writes joined, got %q", got)
+	}
+
+	// Wrapping past the last column, and scrolling past the last row
+	vt.Write([]byte("z"))
+	if got := vt.text(); got != "›xy\nz" {
+		t.Errorf("wanted a wrap and a scroll, got %q", got)
+	}
+}
+
+func TestVTermIgnoresOtherSequences(t *testing.T) {
+	vt := newVTerm(1, 3)
+	vt.Write([]byte("\x1b]52;c;aGk=\x07\x1b[?2026ha\x1b[6n\x1b[?2026l"))
+
+	if got := vt.text(); got != "a" {
+		t.Errorf("wanted only the text drawn, got %q", got)
+	}
+	if vt.privateModes[2026] {
+		t.Errorf("wanted synchronized output to be turned off again")
+	}
+	if len(vt.unknown) != 0 {
+		t.Errorf("wanted every sequence understood, got %q", vt.unknown)
+	}
+
+	vt.Write([]byte("\x1b[5Z"))
+	if len(vt.unknown) != 1 {
+		t.Errorf("wanted an unknown sequence recorded")
+	}
+}
+
+// The real ANSI terminal, writing into a VTerm of the same size
+type vtermTerminal struct {
+	ANSI
+	vt *VTerm
+}
+
+func (t vtermTerminal) getSize() (rows, cols int, err error) {
+	return t.vt.rows, t.vt.cols, nil
+}
+
+func testingProgramWithVTerm(buf string, rows, cols int) (Program[vtermTerminal], *VTerm) {
+	vt := newVTerm(rows, cols)
+	return testingProgramWithTerm(buf, vtermTerminal{ANSI{out: vt}, vt}), vt
+}
+
+// Typing the same keys into a program with the real ANSI terminal, and
+// into one with MockTerminal, and checking that they show the same thing
+func TestANSIMatchesMockTerminal(t *testing.T) {
+	buf := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n\n// end"
+	steps := []string{"", "jj", "wl", "Vj", "\x1b", ":set ts=2\n", "/main\n", ":s", "\x1bG", "kkix", "\x1bu"}
+
+	mock := testingProgramWithSize(buf, 6, 20)
+	real, vt := testingProgramWithVTerm(buf, 6, 20)
+
+	for _, keys := range steps {
+		mock.processKeys(keys)
+		real.processKeys(keys)
+
+		if got, want := vt.text(), mock.term.text(); got != want {
+			t.Errorf("after %q: wanted\n%s\ngot\n%s", keys, want, got)
+		}
+		if vt.cursorX != mock.term.cursorX || vt.cursorY != mock.term.cursorY {
+			t.Errorf("after %q: wanted the cursor at %d,%d, got %d,%d",
+				keys, mock.term.cursorX, mock.term.cursorY, vt.cursorX, vt.cursorY)
+		}
+		if vt.cursorShape != mock.term.cursorShape {
+			t.Errorf("after %q: wanted cursor shape %d, got %d", keys, mock.term.cursorShape, vt.cursorShape)
+		}
+	}
+
+	if len(vt.unknown) != 0 {
+		t.Errorf("wanted only sequences that a terminal understands, got %q", vt.unknown)
+	}
+}
+
+func TestANSIHighlightIsReset(t *testing.T) {
+	p, vt := testingProgramWithVTerm("foo bar\nbaz", 5, 20)
+	p.processKeys("vw")
+
+	if !vt.reversed[1][5] || !vt.reversed[1][9] || vt.reversed[1][10] {
+		t.Errorf("wanted the selection drawn in reverse video")
+	}
+	if vt.reverse {
+		t.Errorf("wanted reverse video turned off at the end of the frame")
+	}
+
+	p.processKeys("\x1b")
+	for y, row := range vt.reversed {
+		for x, reversed := range row {
+			if reversed {
+				t.Errorf("wanted no reverse video left at %d,%d", x, y)
+			}
+		}
+	}
+}
+
+func TestANSICursorShapes(t *testing.T) {
+	p, vt := testingProgramWithVTerm("foo", 3, 10)
+
+	for _, test := range []struct {
+		keys  string
+		shape CursorShape
+	}{
+		{"i", BarCursor},
+		{"\x1b", BlockCursor},
+		{"R", UnderlineCursor},
+		{"\x1b:", BarCursor},
+		{"\x1b", BlockCursor},
+	} {
+		p.processKeys(test.keys)
+		if vt.cursorShape != test.shape {
+			t.Errorf("after %q: wanted cursor shape %d, got %d", test.keys, test.shape, vt.cursorShape)
+		}
+	}
+
+	if vt.privateModes[2026] {
+		t.Errorf("wanted every synchronized update to be finished")
+	}
+}
+
+// Wide runes take two columns on a real terminal, so the cursor,
+// highlights and later text on their line all have to line up with that
+func TestANSIWideRunes(t *testing.T) {
+	p, vt := testingProgramWithVTerm("日本語 abc\nxyz", 4, 20)
+	panel := p.getActivePanel()
+	left, top := panel.topLeftX, panel.topLeftY
+
+	tests := []struct {
+		keys    string
+		line    string
+		cursorX int
+	}{
+		{"", "日本語 abc", 0},
+		{"ll", "日本語 abc", 4},
+		{"x", "日本 abc", 4},
+		{"w", "日本 abc", 5},
+		{"u", "日本語 abc", 4},
+		{"0rX", "X本語 abc", 0},
+	}
+
+	for _, test := range tests {
+		p.processKeys(test.keys)
+		line := strings.TrimRight(strings.ReplaceAll(string(vt.cells[top][left:]), "\x00", ""), " ")
+		if line != test.line {
+			t.Errorf("after %q: wanted %q, got %q", test.keys, test.line, line)
+		}
+		if vt.cursorX != left+test.cursorX || vt.cursorY != top {
+			t.Errorf("after %q: wanted the cursor at %d,%d, got %d,%d",
+				test.keys, left+test.cursorX, top, vt.cursorX, vt.cursorY)
+		}
+	}
+
+	// Highlighting the selection covers both columns of each wide rune
+	p.processKeys("v")
+	if !vt.reversed[top][left] || vt.reversed[top][left+1] {
+		t.Errorf("wanted only the selected rune highlighted")
+	}
+	p.processKeys("l")
+	for x := left; x < left+3; x++ {
+		if !vt.reversed[top][x] {
+			t.Errorf("wanted column %d highlighted", x)
+		}
+	}
+	if vt.reversed[top][left+3] {
+		t.Errorf("wanted the column after the selection left alone")
+	}
+}
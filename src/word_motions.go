@@ -0,0 +1,316 @@
+package main
+
+import (
+	"unicode"
+)
+
+// Words are runs of keyword chars, or runs of other non-blank chars.
+// WORDs are runs of any non-blank chars. The end of a line counts as
+// whitespace, and an empty line counts as a word of its own.
+const (
+	classBlank = iota
+	classPunctuation
+	classKeyword
+)
+
+func charClass(r rune, bigWord bool) int {
+	if r == 0 || unicode.IsSpace(r) {
+		return classBlank
+	}
+	if bigWord || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return classKeyword
+	}
+	return classPunctuation
+}
+
+// Returning the rune at a position, or 0 at the end of a line
+func (m *MotionContext) charAt(pos Position) rune {
+	line := m.line(pos.y)
+	if pos.x >= len(line) {
+		return 0
+	}
+	return line[pos.x]
+}
+
+func (m *MotionContext) classAt(pos Position, bigWord bool) int {
+	return charClass(m.charAt(pos), bigWord)
+}
+
+func (m *MotionContext) isEmptyLine(y int) bool {
+	return len(m.line(y)) == 0
+}
+
+// Stepping forward one char. Returning 0 when moving within a line,
+// 2 when moving onto the end of a line, 1 when moving to the next line,
+// and -1 when already at the end of the buffer.
+func (m *MotionContext) inc(pos *Position) int {
+	length := len(m.line(pos.y))
+
+	if pos.x < length {
+		pos.x++
+		if pos.x < length {
+			return 0
+		}
+		return 2
+	}
+
+	if pos.y < m.lastLineIdx() {
+		pos.y++
+		pos.x = 0
+		return 1
+	}
+
+	return -1
+}
+
+// Stepping backward one char. Returning 0 when moving within a line,
+// 1 when moving onto the end of the previous line, and -1 when
+// already at the start of the buffer.
+func (m *MotionContext) dec(pos *Position) int {
+	if pos.x > 0 {
+		pos.x = min(pos.x, len(m.line(pos.y))) - 1
+		return 0
+	}
+
+	if pos.y > 0 {
+		pos.y--
+		pos.x = len(m.line(pos.y))
+		return 1
+	}
+
+	return -1
+}
+
+// Moving while the class under the position stays the same.
+// Returning true if the edge of the buffer was hit.
+func (m *MotionContext) skipClass(pos *Position, class int, bigWord bool, forward bool) bool {
+	for m.classAt(*pos, bigWord) == class {
+		step := m.dec
+		if forward {
+			step = m.inc
+		}
+		if step(pos) == -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Keeping a motion's target on an existing char, unless an operator is
+// using it, in which case the end of the line is a useful exclusive end
+func (m *MotionContext) settle(pos Position) Position {
+	if !m.operatorPending {
+		pos.x = min(pos.x, m.lastCharIdx(pos.y))
+	}
+	return pos
+}
+
+// Moving to the start of the next word, like `w`
+func forwardWord(m *MotionContext, count int, bigWord bool) (Position, bool) {
+	pos := m.cursor
+	stopAtEOL := m.operatorPending
+
+	for i := count - 1; i >= 0; i-- {
+		startClass := m.classAt(pos, bigWord)
+		isLastLine := pos.y == m.lastLineIdx()
+
+		// Moving at least one char, unless at the end of the buffer
+		step := m.inc(&pos)
+		if step == -1 || (step >= 1 && isLastLine) {
+			if i == count-1 {
+				return m.cursor, false
+			}
+			return m.settle(pos), true
+		}
+		if step >= 1 && stopAtEOL && i == 0 {
+			return m.settle(pos), true
+		}
+
+		// Going one char past the end of the current word
+		if startClass != classBlank {
+			for m.classAt(pos, bigWord) == startClass {
+				step = m.inc(&pos)
+				if step == -1 || (step >= 1 && stopAtEOL && i == 0) {
+					return m.settle(pos), true
+				}
+			}
+		}
+
+		// Going to the next non-blank, stopping at an empty line
+		for m.classAt(pos, bigWord) == classBlank {
+			if pos.x == 0 && m.isEmptyLine(pos.y) {
+				break
+			}
+			step = m.inc(&pos)
+			if step == -1 || (step >= 1 && stopAtEOL && i == 0) {
+				return m.settle(pos), true
+			}
+		}
+	}
+
+	return m.settle(pos), true
+}
+
+// Moving to the start of the previous word, like `b`
+func backwardWord(m *MotionContext, count int, bigWord bool) (Position, bool) {
+	pos := m.cursor
+
+	for i := 0; i < count; i++ {
+		if m.dec(&pos) == -1 {
+			if i == 0 {
+				return m.cursor, false
+			}
+			break
+		}
+
+		// Skipping blanks before the word, stopping at an empty line
+		atEmptyLine := false
+		for m.classAt(pos, bigWord) == classBlank {
+			if pos.x == 0 && m.isEmptyLine(pos.y) {
+				atEmptyLine = true
+				break
+			}
+			if m.dec(&pos) == -1 {
+				return m.settle(pos), true
+			}
+		}
+
+		if atEmptyLine {
+			continue
+		}
+
+		// Moving back to the start of this word, and then forward
+		// again, because that overshoots by one char
+		if m.skipClass(&pos, m.classAt(pos, bigWord), bigWord, false) {
+			return m.settle(pos), true
+		}
+		m.inc(&pos)
+	}
+
+	return m.settle(pos), true
+}
+
+// Moving to the end of the next word, like `e`. With stop, a cursor that
+// is already at the end of a word stays there, which `cw` relies on.
+func forwardWordEnd(m *MotionContext, count int, bigWord bool, stop bool) (Position, bool) {
+	pos := m.cursor
+
+	for i := 0; i < count; i++ {
+		startClass := m.classAt(pos, bigWord)
+
+		if m.inc(&pos) == -1 {
+			if i == 0 {
+				return m.cursor, false
+			}
+			break
+		}
+
+		if m.classAt(pos, bigWord) == startClass && startClass != classBlank {
+			// Moving to the end of the word the cursor is in
+			if m.skipClass(&pos, startClass, bigWord, true) {
+				return m.settle(pos), true
+			}
+		} else if !stop || startClass == classBlank {
+			// Skipping blanks, then moving to the end of the next word
+			for m.classAt(pos, bigWord) == classBlank {
+				if m.inc(&pos) == -1 {
+					return m.settle(pos), true
+				}
+			}
+			if m.skipClass(&pos, m.classAt(pos, bigWord), bigWord, true) {
+				return m.settle(pos), true
+			}
+		}
+
+		// Stepping back from the char that overshot
+		m.dec(&pos)
+		stop = false
+	}
+
+	return m.settle(pos), true
+}
+
+// Moving to the end of the previous word, like `ge`
+func backwardWordEnd(m *MotionContext, count int, bigWord bool) (Position, bool) {
+	pos := m.cursor
+
+	for i := 0; i < count; i++ {
+		startClass := m.classAt(pos, bigWord)
+
+		if m.dec(&pos) == -1 {
+			if i == 0 {
+				return m.cursor, false
+			}
+			break
+		}
+
+		// Moving back to before the start of this word
+		if startClass != classBlank {
+			for m.classAt(pos, bigWord) == startClass {
+				if m.dec(&pos) == -1 {
+					return m.settle(pos), true
+				}
+			}
+		}
+
+		// Moving back to the end of the previous word, stopping at an empty line
+		for m.classAt(pos, bigWord) == classBlank {
+			if pos.x == 0 && m.isEmptyLine(pos.y) {
+				break
+			}
+			if m.dec(&pos) == -1 {
+				return m.settle(pos), true
+			}
+		}
+	}
+
+	return m.settle(pos), true
+}
+
+func motionWordForward(m *MotionContext, count int) (Position, bool) {
+	return forwardWord(m, count, false)
+}
+
+func motionBigWordForward(m *MotionContext, count int) (Position, bool) {
+	return forwardWord(m, count, true)
+}
+
+func motionWordBackward(m *MotionContext, count int) (Position, bool) {
+	return backwardWord(m, count, false)
+}
+
+func motionBigWordBackward(m *MotionContext, count int) (Position, bool) {
+	return backwardWord(m, count, true)
+}
+
+func motionWordEnd(m *MotionContext, count int) (Position, bool) {
+	return forwardWordEnd(m, count, false, false)
+}
+
+func motionBigWordEnd(m *MotionContext, count int) (Position, bool) {
+	return forwardWordEnd(m, count, true, false)
+}
+
+func motionWordEndBackward(m *MotionContext, count int) (Position, bool) {
+	return backwardWordEnd(m, count, false)
+}
+
+func motionBigWordEndBackward(m *MotionContext, count int) (Position, bool) {
+	return backwardWordEnd(m, count, true)
+}
+
+// Making `cw` act like `ce` when the cursor is on a word, since
+// changing a word shouldn't also change the blanks after it
+func (m *MotionContext) changeWordMotion(bigWord bool) (Motion, bool) {
+	if m.classAt(m.cursor, bigWord) == classBlank {
+		return Motion{}, false
+	}
+
+	return Motion{
+		kind:      Charwise,
+		inclusive: true,
+		move: func(m *MotionContext, count int) (Position, bool) {
+			return forwardWordEnd(m, count, bigWord, true)
+		},
+	}, true
+}